@@ -0,0 +1,39 @@
+package simplewebsockets
+
+// What a connection attempts with a room, see WithRoomAuthorizer.
+type Action int
+
+const (
+	ActionJoin Action = iota
+	ActionLeave
+	ActionEmit
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionJoin:
+		return "join"
+	case ActionLeave:
+		return "leave"
+	case ActionEmit:
+		return "emit"
+	}
+	return "unknown"
+}
+
+// Setter to be passed into the creation of a server. fn is asked before a connection joins or leaves a room and
+// before it emits to one (Room.BroadcastFrom, Room.EmitFrom, CRDTRoom.HandleUpdate). A non-nil error is returned
+// to the caller and nothing happens. Rooms are left without asking when connections disconnect or rooms are removed.
+func WithRoomAuthorizer(fn func(c *Connection, room string, action Action) error) ServerOption {
+	return func(s *Server) {
+		s.roomAuthorizer = fn
+	}
+}
+
+// Asks the room authorizer, if there is one.
+func (r *Room) authorize(c *Connection, action Action) error {
+	if fn := r.tenant.server.roomAuthorizer; fn != nil {
+		return fn(c, r.name, action)
+	}
+	return nil
+}
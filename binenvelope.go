@@ -0,0 +1,112 @@
+package simplewebsockets
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// Returned when a binary envelope doesn't start with a valid varint event ID.
+	ErrInvalidEnvelope = errors.New("invalid binary envelope")
+	// Returned by EventRegistry for event names or IDs that weren't registered.
+	ErrUnknownEvent = errors.New("unknown event")
+)
+
+// Compact alternative to the JSON event envelope for high-frequency messages: a binary message holding
+// the event ID as an unsigned varint followed by the raw payload, so small events cost one or two bytes
+// of overhead. Appends the envelope to buf.
+func AppendBinaryEvent(buf []byte, id uint64, payload []byte) []byte {
+	buf = binary.AppendUvarint(buf, id)
+	return append(buf, payload...)
+}
+
+// Splits a binary envelope into its event ID and payload. The payload aliases data.
+func ParseBinaryEvent(data []byte) (uint64, []byte, error) {
+	id, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, ErrInvalidEnvelope
+	}
+	return id, data[n:], nil
+}
+
+// Wraps payload in a binary envelope with the event ID and sends it to every member of the room.
+func (r *Room) EmitBinary(id uint64, payload []byte) error {
+	return r.Broadcast(BinaryMessage, AppendBinaryEvent(nil, id, payload)).Err
+}
+
+// Maps the IDs of binary envelopes to event names, so handlers can keep using names while the wire
+// carries IDs. Server and clients have to agree on the mapping.
+type EventRegistry struct {
+	mx    sync.RWMutex
+	names map[uint64]string
+	ids   map[string]uint64
+}
+
+// Creates an empty registry.
+func NewEventRegistry() *EventRegistry {
+	return &EventRegistry{names: make(map[uint64]string), ids: make(map[string]uint64)}
+}
+
+// Assigns id to the event name. Fails if either is already registered.
+func (r *EventRegistry) Register(id uint64, name string) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if other, ok := r.names[id]; ok {
+		return fmt.Errorf("event id %d is already registered for %q", id, other)
+	}
+	if other, ok := r.ids[name]; ok {
+		return fmt.Errorf("event %q is already registered with id %d", name, other)
+	}
+	r.names[id] = name
+	r.ids[name] = id
+	return nil
+}
+
+// Returns the ID registered for the event name.
+func (r *EventRegistry) ID(name string) (uint64, bool) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	id, ok := r.ids[name]
+	return id, ok
+}
+
+// Returns the event name registered for id.
+func (r *EventRegistry) Name(id uint64) (string, bool) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	name, ok := r.names[id]
+	return name, ok
+}
+
+// Builds the binary envelope for a registered event.
+func (r *EventRegistry) Encode(name string, payload []byte) ([]byte, error) {
+	id, ok := r.ID(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownEvent, name)
+	}
+	return AppendBinaryEvent(nil, id, payload), nil
+}
+
+// Parses a binary envelope and looks up its event name. The payload aliases data.
+func (r *EventRegistry) Decode(data []byte) (string, []byte, error) {
+	id, payload, err := ParseBinaryEvent(data)
+	if err != nil {
+		return "", nil, err
+	}
+	name, ok := r.Name(id)
+	if !ok {
+		return "", nil, fmt.Errorf("%w: id %d", ErrUnknownEvent, id)
+	}
+	return name, payload, nil
+}
+
+// Sends a registered event to every member of the room in a binary envelope.
+func (r *EventRegistry) Emit(room *Room, name string, payload []byte) error {
+	data, err := r.Encode(name, payload)
+	if err != nil {
+		return err
+	}
+	return room.Broadcast(BinaryMessage, data).Err
+}
@@ -0,0 +1,80 @@
+package simplewebsockets_test
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+func TestBinaryEvent(t *testing.T) {
+	for _, tt := range []struct {
+		id   uint64
+		wire []byte
+	}{
+		{1, []byte{0x01, 'h', 'i'}},
+		{127, []byte{0x7f, 'h', 'i'}},
+		{300, []byte{0xac, 0x02, 'h', 'i'}}, // two varint bytes from 128 on
+	} {
+		wire := simplewebsockets.AppendBinaryEvent(nil, tt.id, []byte("hi"))
+		if !bytes.Equal(wire, tt.wire) {
+			t.Errorf("event %d encoded as % x, want % x", tt.id, wire, tt.wire)
+		}
+		id, payload, err := simplewebsockets.ParseBinaryEvent(wire)
+		if err != nil || id != tt.id || string(payload) != "hi" {
+			t.Errorf("% x parsed as %d %q (%v), want %d \"hi\"", wire, id, payload, err, tt.id)
+		}
+	}
+
+	for _, wire := range [][]byte{nil, {0x80}, {0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}} {
+		if _, _, err := simplewebsockets.ParseBinaryEvent(wire); !errors.Is(err, simplewebsockets.ErrInvalidEnvelope) {
+			t.Errorf("parsing % x: %v, want ErrInvalidEnvelope", wire, err)
+		}
+	}
+}
+
+func TestEventRegistry(t *testing.T) {
+	r := simplewebsockets.NewEventRegistry()
+	if err := r.Register(1, "move"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := r.Register(1, "shoot"); err == nil {
+		t.Error("registered a taken id")
+	}
+	if err := r.Register(2, "move"); err == nil {
+		t.Error("registered a taken name")
+	}
+	if _, err := r.Encode("shoot", nil); !errors.Is(err, simplewebsockets.ErrUnknownEvent) {
+		t.Errorf("encoding an unregistered event: %v, want ErrUnknownEvent", err)
+	}
+	if _, _, err := r.Decode([]byte{0x02}); !errors.Is(err, simplewebsockets.ErrUnknownEvent) {
+		t.Errorf("decoding an unregistered id: %v, want ErrUnknownEvent", err)
+	}
+
+	sim := wstest.NewSimulation(1999)
+	conns := make(chan *simplewebsockets.Connection, 1)
+	sim.Server.OnConnect(func(c *simplewebsockets.Connection) { conns <- c })
+	c, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Abort()
+	room := sim.Server.Room("arena")
+	if err := room.Join(<-conns); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	if err := r.Emit(room, "move", []byte{3, 4}); err != nil {
+		t.Fatalf("emit: %v", err)
+	}
+	op, msg, err := c.NextMessage()
+	if err != nil || op != byte(simplewebsockets.BinaryMessage) {
+		t.Fatalf("got opcode %x (%v), want a binary message", op, err)
+	}
+	if name, payload, err := r.Decode(msg); err != nil || name != "move" || !bytes.Equal(payload, []byte{3, 4}) {
+		t.Errorf("% x decoded as %q % x (%v), want the move event", msg, name, payload, err)
+	}
+}
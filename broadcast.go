@@ -0,0 +1,182 @@
+package simplewebsockets
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Default number of concurrent writers per broadcast.
+const defaultBroadcastWorkers = 64
+
+// Outcome of a broadcast. Connections that are closed or read-only are skipped rather than failed.
+type BroadcastResult struct {
+	Sent    int
+	Failed  int
+	Skipped int
+	Err     error // the write errors of the failed connections, joined
+}
+
+// Setter to be passed into the creation of a server. Broadcasts write to at most n connections at a time,
+// so one slow consumer only holds up a single writer. 1 sends to one connection after another.
+func WithBroadcastWorkers(n int) ServerOption {
+	return func(s *Server) {
+		s.broadcastWorkers = n
+	}
+}
+
+// Setter to be passed into the creation of a server. A broadcast write that takes longer than d fails
+// and drops the connection, since a partially written frame leaves it unusable. 0 means no deadline.
+func WithBroadcastWriteTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.broadcastWriteTimeout = d
+	}
+}
+
+// Sends a message to every member of the room and reports how many connections received it.
+func (r *Room) Broadcast(mt MessageType, data []byte) BroadcastResult {
+	return r.broadcast(mt, data, nil)
+}
+
+// sends a message to every member of the room but except, which may be nil
+func (r *Room) broadcast(mt MessageType, data []byte, except *Connection) BroadcastResult {
+	if s := r.tenant.server; s.shouldShed(r.Priority()) {
+		s.metrics.shedBroadcasts.Add(1)
+		return BroadcastResult{Skipped: r.Len(), Err: ErrLoadShed}
+	}
+
+	r.sendMx.Lock()
+	defer r.sendMx.Unlock()
+
+	if err := r.retain(mt, data); err != nil {
+		return BroadcastResult{Err: err}
+	}
+	members := r.Members()
+	if except != nil {
+		for i, c := range members {
+			if c == except {
+				members = append(members[:i], members[i+1:]...)
+				break
+			}
+		}
+	}
+	return broadcast(r.tenant.server, members, mt, data)
+}
+
+// Sends a message to every connection of the tenant and reports how many connections received it.
+func (t *Tenant) Broadcast(mt MessageType, data []byte) BroadcastResult {
+	return broadcast(t.server, t.Connections(), mt, data)
+}
+
+// Sends a message to every connection of the server, across all tenants, and reports how many connections
+// received it.
+func (s *Server) Broadcast(mt MessageType, data []byte) BroadcastResult {
+	s.connectionsMx.RLock()
+	conns := make([]*Connection, 0, len(s.connections))
+	for c := range s.connections {
+		conns = append(conns, c)
+	}
+	s.connectionsMx.RUnlock()
+
+	return broadcast(s, conns, mt, data)
+}
+
+// Sends a text message to every open connection of the server.
+func (s *Server) BroadcastText(msg string) error {
+	return s.Broadcast(TextMessage, []byte(msg)).Err
+}
+
+// Sends a binary message to every open connection of the server.
+func (s *Server) BroadcastBinary(msg []byte) error {
+	return s.Broadcast(BinaryMessage, msg).Err
+}
+
+// Encodes the message once per checksum setting and extension and writes the same bytes to every eligible
+// connection using a bounded number of concurrent writers.
+func broadcast(s *Server, conns []*Connection, mt MessageType, data []byte) BroadcastResult {
+	var result BroadcastResult
+
+	targets := make([]*Connection, 0, len(conns))
+	for _, c := range conns {
+		if !c.IsOpen() || c.Mode() == ModeReadOnly {
+			result.Skipped++
+			continue
+		}
+		targets = append(targets, c)
+	}
+	if len(targets) == 0 {
+		return result
+	}
+
+	// the frame is only built once for every combination of checksum envelope and extension
+	pm := newPreparedMessage(mt, data)
+
+	workers := s.broadcastWorkers
+	if workers <= 0 {
+		workers = defaultBroadcastWorkers
+	}
+	workers = min(workers, len(targets))
+
+	var (
+		wg   sync.WaitGroup
+		mx   sync.Mutex
+		errs []error
+		next = make(chan *Connection)
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		spawn("broadcast worker", func() {
+			defer wg.Done()
+			for c := range next {
+				err := c.sendPrepared(pm, s.broadcastWriteTimeout)
+				mx.Lock()
+				if err != nil {
+					result.Failed++
+					errs = append(errs, err)
+				} else {
+					result.Sent++
+				}
+				mx.Unlock()
+			}
+		})
+	}
+	for _, c := range targets {
+		next <- c
+	}
+	close(next)
+	wg.Wait()
+
+	result.Err = errors.Join(errs...)
+	return result
+}
+
+// Writes an already encoded message. If the write doesn't finish within timeout the connection is dropped.
+func (c *Connection) writeBroadcast(frame []byte, size int64, timeout time.Duration) error {
+	if err := c.checkWritable(); err != nil {
+		return err
+	}
+
+	c.sendMx.Lock()
+	defer c.sendMx.Unlock()
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+
+	if timeout > 0 {
+		timer := c.server.clock.AfterFunc(timeout, func() {
+			c.conn.SetWriteDeadline(aLongTimeAgo)
+		})
+		defer func() {
+			timer.Stop()
+			c.restoreWriteDeadline()
+		}()
+	}
+
+	if _, err := c.write(frame); err != nil {
+		if timeout > 0 {
+			c.dropConn() // the frame may be half written
+		}
+		return err
+	}
+	c.countMessageOut(size)
+	return nil
+}
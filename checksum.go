@@ -0,0 +1,88 @@
+package simplewebsockets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+)
+
+// Checksum algorithm used for the opt-in binary message integrity envelope.
+type ChecksumAlgorithm int
+
+const (
+	ChecksumNone   ChecksumAlgorithm = iota
+	ChecksumCRC32                    // IEEE polynomial
+	ChecksumCRC32C                   // Castagnoli polynomial
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Returned (through OnError) when a received binary message fails checksum verification. The message is dropped.
+type ChecksumError struct {
+	Expected uint32 // checksum carried by the message
+	Actual   uint32 // checksum computed over the received payload
+	Short    bool   // message was too short to carry a checksum
+}
+
+func (e *ChecksumError) Error() string {
+	if e.Short {
+		return "checksum mismatch: message too short to contain a checksum"
+	}
+	return fmt.Sprintf("checksum mismatch: expected %08x, got %08x", e.Expected, e.Actual)
+}
+
+// Setter to be passed into the creation of a server. When set, every binary message sent carries a 4 byte
+// big endian checksum of its payload at the end, and every binary message received must carry one too.
+// Both peers have to be configured with the same algorithm.
+func WithChecksum(alg ChecksumAlgorithm) ServerOption {
+	return func(s *Server) {
+		s.checksum = alg
+	}
+}
+
+func (alg ChecksumAlgorithm) sum(payload []byte) uint32 {
+	if alg == ChecksumCRC32C {
+		return crc32.Checksum(payload, crc32cTable)
+	}
+	return crc32.ChecksumIEEE(payload)
+}
+
+// Returns a hash for computing the checksum incrementally, nil for ChecksumNone.
+func (alg ChecksumAlgorithm) newHash() hash.Hash32 {
+	switch alg {
+	case ChecksumCRC32:
+		return crc32.NewIEEE()
+	case ChecksumCRC32C:
+		return crc32.New(crc32cTable)
+	}
+	return nil
+}
+
+// Returns a copy of payload with the checksum appended.
+func (alg ChecksumAlgorithm) seal(payload []byte) []byte {
+	if alg == ChecksumNone {
+		return payload
+	}
+	sealed := make([]byte, len(payload), len(payload)+4)
+	copy(sealed, payload)
+	return binary.BigEndian.AppendUint32(sealed, alg.sum(payload))
+}
+
+// Verifies and strips the checksum from a received payload.
+func (alg ChecksumAlgorithm) open(payload []byte) ([]byte, error) {
+	if alg == ChecksumNone {
+		return payload, nil
+	}
+	if len(payload) < 4 {
+		return nil, &ChecksumError{Short: true}
+	}
+
+	body := payload[:len(payload)-4]
+	expected := binary.BigEndian.Uint32(payload[len(payload)-4:])
+	actual := alg.sum(body)
+	if expected != actual {
+		return nil, &ChecksumError{Expected: expected, Actual: actual}
+	}
+	return body, nil
+}
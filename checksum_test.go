@@ -0,0 +1,81 @@
+package simplewebsockets_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math/rand"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+func sealCRC32C(payload []byte) []byte {
+	sum := crc32.Checksum(payload, crc32.MakeTable(crc32.Castagnoli))
+	return binary.BigEndian.AppendUint32(append([]byte(nil), payload...), sum)
+}
+
+func TestChecksum(t *testing.T) {
+	sim := wstest.NewSimulation(1946, simplewebsockets.WithChecksum(simplewebsockets.ChecksumCRC32C))
+	received := make(chan string, 4)
+	sim.Server.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) {
+		received <- string(msg)
+		c.SendBinaryMessage(msg)
+	})
+	handled := func() string {
+		select {
+		case msg := <-received:
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler got no message")
+			return ""
+		}
+	}
+	errs := make(chan error, 4)
+	sim.Server.OnError(func(c *simplewebsockets.Connection, err error) { errs <- err })
+	c, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Abort()
+
+	// the handler gets the payload without its checksum, the echo carries one
+	c.SendBinary(sealCRC32C([]byte("reading 42")))
+	if msg := handled(); msg != "reading 42" {
+		t.Fatalf("handler got %q, want the payload", msg)
+	}
+	if _, echo, err := c.NextMessage(); err != nil || string(echo) != string(sealCRC32C([]byte("reading 42"))) {
+		t.Fatalf("echo % x (%v), want the payload and its CRC32C", echo, err)
+	}
+
+	corrupted := sealCRC32C([]byte("reading 42"))
+	corrupted[0] ^= 0x01
+	for _, tt := range []struct {
+		name  string
+		msg   []byte
+		short bool
+	}{
+		{"corrupted", corrupted, false},
+		{"too short", []byte{1, 2}, true},
+	} {
+		c.SendBinary(tt.msg)
+		var ce *simplewebsockets.ChecksumError
+		select {
+		case err := <-errs:
+			if !errors.As(err, &ce) || ce.Short != tt.short {
+				t.Fatalf("%s: error %v, want a *ChecksumError with Short %v", tt.name, err, tt.short)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no checksum error", tt.name)
+		}
+	}
+
+	// the rejected messages were dropped, text messages carry no checksum
+	c.SendText("text")
+	if msg := handled(); msg != "text" {
+		t.Fatalf("handler got %q after the rejected messages, want the text message", msg)
+	}
+}
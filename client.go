@@ -0,0 +1,278 @@
+package simplewebsockets
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned by Dial when the server doesn't complete the websocket handshake.
+var ErrBadHandshake = errors.New("bad handshake")
+
+// Options for Dial.
+type DialOption func(*dialConfig)
+
+type dialConfig struct {
+	header     http.Header
+	tlsConfig  *tls.Config
+	dialer     *net.Dialer
+	conn       []ConnOption
+	srvService string
+	maskKey    func() [4]byte
+}
+
+func newDialConfig(opts []DialOption) dialConfig {
+	cfg := dialConfig{header: make(http.Header), dialer: &net.Dialer{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// Adds a header to the upgrade request, e.g. Authorization or Origin.
+func WithDialHeader(key string, value string) DialOption {
+	return func(d *dialConfig) {
+		d.header.Add(key, value)
+	}
+}
+
+// Offers subprotocols to the server, in order of preference. The one it picks is returned by
+// Connection.Subprotocol, Dial fails if it picks one that wasn't offered.
+func WithDialSubprotocols(protocols ...string) DialOption {
+	return func(d *dialConfig) {
+		d.header.Add("Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+	}
+}
+
+// Sets the TLS configuration used for wss:// URLs.
+func WithDialTLSConfig(config *tls.Config) DialOption {
+	return func(d *dialConfig) {
+		d.tlsConfig = config
+	}
+}
+
+// Configures the connection before it starts reading, e.g. WithMessageHandler, so the server's first messages
+// aren't missed.
+func WithConnOptions(opts ...ConnOption) DialOption {
+	return func(d *dialConfig) {
+		d.conn = append(d.conn, opts...)
+	}
+}
+
+// Sets the dialer for the TCP connection, e.g. to resolve names with its Resolver, bind its LocalAddr or
+// tune its FallbackDelay, how long an IPv6 attempt gets before IPv4 is tried in parallel (Happy Eyeballs).
+func WithDialer(d *net.Dialer) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.dialer = d
+	}
+}
+
+// Generates the mask keys of the frames the connection sends, instead of reading them from crypto/rand, e.g.
+// fixed or sequential keys for compliance tests or reproducible frame bytes. gen is called once per frame
+// while the connection's writes are locked, so it needs no locking of its own unless it is shared between
+// connections. Keys that are predictable to a web page expose intermediaries to cache poisoning, which is what
+// masking prevents (RFC 6455 section 10.3); don't use such keys outside of tests.
+func WithMaskKeyGenerator(gen func() [4]byte) DialOption {
+	return func(d *dialConfig) {
+		d.maskKey = gen
+	}
+}
+
+var (
+	defaultClientOnce sync.Once
+	defaultClient     *Server
+)
+
+// Connects to a websocket server at a ws:// or wss:// URL using a shared server with default settings.
+// See Server.Dial.
+func Dial(ctx context.Context, rawURL string, opts ...DialOption) (*Connection, error) {
+	return sharedClient().Dial(ctx, rawURL, opts...)
+}
+
+// returns the server with default settings that Dial and NewLink use
+func sharedClient() *Server {
+	defaultClientOnce.Do(func() {
+		defaultClient = NewServer()
+	})
+	return defaultClient
+}
+
+// Connects to a websocket server at a ws:// or wss:// URL. ctx bounds the TCP connect and the handshake.
+// Every A and AAAA record of the host is tried, see WithDialer, or the targets of its SRV records with
+// WithDialSRV.
+// The returned connection behaves like one the server accepted: it is counted in the server's
+// connections, uses its limits, events and clock, and has the same OnMessage/OnClose surface. Its frames
+// are masked. Reading starts right away, so pass its handler WithConnOptions; messages arriving before a
+// handler set on the returned connection go to the server's unhandled policy.
+func (s *Server) Dial(ctx context.Context, rawURL string, opts ...DialOption) (*Connection, error) {
+	if s.shuttingDown.Load() {
+		return nil, ErrServerClosed
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	cfg := newDialConfig(opts)
+	network, err := s.tcpNetwork()
+	if err != nil {
+		return nil, err
+	}
+
+	var port string
+	switch u.Scheme {
+	case "ws":
+		port = "80"
+	case "wss":
+		port = "443"
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q, expected ws or wss", u.Scheme)
+	}
+	targets, err := cfg.targets(ctx, u, port)
+	if err != nil {
+		return nil, err
+	}
+
+	dial := cfg.dialer.DialContext
+	if u.Scheme == "wss" {
+		config := cfg.tlsConfig.Clone()
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		dial = (&tls.Dialer{NetDialer: cfg.dialer, Config: config}).DialContext
+	}
+	conn, err := dialTargets(ctx, targets, func(ctx context.Context, addr string) (net.Conn, error) {
+		return dial(ctx, network, addr)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// abort the handshake when ctx ends
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(aLongTimeAgo)
+	})
+
+	header := s.offerEnvelopeVersions(cfg.header)
+	conn, deflate, subprotocol, err := s.performClientHandshake(conn, u, header)
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	var version int
+	if err == nil {
+		// a server that answers without a version predates the negotiation
+		version, err = s.envelopeVersion(nil, subprotocol)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	c := s.newConnection(conn)
+	c.client = true
+	c.maskKey = cfg.maskKey
+	c.deflate = deflate
+	c.subprotocol = subprotocol
+	c.envelopeVersion = version
+	if !s.attachTenant(c, "") {
+		conn.Close()
+		return nil, fmt.Errorf("tenant %q is at its connection limit", c.tenant.id)
+	}
+
+	for _, opt := range cfg.conn {
+		opt(c)
+	}
+	spawn("connection", func() { s.serve(c) })
+	return c, nil
+}
+
+// Sends the upgrade request and checks the server's answer. Returns a conn that still yields any
+// frame bytes read together with the response, how permessage-deflate was negotiated and the
+// subprotocol the server picked.
+func (s *Server) performClientHandshake(conn net.Conn, u *url.URL, header http.Header) (net.Conn, deflateMode, string, error) {
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	path := u.RequestURI()
+	var req strings.Builder
+	fmt.Fprintf(&req, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n", path, u.Host)
+	fmt.Fprintf(&req, "Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n", key)
+	if s.compression {
+		req.WriteString("Sec-WebSocket-Extensions: " + s.deflateOffer() + "\r\n")
+	}
+	for name, values := range header {
+		for _, v := range values {
+			fmt.Fprintf(&req, "%s: %s\r\n", name, v)
+		}
+	}
+	req.WriteString("\r\n")
+	if _, err := conn.Write([]byte(req.String())); err != nil {
+		return conn, deflateOff, "", err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodGet})
+	if err != nil {
+		return conn, deflateOff, "", err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode != http.StatusSwitchingProtocols:
+		return conn, deflateOff, "", fmt.Errorf("%w: server responded %s", ErrBadHandshake, resp.Status)
+	case !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket"):
+		return conn, deflateOff, "", fmt.Errorf("%w: missing Upgrade: websocket header", ErrBadHandshake)
+	case !headerContainsToken(resp.Header, "Connection", "upgrade"):
+		return conn, deflateOff, "", fmt.Errorf("%w: missing Connection: upgrade header", ErrBadHandshake)
+	case resp.Header.Get("Sec-WebSocket-Accept") != acceptKey([]byte(key)):
+		return conn, deflateOff, "", fmt.Errorf("%w: wrong Sec-WebSocket-Accept", ErrBadHandshake)
+	}
+
+	// the server may only accept what we offered, and our offer holds nothing we can't honor
+	deflate := deflateOff
+	if ext := resp.Header.Get("Sec-WebSocket-Extensions"); ext != "" {
+		if s.compression {
+			deflate = s.acceptDeflateOffer(ext)
+		}
+		if deflate == deflateOff {
+			return conn, deflateOff, "", fmt.Errorf("%w: unexpected extensions %q", ErrBadHandshake, ext)
+		}
+	}
+	subprotocol := resp.Header.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !slices.Contains(offeredSubprotocols(header), subprotocol) {
+		return conn, deflateOff, "", fmt.Errorf("%w: unexpected subprotocol %q", ErrBadHandshake, subprotocol)
+	}
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, deflate, subprotocol, nil
+	}
+	return conn, deflate, subprotocol, nil
+}
+
+// A conn whose first reads drain bytes buffered while reading the handshake response.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,98 @@
+package simplewebsockets_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+// Accepts one websocket connection on a loopback port without a server, returning its URL and the
+// connection once the handshake is answered.
+func rawPeer(t *testing.T) (string, chan net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			conn.Close()
+			return
+		}
+		sum := sha1.Sum([]byte(req.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: "+base64.StdEncoding.EncodeToString(sum[:])+"\r\n\r\n")
+		accepted <- conn
+	}()
+	return "ws://" + ln.Addr().String(), accepted
+}
+
+func TestClientFramesGolden(t *testing.T) {
+	keys := [][4]byte{
+		{0x37, 0xfa, 0x21, 0x3d}, // RFC 6455 section 5.7
+		{0x01, 0x02, 0x03, 0x04},
+		{0x00, 0x00, 0x00, 0x00},
+	}
+	next := 0
+	gen := func() [4]byte {
+		key := keys[next%len(keys)]
+		next++
+		return key
+	}
+
+	url, accepted := rawPeer(t)
+	client := simplewebsockets.NewServer()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := client.Dial(ctx, url, simplewebsockets.WithMaskKeyGenerator(gen))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	peer := <-accepted
+	defer peer.Close()
+	peer.SetDeadline(time.Now().Add(5 * time.Second))
+
+	tests := []struct {
+		name string
+		send func() error
+		want []byte
+	}{
+		{"masked text", func() error { return c.SendTextMessage("Hello") },
+			[]byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}},
+		{"masked binary", func() error { return c.SendBinaryMessage([]byte{0x10, 0x20, 0x30, 0x40, 0x50}) },
+			[]byte{0x82, 0x85, 0x01, 0x02, 0x03, 0x04, 0x11, 0x22, 0x33, 0x44, 0x51}},
+		{"masked ping", func() error { return c.SendPing([]byte("hi")) },
+			[]byte{0x89, 0x82, 0x00, 0x00, 0x00, 0x00, 'h', 'i'}},
+		{"generator is called per frame", func() error { return c.SendTextMessage("Hello") },
+			[]byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}},
+	}
+	for _, tt := range tests {
+		if err := tt.send(); err != nil {
+			t.Fatalf("%s: send: %v", tt.name, err)
+		}
+		got := make([]byte, len(tt.want))
+		if _, err := io.ReadFull(peer, got); err != nil {
+			t.Fatalf("%s: read: %v", tt.name, err)
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: client wrote % x, want % x", tt.name, got, tt.want)
+		}
+	}
+}
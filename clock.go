@@ -0,0 +1,71 @@
+package simplewebsockets
+
+import (
+	"net"
+	"time"
+)
+
+// Source of time for deadlines, keepalive intervals, close timers and timestamps. Tests can inject a fake
+// implementation (see wstest.FakeClock) to advance time deterministically instead of sleeping.
+// Diagnostic timings (WithDiagnostics) always use the real clock since they measure actual work.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// A timer created by Clock.AfterFunc.
+type Timer interface {
+	Stop() bool
+}
+
+// A ticker created by Clock.NewTicker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Setter to be passed into the creation of a server.
+func WithClock(clock Clock) ServerOption {
+	return func(s *Server) {
+		s.clock = clock
+	}
+}
+
+// Clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTicker) Stop() {
+	t.t.Stop()
+}
+
+// Deadline in the past, used to interrupt blocked reads and writes.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// Expires the connection's deadline after d on the given clock, so timeouts follow an injected clock rather
+// than the wall clock net.Conn deadlines use. Stop the returned timer to cancel it.
+func expireAfter(clock Clock, conn net.Conn, d time.Duration) Timer {
+	return clock.AfterFunc(d, func() {
+		conn.SetDeadline(aLongTimeAgo)
+	})
+}
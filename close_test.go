@@ -0,0 +1,168 @@
+package simplewebsockets_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+// Waits for the server's close frame and returns its payload.
+func closePayload(t *testing.T, c *wstest.SimClient) []byte {
+	t.Helper()
+	for {
+		f, err := c.NextFrame()
+		if err != nil {
+			t.Fatalf("no close frame from the server: %v", err)
+		}
+		if f.Opcode == 0x8 {
+			return f.Payload
+		}
+	}
+}
+
+func status(code uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, code)
+}
+
+func TestReceivedCloseFrames(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		answer  []byte // the server's close payload
+		clean   bool   // the peer's close is accepted as is
+	}{
+		{name: "no status", payload: nil, answer: nil, clean: true},
+		{name: "normal closure", payload: status(1000), answer: status(1000), clean: true},
+		{name: "reason isn't echoed", payload: append(status(1001), "bye"...), answer: status(1001), clean: true},
+		{name: "last protocol code", payload: status(1003), answer: status(1003), clean: true},
+		{name: "first code after the reserved ones", payload: status(1007), answer: status(1007), clean: true},
+		{name: "bad gateway", payload: status(1014), answer: status(1014), clean: true},
+		{name: "first registered code", payload: status(3000), answer: status(3000), clean: true},
+		{name: "last private code", payload: status(4999), answer: status(4999), clean: true},
+		{name: "multi-byte UTF-8 reason", payload: append(status(1000), "tschüß"...), answer: status(1000), clean: true},
+
+		{name: "1 byte payload", payload: []byte{0x03}, answer: status(1002)},
+		{name: "code 0", payload: status(0), answer: status(1002)},
+		{name: "code 999", payload: status(999), answer: status(1002)},
+		{name: "unassigned 1004", payload: status(1004), answer: status(1002)},
+		{name: "reserved 1005", payload: status(1005), answer: status(1002)},
+		{name: "reserved 1006", payload: status(1006), answer: status(1002)},
+		{name: "reserved 1015", payload: status(1015), answer: status(1002)},
+		{name: "unassigned 1016", payload: status(1016), answer: status(1002)},
+		{name: "unassigned 2999", payload: status(2999), answer: status(1002)},
+		{name: "code 5000", payload: status(5000), answer: status(1002)},
+		{name: "code 65535", payload: status(65535), answer: status(1002)},
+		{name: "invalid UTF-8 reason", payload: append(status(1000), 0xff, 0xfe), answer: status(1007)},
+		{name: "truncated rune in reason", payload: append(status(1000), "ü"[:1]...), answer: status(1007)},
+		{name: "payload over 125 bytes", payload: append(status(1000), strings.Repeat("x", 124)...), answer: status(1002)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim, c := echoClient(t)
+			disconnected := make(chan *simplewebsockets.Connection, 1)
+			sim.Server.OnDisconnect(func(sc *simplewebsockets.Connection) { disconnected <- sc })
+
+			if err := c.SendFrame(0x8, tt.payload, true); err != nil {
+				t.Fatalf("send: %v", err)
+			}
+			answer := closePayload(t, c)
+			if !bytes.Equal(answer[:min(len(answer), 2)], tt.answer) {
+				t.Fatalf("server answered with close payload % x, want status % x", answer, tt.answer)
+			}
+			if tt.clean && len(answer) > 2 {
+				t.Errorf("server echoed the reason %q", answer[2:])
+			}
+
+			select {
+			case sc := <-disconnected:
+				d, _ := sc.Disposition()
+				if tt.clean && (!d.Clean || d.Err != nil) {
+					t.Errorf("disposition %+v, want a clean close", d)
+				}
+				if !tt.clean && d.Err == nil {
+					t.Errorf("disposition %+v, want the close frame's error", d)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("connection wasn't released after the close")
+			}
+		})
+	}
+}
+
+// A local Close racing the peer's close frame, or the peer dropping the connection, must still end every
+// connection exactly once. Run with -race.
+func TestCloseRace(t *testing.T) {
+	wstest.VerifyNoLeaks(t)
+	const clients = 200
+
+	sim := wstest.NewSimulation(1985)
+	var (
+		mx           sync.Mutex
+		closed       = make(map[*simplewebsockets.Connection]int)
+		disconnected = make(map[*simplewebsockets.Connection]int)
+		done         = make(chan struct{})
+	)
+	sim.Server.OnConnect(func(c *simplewebsockets.Connection) {
+		c.OnClose = func([]byte) {
+			mx.Lock()
+			closed[c]++
+			mx.Unlock()
+		}
+		go c.Close(1001, "racing")
+	})
+	sim.Server.OnDisconnect(func(c *simplewebsockets.Connection) {
+		mx.Lock()
+		defer mx.Unlock()
+		if disconnected[c]++; len(disconnected) == clients && disconnected[c] == 1 {
+			close(done)
+		}
+	})
+
+	errs := sim.Run(clients, func(r *rand.Rand, c *wstest.SimClient) error {
+		switch r.Intn(3) {
+		case 0: // answer with a close of our own, crossing the server's
+			c.Close(1000, "bye")
+		case 1: // keep talking until the server's close arrives
+			c.SendText("still here")
+		case 2: // drop the connection while the server closes it
+			return nil
+		}
+		for {
+			f, err := c.NextFrame()
+			if err != nil || f.Opcode == 0x8 {
+				c.Close(1000, "")
+				return nil
+			}
+		}
+	})
+	for _, err := range errs {
+		t.Error(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		sim.Clock.Advance(10 * time.Second) // let close timeouts of connections that are still closing fire
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("not every connection ended")
+		}
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+	for c, n := range disconnected {
+		if n != 1 || closed[c] != 1 {
+			t.Errorf("connection %d: OnDisconnect ran %d times, OnClose %d times", c.ID(), n, closed[c])
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package simplewebsockets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"unicode/utf8"
+)
+
+// Names of the close codes defined by RFC 6455 and the IANA registry.
+var standardCloseCodes = map[uint16]string{
+	1000: "normal closure",
+	1001: "going away",
+	1002: "protocol error",
+	1003: "unsupported data",
+	1005: "no status received",
+	1006: "abnormal closure",
+	1007: "invalid payload data",
+	1008: "policy violation",
+	1009: "message too big",
+	1010: "mandatory extension",
+	1011: "internal error",
+	1012: "service restart",
+	1013: "try again later",
+	1014: "bad gateway",
+	1015: "TLS handshake",
+}
+
+var (
+	appCloseCodesMx sync.RWMutex
+	appCloseCodes   = make(map[uint16]string)
+)
+
+// Reports whether code is in the private range (4000-4999) left to applications.
+func IsApplicationCloseCode(code uint16) bool {
+	return code >= 4000 && code <= 4999
+}
+
+// Reports whether code may be sent in a close frame. 1005, 1006 and 1015 are reserved for reporting
+// and must never be put on the wire.
+func IsValidCloseCode(code uint16) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// Registers a human readable name for an application close code, so both ends of a first party protocol can
+// share reasons and logs can show names. Close uses the name as reason when it is called without one.
+func RegisterCloseCode(code uint16, name string) error {
+	if !IsApplicationCloseCode(code) {
+		return fmt.Errorf("close code %d is outside the application range 4000-4999", code)
+	}
+
+	appCloseCodesMx.Lock()
+	defer appCloseCodesMx.Unlock()
+	appCloseCodes[code] = name
+	return nil
+}
+
+// Returns the name of a standard or registered close code, or the number if it is unknown.
+func CloseCodeName(code uint16) string {
+	if name, ok := standardCloseCodes[code]; ok {
+		return name
+	}
+
+	appCloseCodesMx.RLock()
+	defer appCloseCodesMx.RUnlock()
+	if name, ok := appCloseCodes[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("close code %d", code)
+}
+
+// returns the registered name of an application close code, or ""
+func registeredCloseReason(code uint16) string {
+	appCloseCodesMx.RLock()
+	defer appCloseCodesMx.RUnlock()
+	return appCloseCodes[code]
+}
+
+// Longest reason that fits in a close frame next to the status code.
+const maxCloseReason = 123
+
+// Setter to be passed into the creation of a server. fn rewrites the reason of every close frame the server
+// sends, given the code and the reason picked by the library or the application, e.g. to translate the
+// library's English reasons into the client's language (see Connection.Request for its Accept-Language).
+// Reasons fn returns are cut to the 123 bytes a close frame has room for.
+func WithCloseReasons(fn func(c *Connection, code uint16, reason string) string) ServerOption {
+	return func(s *Server) {
+		s.closeReasons = fn
+	}
+}
+
+// passes a reason about to be sent through the close reason hook, if there is one
+func (c *Connection) wireCloseReason(code uint16, reason string) string {
+	fn := c.server.closeReasons
+	if fn == nil {
+		return reason
+	}
+	reason = fn(c, code, reason)
+	if len(reason) > maxCloseReason {
+		reason = reason[:maxCloseReason]
+		for !utf8.ValidString(reason) {
+			reason = reason[:len(reason)-1] // don't leave half a rune behind
+		}
+	}
+	return reason
+}
+
+// Checks the payload of a received close frame, which must be empty or a valid status code followed by an
+// optional UTF-8 reason. For an invalid payload it returns the status to answer with (1002 or 1007).
+func validateClosePayload(payload []byte) (uint16, error) {
+	switch {
+	case len(payload) == 0:
+		return 0, nil
+	case len(payload) == 1:
+		return 1002, fmt.Errorf("%w: 1 byte payload", ErrInvalidCloseFrame)
+	case len(payload) > 125:
+		return 1002, fmt.Errorf("%w: payload of %d bytes", ErrInvalidCloseFrame, len(payload))
+	}
+
+	code := binary.BigEndian.Uint16(payload)
+	if !IsValidCloseCode(code) {
+		return 1002, fmt.Errorf("%w: status %d", ErrInvalidCloseFrame, code)
+	}
+	if !utf8.Valid(payload[2:]) {
+		return 1007, fmt.Errorf("%w: reason is not valid UTF-8", ErrInvalidCloseFrame)
+	}
+	return 0, nil
+}
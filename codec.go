@@ -0,0 +1,84 @@
+package simplewebsockets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Turns structured values into messages and back, see WithCodec. A protobuf, msgpack or CBOR codec is
+// a small adapter around its library's marshal functions.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+	// whether encoded values are sent as text or binary messages
+	MessageType() MessageType
+}
+
+// The default codec, encoding/json in text messages.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) MessageType() MessageType           { return TextMessage }
+
+// Setter to be passed into the creation of a server. Sets the codec used for the values sent with
+// SendValue and BroadcastValue and received with OnValue and ReadValue, JSONCodec by default.
+func WithCodec(codec Codec) ServerOption {
+	return func(s *Server) {
+		s.codec = codec
+	}
+}
+
+// Returns the server's codec, see WithCodec.
+func (s *Server) Codec() Codec {
+	return s.codec
+}
+
+// Encodes v with the server's codec and sends it to the connection.
+func (c *Connection) SendValue(v any) error {
+	codec := c.server.codec
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.SendMessage(codec.MessageType(), data)
+}
+
+// Encodes v once with the server's codec and sends it to every member of the room.
+func (r *Room) BroadcastValue(v any) error {
+	codec := r.tenant.server.codec
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return r.Broadcast(codec.MessageType(), data).Err
+}
+
+// Sets the message handler of c (see SetOnMessage) to one that decodes every message into a T with the server's
+// codec and passes it to fn. A message that doesn't decode closes the connection with 1007 and ErrInvalidValue.
+func OnValue[T any](c *Connection, fn func(T)) {
+	c.SetOnMessage(func(data []byte) {
+		var v T
+		if err := c.server.codec.Unmarshal(data, &v); err != nil {
+			c.closeWithError(fmt.Errorf("%w: %v", ErrInvalidValue, err), 1007, "Invalid payload")
+			return
+		}
+		fn(v)
+	})
+}
+
+// Reads the next message like ReadMessageContext and decodes it into v with the server's codec. A message
+// that doesn't decode is returned as an error matching ErrInvalidValue and leaves the connection open.
+func (c *Connection) ReadValue(ctx context.Context, v any) error {
+	_, data, err := c.ReadMessageContext(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.server.codec.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package simplewebsockets_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/rand"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (gobCodec) MessageType() simplewebsockets.MessageType { return simplewebsockets.BinaryMessage }
+
+type point struct{ X, Y int }
+
+func TestCodec(t *testing.T) {
+	if simplewebsockets.NewServer().Codec() != simplewebsockets.JSONCodec {
+		t.Error("default codec isn't JSONCodec")
+	}
+
+	sim := wstest.NewSimulation(2016, simplewebsockets.WithCodec(gobCodec{}))
+	conns := make(chan *simplewebsockets.Connection, 1)
+	sim.Server.OnConnect(func(c *simplewebsockets.Connection) { conns <- c })
+	c, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Abort()
+	sc := <-conns
+
+	// values go out in the codec's encoding and message type
+	if err := sc.SendValue(point{1, 2}); err != nil {
+		t.Fatalf("send value: %v", err)
+	}
+	op, msg, err := c.NextMessage()
+	if err != nil || op != byte(simplewebsockets.BinaryMessage) {
+		t.Fatalf("got opcode %x (%v), want a binary message", op, err)
+	}
+	var sent point
+	if err := (gobCodec{}).Unmarshal(msg, &sent); err != nil || sent != (point{1, 2}) {
+		t.Fatalf("sent %+v (%v), want {X:1 Y:2}", sent, err)
+	}
+
+	// and are decoded the same way on the way in
+	got := make(chan point, 1)
+	simplewebsockets.OnValue(sc, func(p point) { got <- p })
+	data, _ := gobCodec{}.Marshal(point{3, 4})
+	c.SendBinary(data)
+	select {
+	case p := <-got:
+		if p != (point{3, 4}) {
+			t.Fatalf("handler got %+v, want {X:3 Y:4}", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler got no value")
+	}
+
+	c.SendBinary([]byte("not gob"))
+	if r := nextReply(t, c); r.closeCode != 1007 {
+		t.Errorf("server replied %+v to an undecodable value, want a close with 1007", r)
+	}
+}
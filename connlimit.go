@@ -0,0 +1,118 @@
+package simplewebsockets
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Retry-After sent with upgrades rejected by a connection limit.
+const connLimitRetryAfter = 5 * time.Second
+
+var (
+	// Matched (with errors.Is) by the error returned for upgrades rejected by WithMaxConnections.
+	ErrTooManyConnections = errors.New("server is at its connection limit")
+	// Matched (with errors.Is) by the error returned for upgrades rejected by WithMaxConnectionsPerIP.
+	ErrTooManyConnectionsFromIP = errors.New("client is at its connection limit")
+)
+
+// Setter to be passed into the creation of a server. Caps the connections the server holds at once at n,
+// counting those still in their handshake. Upgrades past the cap are answered with a 503 and Retry-After
+// instead of being accepted, so memory stays bounded under a connection flood. 0, the default, means no cap.
+func WithMaxConnections(n int) ServerOption {
+	return func(s *Server) {
+		s.maxConnections = n
+	}
+}
+
+// Setter to be passed into the creation of a server. Caps the connections a single client holds at once at n,
+// so one misbehaving client can't take all of the server's connections. Upgrades past it are answered with a
+// 429 and Retry-After. Clients are told apart by their remote IP unless WithConnectionKey says otherwise.
+// 0, the default, means no cap.
+func WithMaxConnectionsPerIP(n int) ServerOption {
+	return func(s *Server) {
+		s.maxConnectionsPerIP = n
+	}
+}
+
+// Setter to be passed into the creation of a server. fn names the client an upgrade request comes from for
+// WithMaxConnectionsPerIP, e.g. the first address of X-Forwarded-For behind a trusted proxy. Requests it
+// returns "" for aren't limited.
+func WithConnectionKey(fn func(r *http.Request) string) ServerOption {
+	return func(s *Server) {
+		s.connectionKey = fn
+	}
+}
+
+// Takes one of the server's connection slots for c, and one of its client's, so the upgrade can go ahead.
+// Returns the error of the limit that is reached otherwise.
+func (s *Server) admit(c *Connection) error {
+	if s.maxConnections > 0 {
+		if s.admitted.Add(1) > int64(s.maxConnections) {
+			s.admitted.Add(-1)
+			s.metrics.overCapacity.Add(1)
+			return ErrTooManyConnections
+		}
+		c.admitted.Store(true)
+	}
+
+	if s.maxConnectionsPerIP > 0 {
+		key := s.clientKey(c.request)
+		if key == "" {
+			return nil
+		}
+		s.perIPMx.Lock()
+		defer s.perIPMx.Unlock()
+		if s.perIP[key] >= s.maxConnectionsPerIP {
+			if c.admitted.Swap(false) {
+				s.admitted.Add(-1)
+			}
+			s.metrics.overIPLimit.Add(1)
+			return ErrTooManyConnectionsFromIP
+		}
+		s.perIP[key]++
+		c.clientKey = key
+	}
+	return nil
+}
+
+// gives back the slots taken by admit, safe to call more than once
+func (s *Server) release(c *Connection) {
+	if c.admitted.Swap(false) {
+		s.admitted.Add(-1)
+	}
+
+	s.perIPMx.Lock()
+	defer s.perIPMx.Unlock()
+	if c.clientKey == "" {
+		return
+	}
+	if s.perIP[c.clientKey]--; s.perIP[c.clientKey] <= 0 {
+		delete(s.perIP, c.clientKey)
+	}
+	c.clientKey = ""
+}
+
+// names the client r comes from, its remote IP unless WithConnectionKey is set
+func (s *Server) clientKey(r *http.Request) string {
+	if s.connectionKey != nil {
+		return s.connectionKey(r)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// answers an upgrade rejected by admit
+func rejectUnadmitted(conn net.Conn, err error) {
+	status := http.StatusServiceUnavailable
+	if errors.Is(err, ErrTooManyConnectionsFromIP) {
+		status = http.StatusTooManyRequests
+	}
+	header := http.Header{"Retry-After": {strconv.Itoa(int(connLimitRetryAfter / time.Second))}}
+	rejectHandshakeWithHeader(conn, status, header)
+}
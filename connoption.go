@@ -0,0 +1,40 @@
+package simplewebsockets
+
+// Configures a connection returned by Server.Upgrade or Dial before it reads anything, so no message can
+// arrive ahead of its handler. Handlers set on the returned connection instead may miss the first messages.
+type ConnOption func(*Connection)
+
+// Sets the message handler, like SetOnMessage.
+func WithMessageHandler(fn func([]byte)) ConnOption {
+	return func(c *Connection) {
+		c.OnMessage = fn
+	}
+}
+
+// Sets the typed message handler, like OnMessageTyped.
+func WithTypedMessageHandler(fn func(mt MessageType, data []byte)) ConnOption {
+	return func(c *Connection) {
+		c.onTyped = fn
+	}
+}
+
+// Sets the handler called with the peer's close frame payload, like assigning OnClose.
+func WithCloseHandler(fn func(reason []byte)) ConnOption {
+	return func(c *Connection) {
+		c.OnClose = fn
+	}
+}
+
+// Reads the connection with ReadMessage from the first message on.
+func WithPullReads() ConnOption {
+	return func(c *Connection) {
+		c.startPull()
+	}
+}
+
+// Reads the connection with NextReader from the first message on.
+func WithStreamReads() ConnOption {
+	return func(c *Connection) {
+		c.streams.use()
+	}
+}
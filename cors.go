@@ -0,0 +1,85 @@
+package simplewebsockets
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS settings for Server.CORS, see WithCORS.
+type CORSConfig struct {
+	AllowMethods     []string      // methods allowed in preflights, GET, HEAD and POST if empty
+	AllowHeaders     []string      // request headers allowed in preflights, those the browser asks for if empty
+	ExposeHeaders    []string      // response headers scripts may read
+	MaxAge           time.Duration // how long browsers may cache a preflight, 0 leaves it to them
+	AllowCredentials bool          // let browsers send cookies, needs WithOriginCheck
+}
+
+// Setter to be passed into the creation of a server. Configures the CORS handling of Server.CORS.
+func WithCORS(config CORSConfig) ServerOption {
+	return func(s *Server) {
+		s.cors = config
+	}
+}
+
+// Wraps a companion HTTP endpoint served next to the websocket endpoint (health checks, metrics, ...) so
+// browser dashboards on other origins can call it. Origins are allowed by the same policy as upgrades,
+// WithOriginCheck; requests from other origins get a 403. Without an origin check any origin may call h,
+// but never with credentials. Preflights are answered here according to WithCORS and don't reach h.
+func (s *Server) CORS(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r) // not a cross-origin request
+			return
+		}
+		if err := s.checkOrigin(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		if s.originCheck == nil {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+			if s.cors.AllowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			s.answerPreflight(w, r)
+			return
+		}
+		if len(s.cors.ExposeHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(s.cors.ExposeHeaders, ", "))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// answers a CORS preflight request according to the server's CORSConfig
+func (s *Server) answerPreflight(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	methods := s.cors.AllowMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+	if len(s.cors.AllowHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(s.cors.AllowHeaders, ", "))
+	} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		header.Set("Access-Control-Allow-Headers", requested)
+	}
+	if s.cors.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(s.cors.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
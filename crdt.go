@@ -0,0 +1,106 @@
+package simplewebsockets
+
+import "sync"
+
+// Hooks connecting a CRDT library (Yjs, Automerge, ...) to a CRDTRoom. Updates and state vectors are opaque
+// bytes to the server, every hook is optional.
+type CRDTHooks struct {
+	// Checks and applies a client's update to the server's copy of the document. An error rejects the update.
+	Apply func(update []byte) error
+
+	// Returns what a client with the given state vector is missing, e.g. Y.encodeStateAsUpdate(doc, sv).
+	// Without it joining clients are sent the whole update log.
+	Diff func(stateVector []byte) ([]byte, error)
+
+	// Merges the update log into fewer updates. Called once the log holds CompactEvery updates.
+	Compact      func(updates [][]byte) ([][]byte, error)
+	CompactEvery int
+}
+
+// A room syncing one collaboratively edited document. Updates from one member are sent as binary messages to
+// all the others and kept in a log, so joining clients can catch up.
+type CRDTRoom struct {
+	room  *Room
+	hooks CRDTHooks
+
+	mx  sync.Mutex // orders joins and updates
+	log [][]byte
+}
+
+// Creates a CRDT room on top of room.
+func NewCRDTRoom(room *Room, hooks CRDTHooks) *CRDTRoom {
+	return &CRDTRoom{room: room, hooks: hooks}
+}
+
+// Returns the room the document is synced in.
+func (d *CRDTRoom) Room() *Room {
+	return d.room
+}
+
+// Joins the room after sending the connection the updates it is missing according to stateVector (see
+// CRDTHooks.Diff). No update is broadcast in between, so the connection sees every update exactly once.
+func (d *CRDTRoom) Join(c *Connection, stateVector []byte) error {
+	if err := d.room.authorize(c, ActionJoin); err != nil {
+		return err
+	}
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	missing := d.log
+	if d.hooks.Diff != nil {
+		diff, err := d.hooks.Diff(stateVector)
+		if err != nil {
+			return err
+		}
+		missing = nil
+		if len(diff) > 0 {
+			missing = [][]byte{diff}
+		}
+	}
+
+	for _, update := range missing {
+		if err := c.writeMessage(byte(BinaryMessage), update); err != nil {
+			return err
+		}
+	}
+	return d.room.join(c)
+}
+
+// Applies an update received from a member and forwards it to the other members. from may be nil for
+// updates made on the server, otherwise the room authorizer is asked first.
+func (d *CRDTRoom) HandleUpdate(from *Connection, update []byte) BroadcastResult {
+	if from != nil {
+		if err := d.room.authorize(from, ActionEmit); err != nil {
+			return BroadcastResult{Err: err}
+		}
+	}
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	if d.hooks.Apply != nil {
+		if err := d.hooks.Apply(update); err != nil {
+			return BroadcastResult{Err: err}
+		}
+	}
+
+	d.log = append(d.log, append([]byte(nil), update...))
+	if d.hooks.Compact != nil && d.hooks.CompactEvery > 0 && len(d.log) >= d.hooks.CompactEvery {
+		if compacted, err := d.hooks.Compact(d.log); err == nil {
+			d.log = compacted
+		}
+	}
+
+	members := d.room.Members()
+	others := members[:0]
+	for _, c := range members {
+		if c != from {
+			others = append(others, c)
+		}
+	}
+
+	d.room.sendMx.Lock()
+	defer d.room.sendMx.Unlock()
+	return broadcast(d.room.tenant.server, others, BinaryMessage, update)
+}
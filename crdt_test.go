@@ -0,0 +1,122 @@
+package simplewebsockets_test
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+// Reads the next binary message from c and compares it with want.
+func expectUpdate(t *testing.T, c *wstest.SimClient, want string) {
+	t.Helper()
+	timer := time.AfterFunc(5*time.Second, c.Abort)
+	defer timer.Stop()
+	op, msg, err := c.NextMessage()
+	if err != nil || op != byte(simplewebsockets.BinaryMessage) || string(msg) != want {
+		t.Fatalf("got %x %q (%v), want the binary update %q", op, msg, err, want)
+	}
+}
+
+func TestCRDTRoom(t *testing.T) {
+	sim := wstest.NewSimulation(1997)
+	conns := make(chan *simplewebsockets.Connection, 2)
+	sim.Server.OnConnect(func(c *simplewebsockets.Connection) { conns <- c })
+	a, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer a.Abort()
+	sa := <-conns
+	b, err := sim.Connect(rand.New(rand.NewSource(sim.Seed + 1)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer b.Abort()
+	sb := <-conns
+
+	errInvalid := errors.New("invalid update")
+	var compactions []string
+	doc := simplewebsockets.NewCRDTRoom(sim.Server.Room("doc"), simplewebsockets.CRDTHooks{
+		Apply: func(update []byte) error {
+			if string(update) == "garbage" {
+				return errInvalid
+			}
+			return nil
+		},
+		Compact: func(updates [][]byte) ([][]byte, error) {
+			merged := bytes.Join(updates, []byte("+"))
+			compactions = append(compactions, string(merged))
+			return [][]byte{merged}, nil
+		},
+		CompactEvery: 2,
+	})
+
+	if err := doc.Join(sa, nil); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if res := doc.HandleUpdate(sa, []byte("u1")); res.Err != nil {
+		t.Fatalf("update: %v", res.Err)
+	}
+	if res := doc.HandleUpdate(sa, []byte("garbage")); !errors.Is(res.Err, errInvalid) {
+		t.Fatalf("rejected update: %v, want the Apply error", res.Err)
+	}
+
+	// a late joiner catches up from the log
+	if err := doc.Join(sb, nil); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	expectUpdate(t, b, "u1")
+
+	// updates reach everyone but their sender
+	if res := doc.HandleUpdate(sb, []byte("u2")); res.Err != nil {
+		t.Fatalf("update: %v", res.Err)
+	}
+	expectUpdate(t, a, "u2")
+	if res := doc.HandleUpdate(nil, []byte("u3")); res.Err != nil {
+		t.Fatalf("server update: %v", res.Err)
+	}
+	expectUpdate(t, a, "u3")
+	expectUpdate(t, b, "u3")
+
+	// compacted once the log held u1 and u2, then again once u3 was added to the result
+	if len(compactions) != 2 || compactions[0] != "u1+u2" || compactions[1] != "u1+u2+u3" {
+		t.Errorf("compacted %q, want the log every time it held 2 updates", compactions)
+	}
+}
+
+func TestCRDTRoomDiff(t *testing.T) {
+	sim := wstest.NewSimulation(1997)
+	conns := make(chan *simplewebsockets.Connection, 1)
+	sim.Server.OnConnect(func(c *simplewebsockets.Connection) { conns <- c })
+	c, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Abort()
+
+	var vector []byte
+	doc := simplewebsockets.NewCRDTRoom(sim.Server.Room("doc"), simplewebsockets.CRDTHooks{
+		Diff: func(stateVector []byte) ([]byte, error) {
+			vector = stateVector
+			return []byte("missing since sv"), nil
+		},
+	})
+	doc.HandleUpdate(nil, []byte("u1"))
+	doc.HandleUpdate(nil, []byte("u2"))
+
+	// the diff replaces the log
+	if err := doc.Join(<-conns, []byte("sv")); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	expectUpdate(t, c, "missing since sv")
+	if string(vector) != "sv" {
+		t.Errorf("Diff got state vector %q, want the joiner's", vector)
+	}
+	doc.HandleUpdate(nil, []byte("u3"))
+	expectUpdate(t, c, "u3")
+}
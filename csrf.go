@@ -0,0 +1,67 @@
+package simplewebsockets
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Matched (with errors.Is) by the error returned for upgrades rejected by WithCSRFProtection.
+var ErrCrossSiteRequest = errors.New("cross-site websocket request")
+
+// Setter to be passed into the creation of a server. Protects cookie authenticated apps against cross-site
+// websocket hijacking, since browsers attach cookies to upgrades started by any site. Upgrades with an Origin
+// header must come from the request's own host or one of trustedOrigins (e.g. "https://app.example.com").
+// Upgrades carrying the named cookie must also repeat its value in the query parameter of the same name,
+// which other sites can't read (double-submit token, see NewCSRFToken). An empty cookie name only checks
+// the Origin. Rejected upgrades get a 403.
+func WithCSRFProtection(cookie string, trustedOrigins ...string) ServerOption {
+	return func(s *Server) {
+		s.csrfCookie = cookie
+		s.checkOrigins = true
+		s.trustedOrigins = trustedOrigins
+	}
+}
+
+// Returns a random token to store in the CSRF cookie and hand to the page's script, see WithCSRFProtection.
+func NewCSRFToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Rejects upgrades another site could have started with the user's cookies.
+func (s *Server) checkCSRF(r *http.Request) error {
+	if origin := r.Header.Get("Origin"); origin != "" && !s.trustedOrigin(origin, r.Host) {
+		return fmt.Errorf("%w: origin %q is not trusted", ErrCrossSiteRequest, origin)
+	}
+
+	if s.csrfCookie == "" {
+		return nil
+	}
+	cookie, err := r.Cookie(s.csrfCookie)
+	if err != nil {
+		return nil // no cookie, nothing ambient to abuse
+	}
+	token := r.URL.Query().Get(s.csrfCookie)
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(cookie.Value)) != 1 {
+		return fmt.Errorf("%w: missing or wrong %s token", ErrCrossSiteRequest, s.csrfCookie)
+	}
+	return nil
+}
+
+// reports whether origin is the request's own host or explicitly trusted
+func (s *Server) trustedOrigin(origin string, host string) bool {
+	for _, trusted := range s.trustedOrigins {
+		if strings.EqualFold(origin, trusted) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, host)
+}
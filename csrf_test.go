@@ -0,0 +1,37 @@
+package simplewebsockets_test
+
+import (
+	"net/http"
+	"testing"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+func TestCSRFProtection(t *testing.T) {
+	valid := []string{"Upgrade: websocket", "Connection: Upgrade", "Sec-WebSocket-Version: 13"}
+	token := simplewebsockets.NewCSRFToken()
+	tests := []struct {
+		name   string
+		target string
+		header []string
+		status int
+	}{
+		{"no origin or cookie", "/", nil, http.StatusSwitchingProtocols},
+		{"own origin", "/", []string{"Origin: http://test"}, http.StatusSwitchingProtocols},
+		{"trusted origin", "/", []string{"Origin: https://APP.example.com"}, http.StatusSwitchingProtocols},
+		{"foreign origin", "/", []string{"Origin: https://evil.example"}, http.StatusForbidden},
+		{"cookie with token", "/?csrf=" + token, []string{"Origin: http://test", "Cookie: csrf=" + token}, http.StatusSwitchingProtocols},
+		{"cookie without token", "/", []string{"Origin: http://test", "Cookie: csrf=" + token}, http.StatusForbidden},
+		{"cookie with wrong token", "/?csrf=" + simplewebsockets.NewCSRFToken(), []string{"Cookie: csrf=" + token}, http.StatusForbidden},
+	}
+
+	listener, _, _ := startServer(t, simplewebsockets.WithCSRFProtection("csrf", "https://app.example.com"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := upgradeResponseFor(t, listener.Addr().String(), tt.target, append(tt.header, valid...)...)
+			if resp.StatusCode != tt.status {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package simplewebsockets
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// RSV1, set on the first frame of a message compressed with permessage-deflate
+const rsvCompressed = 0x4
+
+// Messages smaller than this are sent uncompressed, deflate would barely shrink or even grow them.
+const minCompressSize = 64
+
+// Extension response sent to clients whose permessage-deflate offer we accept. Without context takeover
+// every message is compressed on its own, so connections hold no compression state between messages.
+const deflateResponse = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+// permessage-deflate parameter naming the preset dictionary both sides compress with, see
+// WithCompressionDictionary
+const dictionaryParam = "x-dictionary"
+
+// How a connection compresses messages, if it does.
+type deflateMode byte
+
+const (
+	deflateOff   deflateMode = iota
+	deflatePlain             // permessage-deflate
+	deflateDict              // permessage-deflate with the server's preset dictionary
+)
+
+// ends a deflate stream cut at a sync flush: the removed 0x00 0x00 0xff 0xff (RFC 7692 7.2.2) followed by
+// an empty final block, so the reader sees a clean EOF
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// Setter to be passed into the creation of a server. Negotiates permessage-deflate (RFC 7692) with clients
+// that offer it, and compresses text and binary messages at level (a compress/flate level, e.g.
+// flate.BestSpeed). Clients dialed with Server.Dial offer it as well. Messages inflated beyond the max
+// message size close the connection with 1009.
+func WithCompression(level int) ServerOption {
+	return func(s *Server) {
+		s.compression = true
+		s.compressionLevel = level
+	}
+}
+
+// Setter to be passed into the creation of a server. Compresses with a preset dictionary, e.g. a sample of
+// typical JSON messages, when the peer was configured with the same one, which shrinks small messages that
+// plain deflate can't. The dictionary is negotiated with the x-dictionary parameter of permessage-deflate
+// (see WithCompression), named by a hash of its content; peers without it get plain permessage-deflate.
+// Only the last 32KB of dict are used. dict must not be modified afterwards.
+func WithCompressionDictionary(dict []byte) ServerOption {
+	return func(s *Server) {
+		sum := sha256.Sum256(dict)
+		s.compressionDict = dict
+		s.compressionDictID = hex.EncodeToString(sum[:8])
+	}
+}
+
+// extension header offering permessage-deflate, with the preset dictionary first if there is one
+func (s *Server) deflateOffer() string {
+	if s.compressionDictID == "" {
+		return deflateResponse
+	}
+	return deflateResponse + "; " + dictionaryParam + "=" + s.compressionDictID + ", " + deflateResponse
+}
+
+// Picks the first permessage-deflate offer in the upgrade request we can honor and returns the
+// extension header to answer with, "" if there is none.
+func (s *Server) negotiateDeflate(r *http.Request) (string, deflateMode) {
+	if !s.compression {
+		return "", deflateOff
+	}
+	for _, v := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for offer := range strings.SplitSeq(v, ",") {
+			switch s.acceptDeflateOffer(offer) {
+			case deflatePlain:
+				return deflateResponse, deflatePlain
+			case deflateDict:
+				return deflateResponse + "; " + dictionaryParam + "=" + s.compressionDictID, deflateDict
+			}
+		}
+	}
+	return "", deflateOff
+}
+
+// returns how a permessage-deflate extension offer compresses, deflateOff if it isn't one with
+// parameters we support
+func (s *Server) acceptDeflateOffer(offer string) deflateMode {
+	params := strings.Split(offer, ";")
+	if strings.TrimSpace(params[0]) != "permessage-deflate" {
+		return deflateOff
+	}
+	mode := deflatePlain
+	seen := make(map[string]bool)
+	for _, p := range params[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+		name = strings.TrimSpace(name)
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		if seen[name] {
+			return deflateOff // duplicate parameters invalidate the offer
+		}
+		seen[name] = true
+
+		switch name {
+		case "server_no_context_takeover", "client_no_context_takeover":
+		case "client_max_window_bits":
+			// we inflate with a full window, any limit the client puts on itself is fine
+		case "server_max_window_bits":
+			if value != "15" {
+				return deflateOff // compress/flate always uses a 32KB window
+			}
+		case dictionaryParam:
+			if s.compressionDictID == "" || value != s.compressionDictID {
+				return deflateOff // a dictionary we don't have
+			}
+			mode = deflateDict
+		default:
+			return deflateOff
+		}
+	}
+	return mode
+}
+
+// Compresses a complete message payload as permessage-deflate expects it, with the preset dictionary
+// for mode deflateDict.
+func (s *Server) deflateMessage(data []byte, mode deflateMode) []byte {
+	pool, dict := s.deflaters, []byte(nil)
+	if mode == deflateDict {
+		pool, dict = s.dictDeflaters, s.compressionDict
+	}
+
+	var buf bytes.Buffer
+	w, ok := pool.Get().(*flate.Writer)
+	if ok {
+		w.Reset(&buf) // keeps the dictionary
+	} else {
+		var err error
+		if w, err = flate.NewWriterDict(&buf, s.compressionLevel, dict); err != nil {
+			w, _ = flate.NewWriterDict(&buf, flate.DefaultCompression, dict) // invalid level
+		}
+	}
+	w.Write(data)
+	w.Flush()
+	pool.Put(w)
+
+	// the sync flush ends in 0x00 0x00 0xff 0xff, which isn't sent
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail[:4])
+}
+
+// returns the preset dictionary c's messages are inflated with, nil for none
+func (c *Connection) inflateDict() []byte {
+	if c.deflate == deflateDict {
+		return c.server.compressionDict
+	}
+	return nil
+}
+
+// Decompresses a permessage-deflate message compressed with dict (nil for none), failing with
+// ErrMessageTooLarge beyond limit bytes (0 means no limit).
+func inflateMessage(data []byte, dict []byte, limit int64) ([]byte, error) {
+	r := flate.NewReaderDict(io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail)), dict)
+	defer r.Close()
+
+	var src io.Reader = r
+	if limit > 0 {
+		src = io.LimitReader(r, limit+1)
+	}
+	out, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && int64(len(out)) > limit {
+		return nil, fmt.Errorf("%w: inflated beyond %d bytes", ErrMessageTooLarge, limit)
+	}
+	return out, nil
+}
@@ -0,0 +1,126 @@
+package simplewebsockets_test
+
+import (
+	"compress/flate"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+// Sends msg from a client built with clientOpts to a server built with serverOpts and back. Returns the
+// compression stats of the server's end.
+func compressedEcho(t *testing.T, msg string, serverOpts, clientOpts []simplewebsockets.ServerOption) simplewebsockets.CompressionStats {
+	t.Helper()
+	s, url, conns := startServer(t, serverOpts...)
+	s.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) {
+		c.SendTextMessage(string(msg))
+	})
+
+	got := make(chan string, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := simplewebsockets.NewServer(clientOpts...).Dial(ctx, url, simplewebsockets.WithConnOptions(
+		simplewebsockets.WithMessageHandler(func(msg []byte) { got <- string(msg) })))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close(1000, "")
+	sc := <-conns
+
+	if err := c.SendTextMessage(msg); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	select {
+	case echo := <-got:
+		if echo != msg {
+			t.Fatalf("echo %q, want %q", echo, msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no echo")
+	}
+	return sc.Stats().Compression
+}
+
+func TestCompressionDictionary(t *testing.T) {
+	dict := []byte(`{"type":"position","player":"","x":,"y":,"heading":"north","timestamp":}`)
+	msg := `{"type":"position","player":"p1","x":12,"y":40,"heading":"north","timestamp":1700000000}`
+	compression := simplewebsockets.WithCompression(flate.BestCompression)
+	other := simplewebsockets.WithCompressionDictionary([]byte(`{"kind":"chat","text":""}`))
+
+	plain := compressedEcho(t, msg,
+		[]simplewebsockets.ServerOption{compression},
+		[]simplewebsockets.ServerOption{compression})
+	shared := compressedEcho(t, msg,
+		[]simplewebsockets.ServerOption{compression, simplewebsockets.WithCompressionDictionary(dict)},
+		[]simplewebsockets.ServerOption{compression, simplewebsockets.WithCompressionDictionary(dict)})
+	if shared.InWire == 0 || shared.InWire >= plain.InWire/2 || shared.OutWire != shared.InWire {
+		t.Errorf("with the dictionary %+v, without %+v, want the dictionary to halve the size both ways", shared, plain)
+	}
+
+	// peers without the same dictionary fall back to plain permessage-deflate
+	for name, clientOpts := range map[string][]simplewebsockets.ServerOption{
+		"no dictionary":    {compression},
+		"other dictionary": {compression, other},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got := compressedEcho(t, msg,
+				[]simplewebsockets.ServerOption{compression, simplewebsockets.WithCompressionDictionary(dict)}, clientOpts)
+			if got != plain {
+				t.Errorf("compression %+v, want %+v as without a dictionary", got, plain)
+			}
+		})
+	}
+}
+
+func TestCompression(t *testing.T) {
+	compression := []simplewebsockets.ServerOption{simplewebsockets.WithCompression(flate.BestSpeed)}
+	long := strings.Repeat(`{"type":"position","x":12,"y":40}`, 32)
+
+	got := compressedEcho(t, long, compression, compression)
+	if got.InRaw != int64(len(long)) || got.OutRaw != got.InRaw || got.InWire == 0 || got.InWire > got.InRaw/4 {
+		t.Errorf("compression %+v, want %d raw bytes shrunk to a quarter both ways", got, len(long))
+	}
+
+	// small messages and peers without compression go uncompressed
+	if got := compressedEcho(t, "short", compression, compression); got != (simplewebsockets.CompressionStats{}) {
+		t.Errorf("compression %+v for a short message, want none", got)
+	}
+	if got := compressedEcho(t, long, compression, nil); got != (simplewebsockets.CompressionStats{}) {
+		t.Errorf("compression %+v with a client that doesn't offer it, want none", got)
+	}
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	valid := []string{"Upgrade: websocket", "Connection: Upgrade", "Sec-WebSocket-Version: 13"}
+	accepted := "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+	tests := []struct {
+		name  string
+		offer string
+		want  string
+	}{
+		{"plain", "permessage-deflate", accepted},
+		{"client window bits", "permessage-deflate; client_max_window_bits", accepted},
+		{"smaller server window", "permessage-deflate; server_max_window_bits=10", ""},
+		{"unknown parameter", "permessage-deflate; foo=1", ""},
+		{"duplicate parameter", "permessage-deflate; client_no_context_takeover; client_no_context_takeover", ""},
+		{"first acceptable offer", "permessage-deflate; server_max_window_bits=10, permessage-deflate", accepted},
+		{"other extension", "x-webkit-deflate-frame", ""},
+	}
+
+	listener, _, _ := startServer(t, simplewebsockets.WithCompression(flate.BestSpeed))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := upgradeResponse(t, listener.Addr().String(), append(valid, "Sec-WebSocket-Extensions: "+tt.offer)...)
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+			}
+			if got := resp.Header.Get("Sec-WebSocket-Extensions"); got != tt.want {
+				t.Errorf("extensions %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package simplewebsockets
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Sort order for Server.TopConnections.
+type TopBy int
+
+const (
+	ByReadLoopTime TopBy = iota // wall clock time spent parsing frames plus time spent in handlers
+	ByBytes                     // inbound plus outbound bytes
+)
+
+// Per connection entry of a TopConnections report. Times are wall clock estimates scaled up from the sampled
+// frames, so a handler that blocks or sleeps counts as much as one that computes.
+type ConnectionReport struct {
+	ID          uint64
+	Tenant      string
+	RemoteAddr  string
+	ParseTime   time.Duration
+	HandlerTime time.Duration
+	Usage
+}
+
+// Total estimated wall clock time the read loop spent on the connection.
+func (r ConnectionReport) ReadLoopTime() time.Duration {
+	return r.ParseTime + r.HandlerTime
+}
+
+// sampled read loop timings of a connection
+type readLoopTimings struct {
+	frames      int // only touched by the read loop
+	sampling    bool
+	parseTime   atomic.Int64
+	handlerTime atomic.Int64
+}
+
+// Setter to be passed into the creation of a server. Enables the read loop diagnostic mode which times frame
+// parsing and message handlers for one in every sampleEvery frames (1 times every frame). Used by TopConnections.
+func WithDiagnostics(sampleEvery int) ServerOption {
+	return func(s *Server) {
+		s.diagSampleEvery = sampleEvery
+	}
+}
+
+// Returns up to n open connections with the highest read loop cost or traffic, for finding hot clients.
+// Read loop times stay zero unless the server was created WithDiagnostics.
+func (s *Server) TopConnections(n int, by TopBy) []ConnectionReport {
+	s.connectionsMx.RLock()
+	reports := make([]ConnectionReport, 0, len(s.connections))
+	for c := range s.connections {
+		reports = append(reports, ConnectionReport{
+			ID:          c.id,
+			Tenant:      c.tenant.id,
+			RemoteAddr:  c.conn.RemoteAddr().String(),
+			ParseTime:   time.Duration(c.timings.parseTime.Load()),
+			HandlerTime: time.Duration(c.timings.handlerTime.Load()),
+			Usage:       c.usage.snapshot(),
+		})
+	}
+	s.connectionsMx.RUnlock()
+
+	sort.Slice(reports, func(i, j int) bool {
+		if by == ByBytes {
+			return reports[i].BytesIn+reports[i].BytesOut > reports[j].BytesIn+reports[j].BytesOut
+		}
+		return reports[i].ReadLoopTime() > reports[j].ReadLoopTime()
+	})
+
+	if n >= 0 && n < len(reports) {
+		reports = reports[:n]
+	}
+	return reports
+}
+
+// decides whether the next frame read is timed, called once per frame from the read loop
+func (c *Connection) sampleFrame() bool {
+	every := c.server.diagSampleEvery
+	if every <= 0 {
+		c.timings.sampling = false
+		return false
+	}
+	c.timings.frames++
+	c.timings.sampling = c.timings.frames%every == 0
+	return c.timings.sampling
+}
+
+// adds a sampled duration, scaled by the sample rate
+func (c *Connection) addSample(counter *atomic.Int64, start time.Time) {
+	counter.Add(int64(time.Since(start)) * int64(c.server.diagSampleEvery))
+}
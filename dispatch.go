@@ -0,0 +1,194 @@
+package simplewebsockets
+
+import (
+	"hash/fnv"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Size of each worker's (or connection's) task queue. A full queue blocks the read loop that is submitting to it.
+const workerQueueSize = 256
+
+// Where OnMessage handlers run.
+type DispatchMode int
+
+const (
+	DispatchInline        DispatchMode = iota // on the connection's read loop (default)
+	DispatchSharedPool                        // on a pool of workers shared by all connections, see WithWorkerPool
+	DispatchPerConnection                     // on a dedicated goroutine per connection with a bounded queue
+)
+
+// Fixed set of goroutines running message handlers. Every worker has its own queue so tasks
+// submitted with the same key always run on the same worker, in submission order.
+type workerPool struct {
+	queues []chan func()
+	next   atomic.Uint64
+	done   chan struct{}
+}
+
+func newWorkerPool(n int) *workerPool {
+	p := &workerPool{queues: make([]chan func(), n), done: make(chan struct{})}
+	for i := range p.queues {
+		queue := make(chan func(), workerQueueSize)
+		p.queues[i] = queue
+		spawn("dispatch worker", func() { p.work(queue) })
+	}
+	return p
+}
+
+func (p *workerPool) work(queue chan func()) {
+	for {
+		select {
+		case task := <-queue:
+			task()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Stops the workers. Tasks still queued or submitted later are dropped.
+func (p *workerPool) stop() {
+	close(p.done)
+}
+
+// Queues a task. Tasks with an empty key are spread round robin across the workers.
+func (p *workerPool) submit(key string, task func()) {
+	var i uint64
+	if key == "" {
+		i = p.next.Add(1)
+	} else {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		i = h.Sum64()
+	}
+	select {
+	case p.queues[i%uint64(len(p.queues))] <- task:
+	case <-p.done:
+	}
+}
+
+// Setter to be passed into the creation of a server. Runs OnMessage handlers on a pool of n worker
+// goroutines instead of the connection's read loop. Without WithOrderingKey messages may be handled
+// out of order, even for a single connection.
+func WithWorkerPool(n int) ServerOption {
+	return func(s *Server) {
+		s.workers = n
+		s.dispatchMode = DispatchSharedPool
+	}
+}
+
+// Setter to be passed into the creation of a server. DispatchPerConnection guarantees strict per connection
+// ordering while isolating slow handlers of one connection from the others. DispatchSharedPool without
+// WithWorkerPool uses one worker per CPU.
+func WithDispatchMode(mode DispatchMode) ServerOption {
+	return func(s *Server) {
+		s.dispatchMode = mode
+	}
+}
+
+// Setter to be passed into the creation of a server. When the worker pool is enabled, messages with the same
+// key (e.g. the same document id) are handled in order while different keys run in parallel.
+// Messages for which fn returns "" have no ordering guarantee.
+func WithOrderingKey(fn func(msg []byte) string) ServerOption {
+	return func(s *Server) {
+		s.orderingKey = fn
+	}
+}
+
+// Starts the server's shared workers if the dispatch mode needs them.
+func (s *Server) startDispatch() {
+	if s.dispatchMode != DispatchSharedPool {
+		return
+	}
+	if s.runtime != nil {
+		s.pool = s.runtime.workerPool()
+		return
+	}
+	if s.workers <= 0 {
+		s.workers = runtime.NumCPU()
+	}
+	s.pool = newWorkerPool(s.workers)
+}
+
+// Starts the handler goroutine of a connection in DispatchPerConnection mode. The returned
+// function stops it after the queued handlers have run.
+func (c *Connection) startHandlerQueue() (stop func()) {
+	if c.server.dispatchMode != DispatchPerConnection {
+		return func() {}
+	}
+	c.handlerQueue = make(chan func(), workerQueueSize)
+	c.handlerQueueDone = make(chan struct{})
+	spawn("handler queue", func() {
+		for {
+			select {
+			case task := <-c.handlerQueue:
+				task()
+			case <-c.handlerQueueDone:
+				// run what was queued before the connection ended
+				for {
+					select {
+					case task := <-c.handlerQueue:
+						task()
+					default:
+						return
+					}
+				}
+			}
+		}
+	})
+	return func() { close(c.handlerQueueDone) }
+}
+
+// Runs the connection's message handler according to the server's dispatch configuration.
+func (s *Server) dispatch(c *Connection, handler messageHandler, mt MessageType, data []byte, trace string) {
+	sampled := c.timings.sampling
+
+	run := func(data []byte) {
+		if sampled {
+			defer c.addSample(&c.timings.handlerTime, time.Now())
+		}
+		switch {
+		case handler.typedFn != nil:
+			handler.typedFn(mt, data)
+		case handler.fn != nil:
+			handler.fn(data)
+		default:
+			handler.ctxFn(c.messageContext(trace), c, data)
+		}
+	}
+
+	if s.dispatchMode == DispatchInline {
+		run(data)
+		return
+	}
+
+	// the read loop reuses its message buffer, so the handler needs its own copy
+	data = append([]byte(nil), data...)
+
+	if s.dispatchMode == DispatchPerConnection {
+		// a handler set late may still flush buffered messages after the queue stopped
+		select {
+		case c.handlerQueue <- func() { run(data) }:
+		case <-c.handlerQueueDone:
+		}
+		return
+	}
+
+	key := ""
+	if s.orderingKey != nil {
+		key = s.orderingKey(data)
+	}
+	s.pool.submit(key, func() { run(data) })
+}
+
+// Setter to be passed into the creation of a server. Unfragmented messages are handed to OnMessage as a slice
+// of the connection's read buffer instead of a copy. The slice is only valid until OnMessage returns and must
+// not be retained or modified after that. Handlers run off the read loop (see WithDispatchMode) and buffered
+// unhandled messages still get a copy.
+func WithZeroCopyReads() ServerOption {
+	return func(s *Server) {
+		s.zeroCopyReads = true
+	}
+}
@@ -0,0 +1,119 @@
+package simplewebsockets_test
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+// Reports whether a frame with the opcode arrives on c within d.
+func frameWithin(c *wstest.SimClient, opcode byte, d time.Duration) bool {
+	got := make(chan bool, 1)
+	go func() {
+		for {
+			f, err := c.NextFrame()
+			if err != nil {
+				got <- false
+				return
+			}
+			if f.Opcode == opcode {
+				got <- true
+				return
+			}
+		}
+	}()
+	select {
+	case ok := <-got:
+		return ok
+	case <-time.After(d):
+		return false
+	}
+}
+
+// A handler blocked on one connection holds up different things depending on the dispatch mode.
+func TestDispatchModes(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []simplewebsockets.ServerOption
+		// while a handler of one connection blocks
+		readsOn       bool // its read loop keeps answering pings
+		othersHandled bool // messages of other connections are handled
+	}{
+		{"inline", []simplewebsockets.ServerOption{simplewebsockets.WithDispatchMode(simplewebsockets.DispatchInline)}, false, true},
+		{"per connection", []simplewebsockets.ServerOption{simplewebsockets.WithDispatchMode(simplewebsockets.DispatchPerConnection)}, true, true},
+		{"shared pool", []simplewebsockets.ServerOption{simplewebsockets.WithWorkerPool(1)}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := wstest.NewSimulation(1957, tt.opts...)
+			blocked, release := make(chan struct{}), make(chan struct{})
+			handled := make(chan string, 16)
+			sim.Server.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) {
+				if string(msg) == "block" {
+					close(blocked)
+					<-release
+				}
+				handled <- string(msg)
+			})
+			a, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+			if err != nil {
+				t.Fatalf("connect: %v", err)
+			}
+			defer a.Abort()
+			b, err := sim.Connect(rand.New(rand.NewSource(sim.Seed + 1)))
+			if err != nil {
+				t.Fatalf("connect: %v", err)
+			}
+			defer b.Abort()
+
+			a.SendText("block")
+			select {
+			case <-blocked:
+			case <-time.After(5 * time.Second):
+				t.Fatal("handler didn't run")
+			}
+			// net.Pipe writes wait for the server to read them
+			go func() {
+				a.SendText("a1")
+				a.SendText("a2")
+				a.Ping([]byte("alive"))
+			}()
+			b.SendText("b1")
+
+			if pong := frameWithin(a, 0xA, 100*time.Millisecond); pong != tt.readsOn {
+				t.Errorf("pong while the handler blocks: %v, want %v", pong, tt.readsOn)
+			}
+			select {
+			case msg := <-handled:
+				if !tt.othersHandled || msg != "b1" {
+					t.Errorf("handled %q while a handler blocks", msg)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.othersHandled {
+					t.Error("other connection's message wasn't handled while a handler blocks")
+				}
+			}
+
+			close(release)
+			var order []string
+			for len(order) < 3 {
+				select {
+				case msg := <-handled:
+					if msg != "b1" {
+						order = append(order, msg)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("handled %q, then nothing", order)
+				}
+			}
+			if order[0] != "block" || order[1] != "a1" || order[2] != "a2" {
+				t.Errorf("connection's messages handled in order %q", order)
+			}
+		})
+	}
+}
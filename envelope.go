@@ -0,0 +1,82 @@
+package simplewebsockets
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// JSON event envelope sent as a text message, {"event": "...", "data": ...}, so clients can route
+// messages by name without inspecting the payload.
+type Event struct {
+	Name    string          `json:"event"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	TraceID string          `json:"trace_id,omitempty"` // see EnvelopeTraceID
+	From    string          `json:"from,omitempty"`     // the sender, see WithSenderAttribution
+}
+
+// Marshals v and sends it to the connection as a text message.
+func (c *Connection) SendJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.SendTextMessage(string(data))
+}
+
+// Sets the message handler of c (see SetOnMessage) to one that unmarshals every message into a T and passes it
+// to fn. A message that doesn't unmarshal closes the connection with 1007 and ErrInvalidJSON.
+func OnJSON[T any](c *Connection, fn func(T)) {
+	c.SetOnMessage(func(data []byte) {
+		var v T
+		if err := json.Unmarshal(data, &v); err != nil {
+			c.closeWithError(fmt.Errorf("%w: %v", ErrInvalidJSON, err), 1007, "Invalid JSON")
+			return
+		}
+		fn(v)
+	})
+}
+
+// Marshals v once and sends it as a text message to every member of the room.
+func (r *Room) BroadcastJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return r.Broadcast(TextMessage, data).Err
+}
+
+// Wraps v in an event envelope named event and sends it to every member of the room.
+func (r *Room) Emit(event string, v any) error {
+	data, err := marshalEvent(event, v)
+	if err != nil {
+		return err
+	}
+	return r.Broadcast(TextMessage, data).Err
+}
+
+// Like Emit, for events sent on behalf of connection c, see BroadcastFrom. The envelope names c in its "from"
+// field if WithSenderAttribution is set.
+func (r *Room) EmitFrom(c *Connection, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	envelope, err := json.Marshal(Event{Name: event, Data: data, From: r.tenant.server.attribute(c)})
+	if err != nil {
+		return err
+	}
+	return r.BroadcastFrom(c, TextMessage, envelope).Err
+}
+
+// Emits an event to a room of the default tenant, see Room.Emit.
+func (s *Server) EmitToRoom(room string, event string, v any) error {
+	return s.Room(room).Emit(event, v)
+}
+
+func marshalEvent(event string, v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Event{Name: event, Data: data})
+}
@@ -0,0 +1,113 @@
+package simplewebsockets
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Subprotocol whose versions WithEnvelopeVersions negotiates on servers without WithSubprotocols.
+const EnvelopeProtocol = "simplewebsockets"
+
+// Matched (with errors.Is) by the error of a handshake in which the peers speak no envelope version in common.
+var ErrEnvelopeVersion = errors.New("no envelope version in common")
+
+// Setter to be passed into the creation of a server. The versions of the library's envelopes (Event, binary
+// events, checksums) and of the protocols built on them that the server speaks, negotiated with a subprotocol
+// suffix: clients offer "<protocol>.v<N>" for every version N they speak, e.g. "chat.v2, chat.v1" for a
+// protocol of WithSubprotocols, or EnvelopeProtocol without them. The highest version both speak is picked
+// and returned by Connection.EnvelopeVersion, so the envelope can change for newer clients while older ones
+// keep working. Clients dialed with Server.Dial offer the versions of their server, unless they offer
+// subprotocols of their own with WithDialSubprotocols.
+//
+// Clients that offer no version predate the negotiation and get version 1, as long as the server lists it.
+// Clients that only offer versions the server doesn't speak are refused with 400 Bad Request.
+func WithEnvelopeVersions(versions ...int) ServerOption {
+	return func(s *Server) {
+		s.envelopeVersions = slices.SortedFunc(slices.Values(versions), func(a, b int) int { return cmp.Compare(b, a) })
+	}
+}
+
+// Returns the envelope version agreed on during the handshake, see WithEnvelopeVersions. 0 without it.
+func (c *Connection) EnvelopeVersion() int {
+	return c.envelopeVersion
+}
+
+// the subprotocols whose versions are negotiated
+func (s *Server) versionedProtocols() []string {
+	if len(s.subprotocols) > 0 {
+		return s.subprotocols
+	}
+	return []string{EnvelopeProtocol}
+}
+
+// returns the version of p if it is a versioned protocol, 0 if it isn't
+func (s *Server) protocolVersion(p string) int {
+	base, suffix, ok := cutLast(p, ".v")
+	if !ok || !slices.Contains(s.versionedProtocols(), base) {
+		return 0
+	}
+	v, err := strconv.Atoi(suffix)
+	if err != nil || v < 1 {
+		return 0
+	}
+	return v
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// Subprotocols in the order the server prefers them: with WithEnvelopeVersions every versioned protocol at
+// each version, newest first, then the protocols without a version for clients that predate the negotiation.
+func (s *Server) subprotocolPreference() []string {
+	if len(s.envelopeVersions) == 0 {
+		return s.subprotocols
+	}
+	var prefs []string
+	for _, p := range s.versionedProtocols() {
+		for _, v := range s.envelopeVersions {
+			prefs = append(prefs, p+".v"+strconv.Itoa(v))
+		}
+	}
+	return append(prefs, s.subprotocols...)
+}
+
+// Returns the envelope version of a connection that agreed on subprotocol p, after offering the given
+// subprotocols. See WithEnvelopeVersions for the rules.
+func (s *Server) envelopeVersion(offered []string, p string) (int, error) {
+	if len(s.envelopeVersions) == 0 {
+		return 0, nil
+	}
+	if v := s.protocolVersion(p); slices.Contains(s.envelopeVersions, v) {
+		return v, nil
+	}
+	if slices.ContainsFunc(offered, func(o string) bool { return s.protocolVersion(o) > 0 }) {
+		return 0, fmt.Errorf("%w: offered %q, the server speaks %v", ErrEnvelopeVersion, offered, s.envelopeVersions)
+	}
+	if !slices.Contains(s.envelopeVersions, 1) {
+		return 0, fmt.Errorf("%w: the peer predates version negotiation, the server dropped version 1", ErrEnvelopeVersion)
+	}
+	return 1, nil
+}
+
+// Returns the header of a dial, with the versioned subprotocols offered in it unless it offers subprotocols
+// of its own.
+func (s *Server) offerEnvelopeVersions(h http.Header) http.Header {
+	if len(s.envelopeVersions) == 0 || len(offeredSubprotocols(h)) > 0 {
+		return h
+	}
+	h = h.Clone()
+	if h == nil {
+		h = make(http.Header)
+	}
+	h.Set("Sec-WebSocket-Protocol", strings.Join(s.subprotocolPreference(), ", "))
+	return h
+}
@@ -0,0 +1,78 @@
+package simplewebsockets_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+func TestEnvelopeVersionNegotiation(t *testing.T) {
+	tests := []struct {
+		name           string
+		server, client []simplewebsockets.ServerOption
+		offer          []string // subprotocols offered with WithDialSubprotocols
+		subprotocol    string
+		serverVersion  int
+		clientVersion  int // -1 if the server refuses the client
+	}{
+		{"newest in common",
+			[]simplewebsockets.ServerOption{simplewebsockets.WithEnvelopeVersions(1, 2)},
+			[]simplewebsockets.ServerOption{simplewebsockets.WithEnvelopeVersions(3, 2, 1)},
+			nil, "simplewebsockets.v2", 2, 2},
+		{"client predates negotiation",
+			[]simplewebsockets.ServerOption{simplewebsockets.WithEnvelopeVersions(1, 2)},
+			nil, nil, "", 1, 0},
+		{"server predates negotiation",
+			nil,
+			[]simplewebsockets.ServerOption{simplewebsockets.WithEnvelopeVersions(1, 2)},
+			nil, "", 0, 1},
+		{"versioned application protocol",
+			[]simplewebsockets.ServerOption{simplewebsockets.WithSubprotocols("chat"), simplewebsockets.WithEnvelopeVersions(1, 2)},
+			nil, []string{"chat.v1", "chat"}, "chat.v1", 1, 0},
+		{"application protocol without version",
+			[]simplewebsockets.ServerOption{simplewebsockets.WithSubprotocols("chat"), simplewebsockets.WithEnvelopeVersions(1, 2)},
+			nil, []string{"chat"}, "chat", 1, 0},
+		{"no version in common",
+			[]simplewebsockets.ServerOption{simplewebsockets.WithEnvelopeVersions(1, 2)},
+			[]simplewebsockets.ServerOption{simplewebsockets.WithEnvelopeVersions(3)},
+			nil, "", 0, -1},
+		{"version 1 dropped",
+			[]simplewebsockets.ServerOption{simplewebsockets.WithEnvelopeVersions(2)},
+			nil, nil, "", 0, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, url, conns := startServer(t, tt.server...)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			var opts []simplewebsockets.DialOption
+			if tt.offer != nil {
+				opts = append(opts, simplewebsockets.WithDialSubprotocols(tt.offer...))
+			}
+			c, err := simplewebsockets.NewServer(tt.client...).Dial(ctx, url, opts...)
+			if tt.clientVersion < 0 {
+				if !errors.Is(err, simplewebsockets.ErrBadHandshake) {
+					t.Fatalf("dial: %v, want the server to refuse the handshake", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer c.Close(1000, "")
+			sc := <-conns
+
+			if c.Subprotocol() != tt.subprotocol || sc.Subprotocol() != tt.subprotocol {
+				t.Errorf("subprotocol %q on the client, %q on the server, want %q", c.Subprotocol(), sc.Subprotocol(), tt.subprotocol)
+			}
+			if c.EnvelopeVersion() != tt.clientVersion || sc.EnvelopeVersion() != tt.serverVersion {
+				t.Errorf("version %d on the client, %d on the server, want %d and %d",
+					c.EnvelopeVersion(), sc.EnvelopeVersion(), tt.clientVersion, tt.serverVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package simplewebsockets
+
+import "errors"
+
+// Errors that make the server close a connection. A close code mapper (WithCloseCodeMapper) can match
+// them with errors.Is to pick the code and reason sent to the peer.
+var (
+	ErrUnexpectedContinuation = errors.New("continuation frame without initial frame")
+	ErrMessageInProgress      = errors.New("new data frame while message in progress")
+	ErrUnknownOpcode          = errors.New("unknown opcode")
+	ErrFrameTooLarge          = errors.New("frame exceeds maximum frame size")
+	ErrMessageRateLimited     = errors.New("message rate limit exceeded")
+	ErrBandwidthLimited       = errors.New("bandwidth limit exceeded")
+	ErrTooManyFragments       = errors.New("message exceeds maximum number of fragments")
+	ErrFrameReadTimeout       = errors.New("frame not completed within the frame read timeout")
+	ErrInvalidCloseFrame      = errors.New("invalid close frame")
+	ErrPingTimeout            = errors.New("peer stopped answering pings")
+	ErrUnmaskedFrame          = errors.New("client sent an unmasked frame")
+	ErrInvalidUTF8            = errors.New("text message is not valid UTF-8")
+	ErrReservedBits           = errors.New("frame sets reserved bits without a negotiated extension")
+	ErrMessageTooLarge        = errors.New("message exceeds maximum message size")
+	ErrPongTimeout            = errors.New("no pong within the pong timeout")
+	ErrInvalidControlFrame    = errors.New("control frame is fragmented or longer than 125 bytes")
+	ErrInvalidJSON            = errors.New("message is not valid JSON for its handler")
+	ErrInvalidValue           = errors.New("message doesn't decode with the server's codec")
+)
+
+// Setter to be passed into the creation of a server. When an error forces the server to close a connection,
+// fn picks the close code and reason sent to the peer (e.g. mapping auth errors to 4401). Returning a code
+// of 0 keeps the library's default for that error.
+func WithCloseCodeMapper(fn func(err error) (uint16, string)) ServerOption {
+	return func(s *Server) {
+		s.closeCodeMapper = fn
+	}
+}
+
+// Closes the connection because of err, letting the close code mapper override the default status and reason.
+// Returns err so call sites can pass it on.
+func (c *Connection) closeWithError(err error, status uint16, reason string) error {
+	c.setCloseError(err)
+	status, reason = c.server.mapCloseError(err, status, reason)
+	c.Close(status, reason)
+	return err
+}
+
+// applies the close code mapper to the default status and reason for err
+func (s *Server) mapCloseError(err error, status uint16, reason string) (uint16, string) {
+	if mapper := s.closeCodeMapper; mapper != nil {
+		if code, r := mapper(err); code != 0 {
+			return code, r
+		}
+	}
+	return status, reason
+}
+
+// How a connection ended.
+type Disposition struct {
+	Clean  bool   // the closing handshake completed
+	Code   uint16 // close code in the peer's close frame, 1005 if it had none, 1006 if no close frame arrived
+	Reason string
+	Err    error // protocol or limit violation, or the read or write error that lost the connection, nil for a normal close
+}
+
+// Returns how the connection ended, false while it is still open.
+func (c *Connection) Disposition() (Disposition, bool) {
+	c.closeMx.Lock()
+	defer c.closeMx.Unlock()
+	if c.disposition == nil {
+		return Disposition{}, false
+	}
+	return *c.disposition, true
+}
+
+// records the error that is ending the connection, the first one wins
+func (c *Connection) setCloseError(err error) {
+	c.closeMx.Lock()
+	defer c.closeMx.Unlock()
+	if c.closeErr == nil {
+		c.closeErr = err
+	}
+}
@@ -0,0 +1,143 @@
+package simplewebsockets
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Published after a connection completed its handshake.
+type ConnectEvent struct {
+	Conn *Connection
+}
+
+// Published exactly once when a connection ends, carrying how it ended. It is the single terminal event of a
+// connection; ErrorEvent is only used for problems the connection survives.
+type DisconnectEvent struct {
+	Conn *Connection
+	Disposition
+}
+
+// Published for every complete data message received, before the connection's OnMessage runs.
+// Data is only valid during the handler.
+type MessageEvent struct {
+	Conn    *Connection
+	Type    MessageType
+	Data    []byte
+	TraceID string // see WithTraceIDs
+}
+
+// Published for errors that don't end a connection by themselves (a dropped message, a failed accept or handshake).
+// Conn is nil for errors that happen before a connection exists. Errors that end a connection are reported
+// in DisconnectEvent.Err instead.
+type ErrorEvent struct {
+	Conn *Connection
+	Err  error
+}
+
+// Published after a connection joined a room.
+type RoomJoinEvent struct {
+	Conn *Connection
+	Room *Room
+}
+
+// Published after a connection left a room, including when it disconnects.
+type RoomLeaveEvent struct {
+	Conn *Connection
+	Room *Room
+}
+
+// Published for every message a connection sends to a room (Room.BroadcastFrom, Room.EmitFrom), before the
+// members get it. Data is only valid during the handler.
+type RoomMessageEvent struct {
+	Room   *Room
+	Sender *Connection
+	Type   MessageType
+	Data   []byte
+}
+
+// Published when the load shedding level changes, with the probe under the highest pressure.
+type ShedEvent struct {
+	Level    ShedLevel
+	Previous ShedLevel
+	Probe    string
+	Pressure float64
+}
+
+// Typed publish/subscribe bus connecting the server's subsystems with the application.
+// Handlers run synchronously on the publishing goroutine, in subscription order.
+type EventBus struct {
+	mx       sync.RWMutex
+	nextID   uint64
+	handlers map[reflect.Type][]subscription
+}
+
+type subscription struct {
+	id uint64
+	fn any // func(E)
+}
+
+func newEventBus() *EventBus {
+	return &EventBus{handlers: make(map[reflect.Type][]subscription)}
+}
+
+// Returns the server's event bus.
+func (s *Server) Events() *EventBus {
+	return s.events
+}
+
+// Registers fn for events of type E, e.g. Subscribe(s.Events(), func(e RoomJoinEvent) { ... }).
+// Returns a function that removes the subscription.
+func Subscribe[E any](b *EventBus, fn func(E)) (unsubscribe func()) {
+	t := reflect.TypeFor[E]()
+
+	b.mx.Lock()
+	b.nextID++
+	id := b.nextID
+	b.handlers[t] = append(b.handlers[t], subscription{id: id, fn: fn})
+	b.mx.Unlock()
+
+	return func() {
+		b.mx.Lock()
+		defer b.mx.Unlock()
+		subs := b.handlers[t]
+		for i, sub := range subs {
+			if sub.id == id {
+				b.handlers[t] = append(subs[:i:i], subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Calls every handler subscribed to events of type E.
+func Publish[E any](b *EventBus, event E) {
+	b.mx.RLock()
+	subs := b.handlers[reflect.TypeFor[E]()]
+	b.mx.RUnlock()
+
+	for _, sub := range subs {
+		sub.fn.(func(E))(event)
+	}
+}
+
+// Reports whether anyone is subscribed to events of type E, to skip building expensive events.
+func hasSubscribers[E any](b *EventBus) bool {
+	b.mx.RLock()
+	defer b.mx.RUnlock()
+	return len(b.handlers[reflect.TypeFor[E]()]) > 0
+}
+
+// replaces the subscription held in *current with fn, used by the OnX setters
+func (s *Server) setHandler(current *func(), subscribe func() func()) {
+	s.setterMx.Lock()
+	defer s.setterMx.Unlock()
+	if *current != nil {
+		(*current)()
+	}
+	*current = subscribe()
+}
+
+// publishes an error event
+func (s *Server) emitError(c *Connection, err error) {
+	Publish(s.events, ErrorEvent{Conn: c, Err: err})
+}
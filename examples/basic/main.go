@@ -29,7 +29,7 @@ func main() {
 	})
 
 	myServer.OnDisconnect(func(c *simplewebsockets.Connection) {
-		fmt.Println("Client disconnected cleanly")
+		fmt.Println("Client disconnected")
 	})
 
 	myServer.OnError(func(c *simplewebsockets.Connection, err error) {
@@ -0,0 +1,76 @@
+package simplewebsockets
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Negotiates the extensions of a new connection and returns the Sec-WebSocket-Extensions header to answer
+// with, "" if none was agreed on. permessage-deflate and the run-length extension exclude each other.
+func (s *Server) negotiateExtensions(c *Connection, r *http.Request) string {
+	if ext, mode := s.negotiateDeflate(r); mode != deflateOff {
+		c.deflate = mode
+		return ext
+	}
+
+	var offers []string
+	for _, v := range r.Header.Values("Sec-WebSocket-Extensions") {
+		offers = append(offers, strings.Split(v, ",")...)
+	}
+	if s.negotiateRLE(offers) {
+		c.rle = true
+		return rleExtension
+	}
+	return ""
+}
+
+// reports whether the reserved bits set on a frame belong to a negotiated extension, only the first frame
+// of a data message may set them
+func (c *Connection) extensionRSV(fr *Frame) bool {
+	switch {
+	case fr.Opcode != 0x1 && fr.Opcode != 0x2:
+		return false
+	case fr.RSV == rsvCompressed:
+		return c.deflate != deflateOff
+	case fr.RSV == rsvRLE:
+		return c.rle && fr.Opcode == 0x1
+	}
+	return false
+}
+
+// Picks the extension for a message of size bytes and returns the RSV bits marking it, 0 to send it as is.
+func (c *Connection) messageTransform(opcode byte, size int) byte {
+	switch {
+	case c.deflate != deflateOff && size >= minCompressSize:
+		return rsvCompressed
+	case c.rle && opcode == 0x1:
+		return rsvRLE
+	}
+	return 0
+}
+
+// Encodes a message payload with the extension picked by messageTransform, compressing in the given mode.
+// Returns the payload to send and its RSV bits, which are 0 if the encoding wouldn't have shrunk the payload.
+func (s *Server) transformPayload(rsv byte, mode deflateMode, payload []byte) ([]byte, byte) {
+	switch rsv {
+	case rsvCompressed:
+		return s.deflateMessage(payload, mode), rsvCompressed
+	case rsvRLE:
+		if encoded := encodeRLE(payload); len(encoded) < len(payload) {
+			return encoded, rsvRLE
+		}
+	}
+	return payload, 0
+}
+
+// Splits a message into frames of at most fs payload bytes, encoding it first with the negotiated extension.
+func (c *Connection) messageFrames(opcode byte, payload []byte, fs int) []Frame {
+	encoded, rsv := c.server.transformPayload(c.messageTransform(opcode, len(payload)), c.deflate, payload)
+	if rsv != 0 {
+		c.countCompressed(Outbound, len(payload), len(encoded))
+	}
+	frames := msgToFrames(encoded, fs)
+	frames[0].Opcode = opcode
+	frames[0].RSV = rsv
+	return frames
+}
@@ -0,0 +1,72 @@
+package simplewebsockets
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Signals derived from an upgrade request, for anti-abuse systems that score clients. Fields that
+// can't be observed for a connection are left empty.
+type ClientFingerprint struct {
+	RemoteAddr  net.Addr
+	HeaderOrder []string // header names as sent, nil for upgrades through Server.Upgrade
+	Extensions  []string // offered in Sec-WebSocket-Extensions, without parameters
+	UserAgent   string
+	JA3         string // TLS client hello hash, for TLS served by the server or conns with a JA3() string method
+}
+
+// Setter to be passed into the creation of a server. fn is called with every upgrade request that passed
+// validation and the client's fingerprint. Returning an error rejects the upgrade with a 403 and reports the
+// error through OnError. The fingerprint stays available through Connection.Fingerprint.
+func WithClientFingerprinter(fn func(r *http.Request, fp *ClientFingerprint) error) ServerOption {
+	return func(s *Server) {
+		s.fingerprinter = fn
+	}
+}
+
+// Returns the fingerprint taken during the upgrade, nil unless WithClientFingerprinter is used.
+func (c *Connection) Fingerprint() *ClientFingerprint {
+	return c.fingerprint
+}
+
+// Fingerprints the client and runs the fingerprinter. raw is the request head if we read it ourselves.
+func (s *Server) checkFingerprint(conn net.Conn, r *http.Request, raw []byte) (*ClientFingerprint, error) {
+	fp := &ClientFingerprint{
+		RemoteAddr:  conn.RemoteAddr(),
+		HeaderOrder: headerOrder(raw),
+		UserAgent:   r.UserAgent(),
+	}
+	for _, v := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for ext := range strings.SplitSeq(v, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if name = strings.TrimSpace(name); name != "" {
+				fp.Extensions = append(fp.Extensions, name)
+			}
+		}
+	}
+	if tc, ok := conn.(interface{ JA3() string }); ok {
+		fp.JA3 = tc.JA3()
+	}
+
+	if err := s.fingerprinter(r, fp); err != nil {
+		return nil, err
+	}
+	return fp, nil
+}
+
+// names of the header lines of a raw request head, in order
+func headerOrder(raw []byte) []string {
+	if raw == nil {
+		return nil
+	}
+	var names []string
+	lines := bytes.Split(raw, []byte("\r\n"))
+	for _, line := range lines[1:] { // skip the request line
+		if name, _, ok := bytes.Cut(line, []byte(":")); ok {
+			names = append(names, string(bytes.TrimSpace(name)))
+		}
+	}
+	return names
+}
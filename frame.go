@@ -2,12 +2,16 @@ package simplewebsockets
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 )
 
 // must be encoded as raw bytes before being sent over tcp
 type Frame struct {
 	FIN           bool // 1-bit flag
+	RSV           byte // the three reserved bits, RSV1 (0x4) marks a permessage-deflate compressed message, RSV2 (0x2) a run-length encoded one
 	Opcode        byte
 	Mask          bool // 1-bit flag
 	MaskKey       [4]byte
@@ -15,166 +19,276 @@ type Frame struct {
 	PayloadLength int64
 }
 
+// A batch of frames sent together with Connection.SendFrames.
 type Frames struct {
 	MsgFrames []Frame
 }
 
-// Turns a Frame into its raw byte representation for sending via TCP.
-func (f Frame) FrameToBytes() []byte {
+// Returned when a batch of frames doesn't form a legal sequence of messages.
+var ErrInvalidFrameSequence = errors.New("invalid frame sequence")
 
-	// --- Calculate Frame Size ---
+// Returned when a frame's 64-bit payload length has its most significant bit set, which RFC 6455 forbids.
+var ErrInvalidPayloadLength = errors.New("64-bit payload length has its most significant bit set")
 
-	frameSize := 2 // header will always be present
+// Checks that the frames form complete messages: each starts with a text or binary frame, continues with
+// continuation frames and ends with a FIN frame. Ping and pong frames may be interleaved, close frames are
+// not allowed (use Connection.Close). Server frames must not be masked.
+func (fs Frames) Validate() error {
+	if len(fs.MsgFrames) == 0 {
+		return fmt.Errorf("%w: no frames", ErrInvalidFrameSequence)
+	}
+
+	inMessage := false
+	for i, f := range fs.MsgFrames {
+		if f.Mask {
+			return fmt.Errorf("%w: frame %d is masked", ErrInvalidFrameSequence, i)
+		}
+		if f.RSV != 0 {
+			return fmt.Errorf("%w: frame %d sets reserved bits", ErrInvalidFrameSequence, i)
+		}
+		if f.PayloadLength != int64(len(f.Payload)) {
+			return fmt.Errorf("%w: frame %d payload length %d doesn't match its payload", ErrInvalidFrameSequence, i, f.PayloadLength)
+		}
 
-	// determine extra bytes for payload length
-	var payloadLenBytes int
-	if f.PayloadLength < 126 {
-		payloadLenBytes = 0
-	} else if f.PayloadLength <= 65535 {
-		payloadLenBytes = 2
-	} else {
-		payloadLenBytes = 8
+		switch f.Opcode {
+		case 0x0:
+			if !inMessage {
+				return fmt.Errorf("%w: continuation frame %d without a message", ErrInvalidFrameSequence, i)
+			}
+			inMessage = !f.FIN
+		case 0x1, 0x2:
+			if inMessage {
+				return fmt.Errorf("%w: data frame %d inside a fragmented message", ErrInvalidFrameSequence, i)
+			}
+			inMessage = !f.FIN
+		case 0x9, 0xA:
+			if !f.FIN || len(f.Payload) > 125 {
+				return fmt.Errorf("%w: control frame %d is fragmented or larger than 125 bytes", ErrInvalidFrameSequence, i)
+			}
+		default:
+			return fmt.Errorf("%w: frame %d has opcode %d", ErrInvalidFrameSequence, i, f.Opcode)
+		}
 	}
 
-	// determine if we need extra bytes for mask
-	maskBytes := 0
-	if f.Mask {
-		maskBytes = 4
+	if inMessage {
+		return fmt.Errorf("%w: last message has no FIN frame", ErrInvalidFrameSequence)
 	}
+	return nil
+}
+
+// Turns a Frame into its raw byte representation for sending via TCP.
+func (f Frame) FrameToBytes() []byte {
+	return f.AppendTo(make([]byte, 0, maxFrameHeaderSize+len(f.Payload)))
+}
 
-	// make buffer for frame contents
-	frameSize += payloadLenBytes + maskBytes + int(f.PayloadLength)
-	frame := make([]byte, frameSize)
+// 2 byte header, 8 byte extended length and 4 byte mask key
+const maxFrameHeaderSize = 14
 
-	// set fin and opcode
+// Appends the raw byte representation of the frame to buf and returns the extended slice, so a scratch
+// buffer can be reused across frames instead of allocating a new one for each.
+func (f Frame) AppendTo(buf []byte) []byte {
+	// fin, reserved bits and opcode
+	first := f.RSV<<4 | f.Opcode
 	if f.FIN {
-		frame[0] |= 0x80
+		first |= 0x80
 	}
-	frame[0] |= f.Opcode
 
-	// set mask bit
+	// mask bit
+	var second byte
 	if f.Mask {
-		frame[1] |= 0x80
+		second = 0x80
 	}
 
-	// set payload length bits
-	if f.PayloadLength < 126 {
-		frame[1] |= byte(f.PayloadLength)
-		payloadOffset := 2
+	// payload length, extended to 16 or 64 bits when needed
+	switch {
+	case f.PayloadLength < 126:
+		buf = append(buf, first, second|byte(f.PayloadLength))
+	case f.PayloadLength <= 65535:
+		buf = append(buf, first, second|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(f.PayloadLength))
+	default:
+		buf = append(buf, first, second|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(f.PayloadLength))
+	}
 
-		// add mask key if present
-		if f.Mask {
-			copy(frame[payloadOffset:payloadOffset + 4], f.MaskKey[:])
-			payloadOffset += 4
+	// add mask key if present
+	if f.Mask {
+		buf = append(buf, f.MaskKey[:]...)
+	}
+
+	// mask the copied payload, f.Payload is left untouched
+	start := len(buf)
+	buf = append(buf, f.Payload...)
+	if f.Mask {
+		for i := range buf[start:] {
+			buf[start+i] ^= f.MaskKey[i%4]
 		}
+	}
+	return buf
+}
 
-		// copy payload
-		copy(frame[payloadOffset:], f.Payload)
-	} else if f.PayloadLength <= 65535 {
-		frame[1] |= 126
+// BytesToFrame converts raw TCP bytes into a WebSocket Frame struct.
+func BytesToFrame(data []byte) (*Frame, error) {
+	return parseFrame(data, true)
+}
 
-		// add extended 16-bit length
-		frame[2] = byte(f.PayloadLength >> 8)
-		frame[3] = byte(f.PayloadLength)
-		payloadOffset := 4
+// Parses a frame. Without copyPayload the payload aliases data and is unmasked in place.
+func parseFrame(data []byte, copyPayload bool) (*Frame, error) {
+	h, offset, err := decodeHeader(data)
+	if err != nil {
+		return nil, err
+	}
 
-		// add mask key if exists
-		if f.Mask {
-			copy(frame[payloadOffset:payloadOffset+4], f.MaskKey[:])
-			payloadOffset += 4
-		}
+	frame := &Frame{
+		FIN:           h.FIN,
+		RSV:           h.RSV,
+		Opcode:        h.Opcode,
+		Mask:          h.Mask,
+		MaskKey:       h.MaskKey,
+		PayloadLength: h.PayloadLength,
+	}
 
-		// copy payload
-		copy(frame[payloadOffset:], f.Payload)
-	} else {
-		frame[1] |= 127
+	// check if actual payload length is expected based on provided length
+	if int64(len(data)-offset) < frame.PayloadLength {
+		return nil, fmt.Errorf("frame too short for payload: expected %d bytes, got %d",
+			frame.PayloadLength, len(data)-offset)
+	}
 
-		// add extended 64-bit length
-		binary.BigEndian.PutUint64(frame[2:10], uint64(f.PayloadLength))
-		payloadOffset := 10
+	// extract and unmask payload if necessary
+	frame.Payload = data[offset : offset+int(frame.PayloadLength)]
+	if copyPayload {
+		frame.Payload = make([]byte, frame.PayloadLength)
+		copy(frame.Payload, data[offset:offset+int(frame.PayloadLength)])
+	}
 
-		// add mask key if exists
-		if f.Mask {
-			copy(frame[payloadOffset:payloadOffset+4], f.MaskKey[:])
-			payloadOffset += 4
+	if frame.Mask {
+		// unmask payload
+		for i := range frame.Payload {
+			frame.Payload[i] ^= frame.MaskKey[i%4]
 		}
+	}
+
+	return frame, nil
+}
+
+// Everything in a frame before its payload.
+type Header struct {
+	FIN           bool
+	RSV           byte // the three reserved bits, RSV1 is 0x4
+	Opcode        byte
+	Mask          bool
+	MaskKey       [4]byte
+	PayloadLength int64
+}
+
+// Reads a frame header from r, leaving r at the start of the payload so callers can act on the header
+// (size checks, routing, forwarding) before any of the payload has arrived. Read the payload with PayloadReader.
+func ParseHeader(r io.Reader) (Header, error) {
+	var buf [maxFrameHeaderSize]byte
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return Header{}, err
+	}
 
-		// copy payload
-		copy(frame[payloadOffset:], f.Payload)
+	// the first two bytes tell how much of the header follows
+	n := 2
+	switch buf[1] & 0x7F {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if buf[1]&0x80 != 0 {
+		n += 4
+	}
+	if _, err := io.ReadFull(r, buf[2:n]); err != nil {
+		return Header{}, err
 	}
 
-	return frame
+	h, _, err := decodeHeader(buf[:n])
+	return h, err
 }
 
-// BytesToFrame converts raw TCP bytes into a WebSocket Frame struct.
-func BytesToFrame(data []byte) (*Frame, error) {
-	if len(data) < 2 {
-		return nil, fmt.Errorf("frame too short: need at least 2 bytes, got %d", len(data))
+// Returns a reader for the payload following h in r, unmasking it if needed. It reports io.EOF after
+// exactly h.PayloadLength bytes.
+func (h Header) PayloadReader(r io.Reader) io.Reader {
+	lr := io.LimitReader(r, h.PayloadLength)
+	if !h.Mask {
+		return lr
 	}
+	return &unmaskReader{r: lr, key: h.MaskKey}
+}
 
-	frame := &Frame{}
+type unmaskReader struct {
+	r   io.Reader
+	key [4]byte
+	pos int
+}
+
+func (u *unmaskReader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	for i := range p[:n] {
+		p[i] ^= u.key[(u.pos+i)%4]
+	}
+	u.pos = (u.pos + n) % 4
+	return n, err
+}
+
+// decodes the header at the start of data, returning it with the offset of the payload
+func decodeHeader(data []byte) (Header, int, error) {
+	var h Header
+	if len(data) < 2 {
+		return h, 0, fmt.Errorf("frame too short: need at least 2 bytes, got %d", len(data))
+	}
 
 	// parse first byte -> FIN (1 bit) + RSV (3 bit) + opcode (4 bit)
 	firstByte := data[0]
-	frame.FIN = (firstByte & 0x80) != 0 // check fin bit
-	frame.Opcode = firstByte & 0x0F     // check opcode bits
+	h.FIN = (firstByte & 0x80) != 0 // check fin bit
+	h.RSV = (firstByte >> 4) & 0x07 // check reserved bits
+	h.Opcode = firstByte & 0x0F     // check opcode bits
 
 	// parse second byte -> mask (1 bit) + payload length (7 bits)
 	secondByte := data[1]
-	frame.Mask = (secondByte & 0x80) != 0 // check mask bit (boolean)
-	payloadLen := secondByte & 0x7F       // check len bits
+	h.Mask = (secondByte & 0x80) != 0 // check mask bit (boolean)
+	payloadLen := secondByte & 0x7F   // check len bits
 
 	offset := 2 // variable offset past this point
 
 	// find payload length
 	switch {
 	case payloadLen < 126:
-		frame.PayloadLength = int64(payloadLen)
+		h.PayloadLength = int64(payloadLen)
 
 	case payloadLen == 126:
 		// 2 more bytes contain length
-		if len(data) < offset + 2 {
-			return nil, fmt.Errorf("frame too short for 16-bit length")
+		if len(data) < offset+2 {
+			return h, 0, fmt.Errorf("frame too short for 16-bit length")
 		}
-		frame.PayloadLength = int64(binary.BigEndian.Uint16(data[offset : offset + 2]))
+		h.PayloadLength = int64(binary.BigEndian.Uint16(data[offset : offset+2]))
 		offset += 2
 
 	case payloadLen == 127:
 		// 8 more bytes contain length
-		if len(data) < offset + 8 {
-			return nil, fmt.Errorf("frame too short for 64-bit length")
+		if len(data) < offset+8 {
+			return h, 0, fmt.Errorf("frame too short for 64-bit length")
+		}
+		length := binary.BigEndian.Uint64(data[offset : offset+8])
+		if length > math.MaxInt64 {
+			return h, 0, ErrInvalidPayloadLength
 		}
-		frame.PayloadLength = int64(binary.BigEndian.Uint64(data[offset : offset + 8]))
+		h.PayloadLength = int64(length)
 		offset += 8
 	}
 
 	// get mask key if present
-	if frame.Mask {
+	if h.Mask {
 		if len(data) < offset+4 {
-			return nil, fmt.Errorf("frame too short for mask key")
+			return h, 0, fmt.Errorf("frame too short for mask key")
 		}
-		copy(frame.MaskKey[:], data[offset:offset + 4])
+		copy(h.MaskKey[:], data[offset:offset+4])
 		offset += 4
 	}
 
-	// check if actual payload length is expected based on provided length
-	if len(data) < offset+int(frame.PayloadLength) {
-		return nil, fmt.Errorf("frame too short for payload: expected %d bytes, got %d",
-			offset+int(frame.PayloadLength), len(data))
-	}
-
-	// extract and unmask payload if necessary
-	frame.Payload = make([]byte, frame.PayloadLength)
-	copy(frame.Payload, data[offset:offset + int(frame.PayloadLength)])
-
-	if frame.Mask {
-		// unmask payload
-		for i := range frame.Payload {
-			frame.Payload[i] ^= frame.MaskKey[i % 4]
-		}
-	}
-
-	return frame, nil
+	return h, offset, nil
 }
 
 func NewFrame[T string | []byte](opcode byte, data T, isFin bool, masked bool, maskKey [4]byte) Frame {
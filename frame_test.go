@@ -0,0 +1,238 @@
+package simplewebsockets
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// Frames with their exact encoding, most taken from the examples in RFC 6455 section 5.7.
+var frameVectors = []struct {
+	name  string
+	frame Frame
+	wire  []byte
+}{
+	{
+		name:  "unmasked text",
+		frame: NewFrame(0x1, []byte("Hello"), true, false, [4]byte{}),
+		wire:  []byte{0x81, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f},
+	},
+	{
+		name:  "masked text",
+		frame: NewFrame(0x1, []byte("Hello"), true, true, [4]byte{0x37, 0xfa, 0x21, 0x3d}),
+		wire:  []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58},
+	},
+	{
+		name:  "first fragment",
+		frame: NewFrame(0x1, []byte("Hel"), false, false, [4]byte{}),
+		wire:  []byte{0x01, 0x03, 0x48, 0x65, 0x6c},
+	},
+	{
+		name:  "last fragment",
+		frame: NewFrame(0x0, []byte("lo"), true, false, [4]byte{}),
+		wire:  []byte{0x80, 0x02, 0x6c, 0x6f},
+	},
+	{
+		name:  "masked continuation",
+		frame: NewFrame(0x0, []byte("lo"), false, true, [4]byte{0x01, 0x02, 0x03, 0x04}),
+		wire:  []byte{0x00, 0x82, 0x01, 0x02, 0x03, 0x04, 0x6d, 0x6d},
+	},
+	{
+		name:  "unmasked ping",
+		frame: NewFrame(0x9, []byte("Hello"), true, false, [4]byte{}),
+		wire:  []byte{0x89, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f},
+	},
+	{
+		name:  "masked pong",
+		frame: NewFrame(0xA, []byte("Hello"), true, true, [4]byte{0x37, 0xfa, 0x21, 0x3d}),
+		wire:  []byte{0x8a, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58},
+	},
+	{
+		name:  "empty close",
+		frame: NewEmptyCloseFrame(),
+		wire:  []byte{0x88, 0x00},
+	},
+	{
+		name:  "close with status and reason",
+		frame: NewFrame(0x8, []byte{0x03, 0xe8, 'b', 'y', 'e'}, true, false, [4]byte{}),
+		wire:  []byte{0x88, 0x05, 0x03, 0xe8, 0x62, 0x79, 0x65},
+	},
+	{
+		name:  "masked close",
+		frame: NewFrame(0x8, []byte{0x03, 0xe8}, true, true, [4]byte{0xff, 0x00, 0xff, 0x00}),
+		wire:  []byte{0x88, 0x82, 0xff, 0x00, 0xff, 0x00, 0xfc, 0xe8},
+	},
+	{
+		name:  "compressed text",
+		frame: Frame{FIN: true, RSV: rsvCompressed, Opcode: 0x1, Payload: []byte{0xf2, 0x48, 0xcd, 0xc9, 0xc9, 0x07, 0x00}, PayloadLength: 7},
+		wire:  []byte{0xc1, 0x07, 0xf2, 0x48, 0xcd, 0xc9, 0xc9, 0x07, 0x00},
+	},
+	{
+		name:  "empty binary",
+		frame: NewFrame(0x2, []byte{}, true, false, [4]byte{}),
+		wire:  []byte{0x82, 0x00},
+	},
+	{
+		name:  "125 byte binary",
+		frame: NewFrame(0x2, bytes.Repeat([]byte{0xab}, 125), true, false, [4]byte{}),
+		wire:  append([]byte{0x82, 0x7d}, bytes.Repeat([]byte{0xab}, 125)...),
+	},
+	{
+		name:  "256 byte binary, 16 bit length",
+		frame: NewFrame(0x2, bytes.Repeat([]byte{0xab}, 256), true, false, [4]byte{}),
+		wire:  append([]byte{0x82, 0x7e, 0x01, 0x00}, bytes.Repeat([]byte{0xab}, 256)...),
+	},
+	{
+		name:  "masked 65535 byte binary, 16 bit length",
+		frame: NewFrame(0x2, bytes.Repeat([]byte{0x00}, 65535), true, true, [4]byte{0x01, 0x02, 0x03, 0x04}),
+		wire:  append([]byte{0x82, 0xfe, 0xff, 0xff, 0x01, 0x02, 0x03, 0x04}, bytes.Repeat([]byte{0x01, 0x02, 0x03, 0x04}, 16384)[:65535]...),
+	},
+	{
+		name:  "64 KiB binary, 64 bit length",
+		frame: NewFrame(0x2, bytes.Repeat([]byte{0xab}, 65536), true, false, [4]byte{}),
+		wire:  append([]byte{0x82, 0x7f, 0, 0, 0, 0, 0, 0x01, 0x00, 0x00}, bytes.Repeat([]byte{0xab}, 65536)...),
+	},
+}
+
+func TestFrameEncode(t *testing.T) {
+	for _, tt := range frameVectors {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := bytes.Clone(tt.frame.Payload)
+			if got := tt.frame.FrameToBytes(); !bytes.Equal(got, tt.wire) {
+				t.Errorf("FrameToBytes = % x, want % x", head(got), head(tt.wire))
+			}
+			prefix := []byte{0xde, 0xad}
+			if got := tt.frame.AppendTo(bytes.Clone(prefix)); !bytes.Equal(got, append(prefix, tt.wire...)) {
+				t.Errorf("AppendTo = % x, want the prefix followed by % x", head(got), head(tt.wire))
+			}
+			if !bytes.Equal(tt.frame.Payload, payload) {
+				t.Error("encoding changed the frame's payload")
+			}
+		})
+	}
+}
+
+func TestFrameDecode(t *testing.T) {
+	for _, tt := range frameVectors {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := BytesToFrame(tt.wire)
+			if err != nil {
+				t.Fatalf("BytesToFrame: %v", err)
+			}
+			if !reflect.DeepEqual(*f, tt.frame) {
+				t.Errorf("BytesToFrame = %+v, want %+v", summary(*f), summary(tt.frame))
+			}
+
+			// the streaming parser agrees with the buffered one
+			r := bytes.NewReader(tt.wire)
+			h, err := ParseHeader(r)
+			if err != nil {
+				t.Fatalf("ParseHeader: %v", err)
+			}
+			want := Header{FIN: tt.frame.FIN, RSV: tt.frame.RSV, Opcode: tt.frame.Opcode, Mask: tt.frame.Mask, MaskKey: tt.frame.MaskKey, PayloadLength: tt.frame.PayloadLength}
+			if h != want {
+				t.Errorf("ParseHeader = %+v, want %+v", h, want)
+			}
+			payload, err := io.ReadAll(h.PayloadReader(r))
+			if err != nil {
+				t.Fatalf("PayloadReader: %v", err)
+			}
+			if !bytes.Equal(payload, tt.frame.Payload) {
+				t.Errorf("PayloadReader = % x, want % x", head(payload), head(tt.frame.Payload))
+			}
+		})
+	}
+}
+
+func TestFrameDecodeTruncated(t *testing.T) {
+	for _, tt := range frameVectors {
+		for _, n := range []int{0, 1, len(tt.wire) - 1} {
+			if _, err := BytesToFrame(tt.wire[:n]); err == nil {
+				t.Errorf("%s: BytesToFrame accepted the first %d of %d bytes", tt.name, n, len(tt.wire))
+			}
+		}
+	}
+}
+
+func TestFrameDecodeInvalidLength(t *testing.T) {
+	tests := []struct {
+		name string
+		wire []byte
+	}{
+		{"top bit set", []byte{0x82, 0x7f, 0x80, 0, 0, 0, 0, 0, 0, 0x0a}},
+		{"all bits set", []byte{0x82, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"top bit set, masked", []byte{0x82, 0xff, 0x80, 0, 0, 0, 0, 0, 0, 0x0a, 1, 2, 3, 4, 0xaa}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := BytesToFrame(tt.wire); !errors.Is(err, ErrInvalidPayloadLength) {
+				t.Errorf("BytesToFrame: %v, want ErrInvalidPayloadLength", err)
+			}
+			if h, err := ParseHeader(bytes.NewReader(tt.wire)); !errors.Is(err, ErrInvalidPayloadLength) {
+				t.Errorf("ParseHeader = %+v, %v, want ErrInvalidPayloadLength", h, err)
+			}
+		})
+	}
+
+	// the largest legal length is only short of payload
+	wire := []byte{0x82, 0x7f, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xaa}
+	if _, err := BytesToFrame(wire); err == nil || errors.Is(err, ErrInvalidPayloadLength) {
+		t.Errorf("BytesToFrame of a truncated frame with the largest length: %v, want a short frame error", err)
+	}
+}
+
+// the frame with its payload shortened, for failure messages
+func summary(f Frame) Frame {
+	f.Payload = head(f.Payload)
+	return f
+}
+
+// shortens long payloads in failure messages
+func head(b []byte) []byte {
+	if len(b) > 32 {
+		return b[:32]
+	}
+	return b
+}
+
+var frameBenchmarks = []struct {
+	name   string
+	size   int
+	masked bool
+}{
+	{"16B", 16, false},
+	{"1KiB", 1024, false},
+	{"64KiB", 64 * 1024, false},
+	{"1KiB masked", 1024, true},
+}
+
+// Encodes frames into a reused buffer, the way the send path does.
+func BenchmarkFrameAppendTo(b *testing.B) {
+	for _, bm := range frameBenchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			f := NewFrame(0x2, make([]byte, bm.size), true, bm.masked, [4]byte{1, 2, 3, 4})
+			buf := make([]byte, 0, maxFrameHeaderSize+bm.size)
+			b.SetBytes(int64(bm.size))
+			b.ReportAllocs()
+			for b.Loop() {
+				buf = f.AppendTo(buf[:0])
+			}
+		})
+	}
+}
+
+// Encodes frames into a new buffer each time.
+func BenchmarkFrameToBytes(b *testing.B) {
+	for _, bm := range frameBenchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			f := NewFrame(0x2, make([]byte, bm.size), true, bm.masked, [4]byte{1, 2, 3, 4})
+			b.SetBytes(int64(bm.size))
+			b.ReportAllocs()
+			for b.Loop() {
+				f.FrameToBytes()
+			}
+		})
+	}
+}
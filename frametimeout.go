@@ -0,0 +1,40 @@
+package simplewebsockets
+
+import "time"
+
+// Setter to be passed into the creation of a server. A frame whose first bytes arrived must be complete
+// within d, otherwise the connection is closed with 1008. This is separate from the idle timeout and stops
+// peers from trickling a frame in for minutes while it holds a buffer. 0 disables the check.
+func WithFrameReadTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.frameReadTimeout = d
+	}
+}
+
+// Starts, keeps or stops the partial frame timer after the read loop drained the frame buffer.
+// completed reports whether at least one frame finished since the last call. Only called by the read loop.
+func (c *Connection) watchPartialFrame(completed bool) {
+	d := c.server.frameReadTimeout
+	if d <= 0 {
+		return
+	}
+
+	if c.frameTimer != nil {
+		if len(c.frameBuffer) > 0 && !completed {
+			return // still waiting on the same frame
+		}
+		if !c.frameTimer.Stop() {
+			// fired while the frame completed, undo the expired deadline
+			c.frameTimedOut.Store(false)
+			c.restoreReadDeadline()
+		}
+		c.frameTimer = nil
+	}
+
+	if len(c.frameBuffer) > 0 {
+		c.frameTimer = c.server.clock.AfterFunc(d, func() {
+			c.frameTimedOut.Store(true)
+			c.conn.SetReadDeadline(aLongTimeAgo)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package simplewebsockets
+
+import "sync"
+
+// goroutines the library is running, by what they do
+var goroutines struct {
+	mx      sync.Mutex
+	running map[string]int
+}
+
+// Runs fn on a new goroutine that is counted under name until fn returns.
+func spawn(name string, fn func()) {
+	goroutines.mx.Lock()
+	if goroutines.running == nil {
+		goroutines.running = make(map[string]int)
+	}
+	goroutines.running[name]++
+	goroutines.mx.Unlock()
+
+	go func() {
+		defer func() {
+			goroutines.mx.Lock()
+			defer goroutines.mx.Unlock()
+			if goroutines.running[name]--; goroutines.running[name] == 0 {
+				delete(goroutines.running, name)
+			}
+		}()
+		fn()
+	}()
+}
+
+// Returns the goroutines the library is running across all servers and clients, counted by what they do
+// (e.g. "connection", "keepalive"). Once every server is shut down and every client closed it is empty,
+// except for the handler pools of Runtimes, which outlive their servers. See wstest.VerifyNoLeaks.
+func Goroutines() map[string]int {
+	goroutines.mx.Lock()
+	defer goroutines.mx.Unlock()
+	running := make(map[string]int, len(goroutines.running))
+	for name, n := range goroutines.running {
+		running[name] = n
+	}
+	return running
+}
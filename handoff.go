@@ -0,0 +1,134 @@
+//go:build unix
+
+package simplewebsockets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Returned when the listener can't be handed off or received.
+var ErrHandoff = errors.New("listener handoff failed")
+
+// Passes the listening socket, and state (e.g. serialized sessions, may be nil), to a new process waiting in
+// BindHandoff on the unix socket at socketPath. Once the new process has taken over, Serve returns
+// ErrServerClosed while open connections keep running; follow with Shutdown to drain them.
+func (s *Server) HandOff(socketPath string, state []byte) error {
+	s.listenerMx.Lock()
+	ln := s.listener
+	s.listenerMx.Unlock()
+	if ln == nil {
+		return errors.New("server is not bound, call Bind first")
+	}
+	raw := ln
+	if tl, ok := ln.(*tlsListener); ok {
+		raw = tl.Listener // the new process does TLS with its own config
+	}
+	fl, ok := raw.(interface{ File() (*os.File, error) })
+	if !ok {
+		return fmt.Errorf("%w: %T has no file descriptor", ErrHandoff, raw)
+	}
+	f, err := fl.File()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrHandoff, err)
+	}
+	defer f.Close()
+
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrHandoff, err)
+	}
+	defer conn.Close()
+
+	// the descriptor travels with the first byte, the state follows until we close our side
+	rights := syscall.UnixRights(int(f.Fd()))
+	if _, _, err := conn.WriteMsgUnix([]byte{0}, rights, nil); err != nil {
+		return fmt.Errorf("%w: %v", ErrHandoff, err)
+	}
+	if _, err := conn.Write(state); err != nil {
+		return fmt.Errorf("%w: %v", ErrHandoff, err)
+	}
+	conn.CloseWrite()
+
+	// both processes accept on the socket until the new one confirms it serves
+	ack := make([]byte, 1)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		return fmt.Errorf("%w: new process did not confirm: %v", ErrHandoff, err)
+	}
+
+	s.handedOff.Store(true)
+	return ln.Close()
+}
+
+// Binds the server to a listening socket handed over by an old process calling HandOff, waiting on the
+// unix socket at socketPath until it does or ctx ends. Returns the state the old process passed along.
+// TLS is served with this server's WithTLSConfig. Follow with Serve.
+func (s *Server) BindHandoff(ctx context.Context, socketPath string) ([]byte, error) {
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHandoff, err)
+	}
+	defer l.Close()
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+
+	conn, err := l.AcceptUnix()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrHandoff, err)
+	}
+	defer conn.Close()
+
+	ln, err := receiveListener(conn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHandoff, err)
+	}
+	state, err := io.ReadAll(conn)
+	if err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("%w: %v", ErrHandoff, err)
+	}
+
+	if err := s.attach(ln, s.tlsConfig); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{1}); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHandoff, err)
+	}
+	return state, nil
+}
+
+// reads the message carrying the listener's descriptor
+func receiveListener(conn *net.UnixConn) (net.Listener, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) != 1 {
+		return nil, errors.New("no file descriptor received")
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) != 1 {
+		return nil, errors.New("no file descriptor received")
+	}
+
+	f := os.NewFile(uintptr(fds[0]), "listener")
+	defer f.Close() // FileListener dups the descriptor
+	return net.FileListener(f)
+}
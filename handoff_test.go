@@ -0,0 +1,113 @@
+//go:build unix
+
+package simplewebsockets_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+// Dials url and returns the connection and the messages it receives.
+func dialEcho(t *testing.T, url string) (*simplewebsockets.Connection, chan string) {
+	t.Helper()
+	got := make(chan string, 4)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := simplewebsockets.NewServer().Dial(ctx, url, simplewebsockets.WithConnOptions(
+		simplewebsockets.WithMessageHandler(func(msg []byte) { got <- string(msg) })))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close(1000, "") })
+	return c, got
+}
+
+// Sends "hello" and expects the server named want to echo it.
+func expectEchoFrom(t *testing.T, c *simplewebsockets.Connection, got chan string, want string) {
+	t.Helper()
+	if err := c.SendTextMessage("hello"); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	select {
+	case echo := <-got:
+		if echo != want+": hello" {
+			t.Fatalf("got %q, want the echo from %s", echo, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no echo from %s", want)
+	}
+}
+
+func TestListenerHandoff(t *testing.T) {
+	newServer := func(name string) *simplewebsockets.Server {
+		s := simplewebsockets.NewServer()
+		s.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) {
+			c.SendTextMessage(name + ": " + string(msg))
+		})
+		t.Cleanup(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			s.Shutdown(ctx)
+		})
+		return s
+	}
+
+	old := newServer("old")
+	if err := old.Bind("127.0.0.1:0"); err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- old.Serve() }()
+	url := "ws://" + old.Addr().String()
+	before, beforeGot := dialEcho(t, url)
+	expectEchoFrom(t, before, beforeGot, "old")
+
+	socket := filepath.Join(t.TempDir(), "handoff.sock")
+	next := newServer("new")
+	type bound struct {
+		state []byte
+		err   error
+	}
+	handedOver := make(chan bound, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		state, err := next.BindHandoff(ctx, socket)
+		handedOver <- bound{state, err}
+	}()
+
+	// retry until the new process listens on the socket
+	deadline := time.Now().Add(5 * time.Second)
+	for err := old.HandOff(socket, []byte("sessions")); err != nil; err = old.HandOff(socket, []byte("sessions")) {
+		if !errors.Is(err, simplewebsockets.ErrHandoff) || time.Now().After(deadline) {
+			t.Fatalf("hand off: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	b := <-handedOver
+	if b.err != nil || string(b.state) != "sessions" {
+		t.Fatalf("bind handoff: state %q (%v), want the old server's", b.state, b.err)
+	}
+	select {
+	case err := <-served:
+		if !errors.Is(err, simplewebsockets.ErrServerClosed) {
+			t.Errorf("old Serve returned %v, want ErrServerClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("old Serve didn't return after the handoff")
+	}
+	go next.Serve()
+
+	// new connections reach the new server on the same address, the old one keeps its connections
+	if next.Addr().String() != old.Addr().String() {
+		t.Errorf("new server listens on %v, want %v", next.Addr(), old.Addr())
+	}
+	after, afterGot := dialEcho(t, url)
+	expectEchoFrom(t, after, afterGot, "new")
+	expectEchoFrom(t, before, beforeGot, "old")
+}
@@ -0,0 +1,121 @@
+package simplewebsockets
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Largest upgrade request head accepted.
+const maxHandshakeSize = 8 * 1024
+
+// The only websocket version we speak, RFC 6455.
+const websocketVersion = "13"
+
+// Matched (with errors.Is) by the error of an upgrade request for a websocket version other than 13. It is
+// answered with 426 Upgrade Required and the version we speak.
+var ErrUnsupportedVersion = errors.New("unsupported websocket version")
+
+// Reads exactly one upgrade request head from the connection. Clients must wait for the 101 before sending
+// anything else, so bytes following the head (a body or a pipelined request) are rejected rather than
+// being interpreted later as HTTP or as frames.
+func readHandshakeRequest(conn net.Conn) (*http.Request, []byte, error) {
+	buf := make([]byte, 0, 1024)
+	chunk := make([]byte, 1024)
+
+	for {
+		n, err := conn.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+
+		if end := bytes.Index(buf, []byte("\r\n\r\n")); end >= 0 {
+			if end+4 != len(buf) {
+				return nil, nil, fmt.Errorf("unexpected bytes after the upgrade request (body or pipelined request)")
+			}
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(buf) > maxHandshakeSize {
+			return nil, nil, fmt.Errorf("upgrade request larger than %d bytes", maxHandshakeSize)
+		}
+	}
+
+	raw := buf
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.RemoteAddr = conn.RemoteAddr().String()
+	return req, raw, nil
+}
+
+// Rejects upgrade requests that could be used for request smuggling or that aren't websocket upgrades.
+// raw is nil for requests parsed by net/http, which already rejects conflicting Content-Length headers.
+// Returns the Sec-WebSocket-Key.
+func validateUpgradeRequest(r *http.Request, raw []byte) (string, error) {
+	if r.Method != http.MethodGet {
+		return "", fmt.Errorf("client did not send handshake (not a GET http request)")
+	}
+	if !r.ProtoAtLeast(1, 1) {
+		return "", fmt.Errorf("upgrade requires HTTP/1.1, got %s", r.Proto)
+	}
+
+	// http.ReadRequest folds identical Content-Length headers, count them in the raw request
+	if raw != nil && countHeaderLines(raw, "Content-Length") > 1 {
+		return "", fmt.Errorf("multiple Content-Length headers")
+	}
+	if len(r.TransferEncoding) > 0 || (raw != nil && countHeaderLines(raw, "Transfer-Encoding") > 0) {
+		return "", fmt.Errorf("Transfer-Encoding not allowed on upgrade requests")
+	}
+	if r.ContentLength > 0 {
+		return "", fmt.Errorf("upgrade request must not have a body")
+	}
+
+	if !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		return "", fmt.Errorf("Upgrade header does not contain \"websocket\"")
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") {
+		return "", fmt.Errorf("Connection header does not contain \"upgrade\"")
+	}
+	switch versions := r.Header.Values("Sec-WebSocket-Version"); {
+	case len(versions) == 0:
+		return "", fmt.Errorf("Sec-WebSocket-Version header not found")
+	case len(versions) > 1 || strings.TrimSpace(versions[0]) != websocketVersion:
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedVersion, versions)
+	}
+
+	keys := r.Header.Values("Sec-WebSocket-Key")
+	if len(keys) == 0 {
+		return "", fmt.Errorf("Sec-WebSocket-Key header not found")
+	}
+	if len(keys) > 1 {
+		return "", fmt.Errorf("multiple Sec-WebSocket-Key headers")
+	}
+	return strings.TrimSpace(keys[0]), nil
+}
+
+// Returns the status and headers to refuse an upgrade request with that validateUpgradeRequest rejected: 426
+// with the version we speak (RFC 6455 4.4) for other websocket versions, 400 otherwise.
+func upgradeRejection(err error) (int, http.Header) {
+	if errors.Is(err, ErrUnsupportedVersion) {
+		return http.StatusUpgradeRequired, http.Header{"Sec-Websocket-Version": {websocketVersion}}
+	}
+	return http.StatusBadRequest, nil
+}
+
+// counts header lines with the given name in a raw request head
+func countHeaderLines(raw []byte, name string) int {
+	count := 0
+	prefix := strings.ToLower(name) + ":"
+	for line := range strings.SplitSeq(string(raw), "\r\n") {
+		if strings.HasPrefix(strings.ToLower(strings.TrimLeft(line, " \t")), prefix) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,82 @@
+package simplewebsockets_test
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+// Sends a raw upgrade request with the given header lines to addr and returns the response.
+func upgradeResponse(t *testing.T, addr string, headers ...string) *http.Response {
+	t.Helper()
+	return upgradeResponseFor(t, addr, "/", headers...)
+}
+
+// Like upgradeResponse, for a request to target, e.g. "/?token=x".
+func upgradeResponseFor(t *testing.T, addr string, target string, headers ...string) *http.Response {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req := "GET " + target + " HTTP/1.1\r\nHost: test\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" + strings.Join(headers, "\r\n") + "\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestUpgradeRequestHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		status  int
+	}{
+		{"valid", []string{"Upgrade: websocket", "Connection: keep-alive, Upgrade", "Sec-WebSocket-Version: 13"}, http.StatusSwitchingProtocols},
+		{"no Upgrade", []string{"Connection: Upgrade", "Sec-WebSocket-Version: 13"}, http.StatusBadRequest},
+		{"other Upgrade", []string{"Upgrade: h2c", "Connection: Upgrade", "Sec-WebSocket-Version: 13"}, http.StatusBadRequest},
+		{"no Connection", []string{"Upgrade: websocket", "Sec-WebSocket-Version: 13"}, http.StatusBadRequest},
+		{"Connection without upgrade", []string{"Upgrade: websocket", "Connection: keep-alive", "Sec-WebSocket-Version: 13"}, http.StatusBadRequest},
+		{"no version", []string{"Upgrade: websocket", "Connection: Upgrade"}, http.StatusBadRequest},
+		{"old version", []string{"Upgrade: websocket", "Connection: Upgrade", "Sec-WebSocket-Version: 8"}, http.StatusUpgradeRequired},
+		{"several versions", []string{"Upgrade: websocket", "Connection: Upgrade", "Sec-WebSocket-Version: 13, 8"}, http.StatusUpgradeRequired},
+	}
+
+	// the server's own listener and Server.Upgrade in an http.Handler validate the same way
+	listener, _, _ := startServer(t)
+	upgrader := simplewebsockets.NewServer()
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := upgrader.Upgrade(w, r); err == nil {
+			c.Close(1000, "")
+		}
+	}))
+	defer hs.Close()
+
+	for _, tt := range tests {
+		for name, addr := range map[string]string{"ServeConn": listener.Addr().String(), "Upgrade": hs.Listener.Addr().String()} {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				resp := upgradeResponse(t, addr, tt.headers...)
+				if resp.StatusCode != tt.status {
+					t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+				}
+				if v := resp.Header.Get("Sec-WebSocket-Version"); tt.status == http.StatusUpgradeRequired && v != "13" {
+					t.Errorf("Sec-WebSocket-Version %q, want 13", v)
+				}
+			})
+		}
+	}
+}
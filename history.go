@@ -0,0 +1,139 @@
+package simplewebsockets
+
+import (
+	"sync"
+	"time"
+)
+
+// A room message retained for backfill.
+type StoredMessage struct {
+	Time time.Time
+	Type MessageType
+	Data []byte
+}
+
+// Storage for room history. Implementations must be safe for concurrent use and return messages in
+// the order they were appended.
+type MessageStore interface {
+	Append(tenant string, room string, msg StoredMessage) error
+	Since(tenant string, room string, since time.Time) ([]StoredMessage, error)
+}
+
+// Setter to be passed into the creation of a server. Room broadcasts are appended to the store so that
+// joining clients can be backfilled with Room.JoinWithBackfill.
+func WithMessageStore(store MessageStore) ServerOption {
+	return func(s *Server) {
+		s.messageStore = store
+	}
+}
+
+// In memory MessageStore keeping at most limit messages per room, each for at most ttl (0 keeps them until evicted by limit).
+type MemoryStore struct {
+	limit int
+	ttl   time.Duration
+	clock Clock
+
+	mx    sync.Mutex
+	rooms map[string][]StoredMessage
+}
+
+// Creates an in memory message store.
+func NewMemoryStore(limit int, ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		limit: limit,
+		ttl:   ttl,
+		clock: realClock{},
+		rooms: make(map[string][]StoredMessage),
+	}
+}
+
+// Replaces the clock used to expire messages, usually the same one given to the server WithClock.
+func (m *MemoryStore) SetClock(clock Clock) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.clock = clock
+}
+
+func (m *MemoryStore) Append(tenant string, room string, msg StoredMessage) error {
+	key := tenant + "\x00" + room
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	msgs := append(m.rooms[key], msg)
+	if m.limit > 0 && len(msgs) > m.limit {
+		msgs = append([]StoredMessage(nil), msgs[len(msgs)-m.limit:]...)
+	}
+	m.rooms[key] = m.expire(msgs)
+	return nil
+}
+
+func (m *MemoryStore) Since(tenant string, room string, since time.Time) ([]StoredMessage, error) {
+	key := tenant + "\x00" + room
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	msgs := m.expire(m.rooms[key])
+	m.rooms[key] = msgs
+
+	var result []StoredMessage
+	for _, msg := range msgs {
+		if msg.Time.After(since) {
+			result = append(result, msg)
+		}
+	}
+	return result, nil
+}
+
+// drops messages older than the ttl, msgs is ordered oldest first
+func (m *MemoryStore) expire(msgs []StoredMessage) []StoredMessage {
+	if m.ttl <= 0 {
+		return msgs
+	}
+	cutoff := m.clock.Now().Add(-m.ttl)
+	i := 0
+	for i < len(msgs) && msgs[i].Time.Before(cutoff) {
+		i++
+	}
+	return msgs[i:]
+}
+
+// Joins the room and replays retained messages newer than since to the connection before it receives
+// live broadcasts. Broadcasts to the room wait during the replay so no message is missed or duplicated.
+func (r *Room) JoinWithBackfill(c *Connection, since time.Time) error {
+	if err := r.authorize(c, ActionJoin); err != nil {
+		return err
+	}
+
+	r.sendMx.Lock()
+	defer r.sendMx.Unlock()
+
+	store := r.tenant.server.messageStore
+	if store != nil {
+		msgs, err := store.Since(r.tenant.id, r.name, since)
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if err := c.writeMessage(byte(msg.Type), msg.Data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return r.join(c)
+}
+
+// retains a broadcast message if the server has a message store
+func (r *Room) retain(mt MessageType, data []byte) error {
+	store := r.tenant.server.messageStore
+	if store == nil {
+		return nil
+	}
+	return store.Append(r.tenant.id, r.name, StoredMessage{
+		Time: r.tenant.server.clock.Now(),
+		Type: mt,
+		Data: append([]byte(nil), data...),
+	})
+}
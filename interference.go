@@ -0,0 +1,89 @@
+package simplewebsockets
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Matched (with errors.Is) by the error returned from a handshake that shows signs of a misbehaving proxy.
+var ErrIntermediaryInterference = errors.New("intermediary interference detected")
+
+// Details about what a proxy between client and server appears to have done to the upgrade request,
+// with hints on how to fix it.
+type InterferenceError struct {
+	Signals []string
+	Hints   []string
+}
+
+func (e *InterferenceError) Error() string {
+	return fmt.Sprintf("%v: %s (hints: %s)", ErrIntermediaryInterference, strings.Join(e.Signals, "; "), strings.Join(e.Hints, "; "))
+}
+
+func (e *InterferenceError) Unwrap() error {
+	return ErrIntermediaryInterference
+}
+
+// Setter to be passed into the creation of a server. Enables checks during the handshake for common
+// intermediary misbehavior (stripped Upgrade/Connection headers, HTTP/1.0 downgrades, buffered or
+// re-encoded requests). Affected upgrades are rejected with a 400 and an *InterferenceError is reported
+// through OnError.
+func WithHandshakeDiagnostics(enabled bool) ServerOption {
+	return func(s *Server) {
+		s.handshakeDiagnostics = enabled
+	}
+}
+
+// Looks for signs that a proxy altered the upgrade request. Returns nil if the request looks untouched.
+func detectInterference(r *http.Request) error {
+	e := &InterferenceError{}
+	proxied := r.Header.Get("Via") != "" || r.Header.Get("X-Forwarded-For") != "" || r.Header.Get("Forwarded") != ""
+
+	if r.ProtoMajor == 1 && r.ProtoMinor == 0 {
+		e.Signals = append(e.Signals, "request was downgraded to HTTP/1.0")
+		e.Hints = append(e.Hints, "configure the proxy to speak HTTP/1.1 to the backend (nginx: proxy_http_version 1.1)")
+	}
+
+	if !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		e.Signals = append(e.Signals, "Upgrade header missing while Sec-WebSocket-Key is present")
+		e.Hints = append(e.Hints, "Upgrade is a hop-by-hop header, forward it explicitly (nginx: proxy_set_header Upgrade $http_upgrade)")
+	}
+
+	if !headerContainsToken(r.Header, "Connection", "upgrade") {
+		e.Signals = append(e.Signals, "Connection header does not contain \"upgrade\"")
+		e.Hints = append(e.Hints, "forward the Connection header (nginx: proxy_set_header Connection \"upgrade\")")
+	}
+
+	if r.ContentLength > 0 || len(r.TransferEncoding) > 0 {
+		e.Signals = append(e.Signals, "upgrade request carries a body")
+		e.Hints = append(e.Hints, "disable request buffering for websocket routes (nginx: proxy_request_buffering off)")
+	}
+
+	if r.Header.Get("Content-Encoding") != "" {
+		e.Signals = append(e.Signals, "upgrade request was re-encoded (Content-Encoding: "+r.Header.Get("Content-Encoding")+")")
+		e.Hints = append(e.Hints, "disable compression on the proxy for websocket routes")
+	}
+
+	if len(e.Signals) == 0 {
+		return nil
+	}
+	if proxied {
+		e.Hints = append(e.Hints, "the request passed through a proxy (Via/Forwarded headers present), check its websocket support")
+	} else {
+		e.Hints = append(e.Hints, "no proxy announced itself, look for transparent proxies or corporate middleboxes")
+	}
+	return e
+}
+
+// reports whether any comma separated value of the header equals token, case insensitive
+func headerContainsToken(h http.Header, name string, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package simplewebsockets
+
+import "time"
+
+// Setter to be passed into the creation of a server. Pings every connection each interval. A ping is missed when
+// the peer sends neither a pong nor any other frame before the next one is due, and after maxMissed misses in a row
+// the connection is closed with 1002. The current miss count is in ConnectionStats.MissedPings. An interval of 0
+// disables keepalive pings.
+func WithKeepalive(interval time.Duration, maxMissed int) ServerOption {
+	return func(s *Server) {
+		s.keepaliveInterval = interval
+		s.maxMissedPings = maxMissed
+	}
+}
+
+// Setter to be passed into the creation of a server. Pings every connection each interval, like WithKeepalive
+// with the max misses set there (0 closes on the first missed ping).
+func WithPingInterval(interval time.Duration) ServerOption {
+	return func(s *Server) {
+		s.keepaliveInterval = interval
+	}
+}
+
+// Setter to be passed into the creation of a server. Lets the keepalive interval of each connection drift between
+// min and max, starting from the one set with WithKeepalive or WithPingInterval. A missed ping halves it so flaky
+// links are checked more often, and a ping answered without a round trip spike lengthens it by a quarter so stable
+// ones, e.g. idle mobile clients, cost less battery and bandwidth. Dead peers are still found within max times the
+// allowed misses. The current interval is in ConnectionStats.PingInterval.
+func WithAdaptiveKeepalive(min, max time.Duration) ServerOption {
+	return func(s *Server) {
+		s.minPingInterval = min
+		s.maxPingInterval = max
+	}
+}
+
+// Setter to be passed into the creation of a server. A connection that sends nothing within d after a keepalive
+// ping is dropped without a closing handshake, so its Disposition has code 1006 and ErrPongTimeout. Needs
+// WithPingInterval or WithKeepalive, d should be shorter than the ping interval.
+func WithPongTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.pongTimeout = d
+	}
+}
+
+// Sets a function called with the payload of every ping the peer sends, after it was answered with a pong.
+// It runs on the connection's read loop, so it should return quickly, and the payload is only valid during the call.
+func (c *Connection) OnPing(fn func([]byte)) {
+	c.handlerMx.Lock()
+	defer c.handlerMx.Unlock()
+	c.onPing = fn
+}
+
+// Sets a function called with the payload of every pong the peer sends, e.g. to measure round trip times with
+// SendPing. Like OnPing it runs on the read loop.
+func (c *Connection) OnPong(fn func([]byte)) {
+	c.handlerMx.Lock()
+	defer c.handlerMx.Unlock()
+	c.onPong = fn
+}
+
+// returns the ping or pong handler fn points to
+func (c *Connection) controlHandler(fn *func([]byte)) func([]byte) {
+	c.handlerMx.Lock()
+	defer c.handlerMx.Unlock()
+	return *fn
+}
+
+// Starts pinging the connection if keepalive is enabled. The returned function stops it.
+func (c *Connection) startKeepalive() (stop func()) {
+	s := c.server
+	if s.keepaliveInterval <= 0 {
+		return func() {}
+	}
+
+	interval := s.keepaliveInterval
+	if s.maxPingInterval > 0 {
+		interval = min(max(interval, s.minPingInterval), s.maxPingInterval)
+	}
+	c.pingInterval.Store(int64(interval))
+	ticker := s.clock.NewTicker(interval)
+	done := make(chan struct{})
+	spawn("keepalive", func() {
+		defer func() { ticker.Stop() }()
+		outstanding := false
+		var pongTimer Timer
+		defer func() {
+			if pongTimer != nil {
+				pongTimer.Stop()
+			}
+		}()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C():
+			}
+			if pongTimer != nil {
+				pongTimer.Stop() // it would see the reset below as a miss
+			}
+
+			missed := false
+			if c.heardFrom.Swap(false) {
+				c.missedPings.Store(0)
+			} else if outstanding {
+				if int(c.missedPings.Add(1)) >= s.maxMissedPings {
+					c.closeWithError(ErrPingTimeout, 1002, "Ping timeout")
+					return
+				}
+				missed = true
+			}
+
+			if s.maxPingInterval > 0 {
+				answered := outstanding && c.pingSentAt.Load() == 0
+				if next := c.nextPingInterval(interval, missed, answered); next != interval {
+					interval = next
+					c.pingInterval.Store(int64(interval))
+					ticker.Stop()
+					ticker = s.clock.NewTicker(interval)
+				}
+			}
+
+			c.pingSentAt.Store(s.clock.Now().UnixNano())
+			if err := c.SendPing(nil); err != nil {
+				return // the read loop notices the broken connection
+			}
+			outstanding = true
+
+			if s.pongTimeout > 0 {
+				pongTimer = s.clock.AfterFunc(s.pongTimeout, func() {
+					if !c.heardFrom.Load() {
+						c.setCloseError(ErrPongTimeout)
+						c.dropConn() // a peer that doesn't answer pings won't answer a close frame either
+					}
+				})
+			}
+		}
+	})
+	return func() { close(done) }
+}
+
+// Picks the keepalive interval after a round of pinging, see WithAdaptiveKeepalive.
+func (c *Connection) nextPingInterval(cur time.Duration, missed, answered bool) time.Duration {
+	switch {
+	case missed:
+		cur /= 2
+	case answered && !c.rttSpike.Load():
+		cur += cur / 4
+	}
+	return min(max(cur, c.server.minPingInterval), c.server.maxPingInterval)
+}
@@ -0,0 +1,122 @@
+package simplewebsockets_test
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+// Connects a simulated client to an echo server with keepalive options, returning the server's end.
+func keepaliveClient(t *testing.T, opts ...simplewebsockets.ServerOption) (*wstest.Simulation, *wstest.SimClient, *simplewebsockets.Connection) {
+	t.Helper()
+	sim := wstest.NewSimulation(1994, opts...)
+	sim.Server.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) {
+		c.SendTextMessage(string(msg))
+	})
+	conns := make(chan *simplewebsockets.Connection, 1)
+	sim.Server.OnConnect(func(c *simplewebsockets.Connection) { conns <- c })
+	c, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(c.Abort)
+	return sim, c, <-conns
+}
+
+// Advances the clock by one keepalive interval and reads the ping the server sends then.
+func expectPing(t *testing.T, sim *wstest.Simulation, c *wstest.SimClient, interval time.Duration) {
+	t.Helper()
+	sim.Clock.Advance(interval)
+	if r := nextFrame(t, c); r.Opcode != 0x9 {
+		t.Fatalf("got opcode %x, want a ping", r.Opcode)
+	}
+}
+
+// reports whether ctx ends within d
+func waitDone(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func nextFrame(t *testing.T, c *wstest.SimClient) simplewebsockets.Frame {
+	t.Helper()
+	timer := time.AfterFunc(5*time.Second, c.Abort)
+	defer timer.Stop()
+	f, err := c.NextFrame()
+	if err != nil {
+		t.Fatalf("reading the next frame: %v", err)
+	}
+	return f
+}
+
+func TestKeepaliveMissedPings(t *testing.T) {
+	const interval = 10 * time.Second
+	sim, c, sc := keepaliveClient(t, simplewebsockets.WithKeepalive(interval, 2))
+
+	expectPing(t, sim, c, interval)
+	expectPing(t, sim, c, interval)
+	if st := sc.Stats(); st.MissedPings != 1 {
+		t.Fatalf("stats %+v, want 1 missed ping", st)
+	}
+
+	// any frame from the peer counts as an answer
+	c.SendText("still here")
+	if r := nextReply(t, c); r.echo != "still here" {
+		t.Fatalf("server replied %+v, want the echo", r)
+	}
+	expectPing(t, sim, c, interval)
+	if st := sc.Stats(); st.MissedPings != 0 {
+		t.Fatalf("stats %+v, want the misses reset", st)
+	}
+
+	expectPing(t, sim, c, interval)
+	sim.Clock.Advance(interval)
+	if r := nextReply(t, c); r.closeCode != 1002 {
+		t.Fatalf("server replied %+v, want a close with 1002 after the second miss", r)
+	}
+}
+
+func TestPongTimeout(t *testing.T) {
+	const interval, timeout = 10 * time.Second, 2 * time.Second
+	sim, c, sc := keepaliveClient(t, simplewebsockets.WithPingInterval(interval), simplewebsockets.WithPongTimeout(timeout))
+
+	// a peer that answers stays connected
+	expectPing(t, sim, c, interval)
+	c.SendFrame(0xA, nil, true)
+	c.SendText("sync")
+	if r := nextReply(t, c); r.echo != "sync" {
+		t.Fatalf("server replied %+v, want the echo", r)
+	}
+	sim.Clock.Advance(timeout)
+	if waitDone(sc.Context(), 50*time.Millisecond) {
+		t.Fatal("connection dropped although the ping was answered")
+	}
+
+	// one that doesn't is dropped once the timeout passes
+	sim.Clock.Advance(interval - timeout)
+	if f := nextFrame(t, c); f.Opcode != 0x9 {
+		t.Fatalf("got opcode %x, want a ping", f.Opcode)
+	}
+	// the pong timer starts once the ping was written, advance in steps until it has fired
+	for step := 0; ; step++ {
+		sim.Clock.Advance(timeout / 10)
+		if waitDone(sc.Context(), 10*time.Millisecond) {
+			break
+		}
+		if step == 40 {
+			t.Fatal("connection wasn't dropped after the pong timeout")
+		}
+	}
+	if d, _ := sc.Disposition(); d.Clean || d.Code != 1006 || !errors.Is(d.Err, simplewebsockets.ErrPongTimeout) {
+		t.Errorf("disposition %+v, want an unclean close with 1006 and ErrPongTimeout", d)
+	}
+}
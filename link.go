@@ -0,0 +1,418 @@
+package simplewebsockets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Returned by Link.Send while the link has no connection.
+	ErrLinkDown = errors.New("link is down")
+	// Returned by Link.Wait and Link.Send once the link was closed.
+	ErrLinkClosed = errors.New("link closed")
+	// Matched (with errors.Is) by the error a connection of a link ends with when its health check failed.
+	ErrHealthCheckFailed = errors.New("link health check failed")
+)
+
+const (
+	defaultLinkMinBackoff = 500 * time.Millisecond
+	defaultLinkMaxBackoff = 30 * time.Second
+)
+
+// Options for Server.Link.
+type LinkOption func(*Link)
+
+// Dial options used for every connection of the link, e.g. WithDialHeader, or WithConnOptions for its message
+// handler so no message of a new connection is missed.
+func WithLinkDialOptions(opts ...DialOption) LinkOption {
+	return func(l *Link) {
+		l.dialOpts = append(l.dialOpts, opts...)
+	}
+}
+
+// Waits between min and max before dialing again after a failed dial or a lost connection. The wait doubles
+// from min with every failure in a row, with some jitter so many links don't redial in lockstep, and starts
+// over once a connection stayed up for max. The defaults are 500ms and 30s.
+func WithLinkBackoff(min, max time.Duration) LinkOption {
+	return func(l *Link) {
+		l.minBackoff = min
+		l.maxBackoff = max
+	}
+}
+
+// fn is called with every new connection of the link before Send and Wait hand it out, to replay subscriptions
+// or other state the peer lost along with the previous connection. Its ctx ends with the connection. If it
+// fails the connection is closed with 1011 and the link dials again.
+func WithLinkOnConnect(fn func(ctx context.Context, c *Connection) error) LinkOption {
+	return func(l *Link) {
+		l.onConnect = fn
+	}
+}
+
+// fn is called after a connection of the link that was up ended, with how it ended, before the link dials
+// again.
+func WithLinkOnDisconnect(fn func(c *Connection, d Disposition)) LinkOption {
+	return func(l *Link) {
+		l.onDisconnect = fn
+	}
+}
+
+// Runs check every interval while the link is up, e.g. a request the peer must answer, to notice a peer that
+// still answers pings but stopped working. A check that fails or doesn't return within interval closes the
+// connection with 1011 and ErrHealthCheckFailed, and the link reconnects. Dead connections are found by the
+// Server's WithKeepalive and WithPongTimeout, which apply to dialed connections too.
+func WithLinkHealthCheck(interval time.Duration, check func(ctx context.Context, c *Connection) error) LinkOption {
+	return func(l *Link) {
+		l.healthInterval = interval
+		l.healthCheck = check
+	}
+}
+
+// Resolves the link's URL again every interval while it is up, like its dial would: the host's A and AAAA
+// records, or the targets of its SRV records with WithDialSRV. If the address the connection went to is no
+// longer among them it is closed with 1001 and the link dials again, so a long lived link follows a target
+// that moved or was taken out of rotation. A failed lookup keeps the connection.
+func WithLinkReresolve(interval time.Duration) LinkOption {
+	return func(l *Link) {
+		l.reresolveInterval = interval
+	}
+}
+
+// A client connection to a URL that is kept up, for long lived links to another server such as an upstream
+// feed. When the connection is lost the link dials again with backoff, replays state through
+// WithLinkOnConnect and can check the peer's health. Create it with Server.Link or NewLink.
+type Link struct {
+	s        *Server
+	url      string
+	dialOpts []DialOption
+
+	minBackoff     time.Duration
+	maxBackoff     time.Duration
+	onConnect      func(ctx context.Context, c *Connection) error
+	onDisconnect   func(c *Connection, d Disposition)
+	healthInterval time.Duration
+	healthCheck    func(ctx context.Context, c *Connection) error
+	onUp           func() // called after a connection came up, for ClientPool.Wait
+
+	reresolveInterval time.Duration
+	outbox            *linkOutbox // nil unless WithLinkOutbox
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{} // closed once the link stopped
+
+	mx      sync.Mutex
+	conn    *Connection   // nil while down
+	up      chan struct{} // closed while conn is set
+	upSince time.Time
+	lastErr error
+
+	dials          atomic.Int64
+	dialFailures   atomic.Int64
+	reconnects     atomic.Int64
+	healthFailures atomic.Int64
+	failovers      atomic.Int64
+}
+
+// Counters of a link, see Link.Stats. The traffic of its current connection is in Link.Conn().Stats().
+type LinkStats struct {
+	Up                  bool
+	UpSince             time.Time // when the current connection came up, zero while down
+	Dials               int64     // connection attempts
+	DialFailures        int64     // attempts that failed to dial or in WithLinkOnConnect
+	Reconnects          int64     // connections that came up after the first
+	HealthCheckFailures int64
+	Failovers           int64 // connections closed because their address no longer resolved, see WithLinkReresolve
+	Held                int   // messages waiting for the next connection, see WithLinkOutbox
+	HeldExpired         int64 // held messages dropped because their TTL passed
+	LastError           error // why the last attempt, health check or connection failed
+}
+
+// Keeps a client connection to a ws:// or wss:// URL up until Close, dialing with this server like Dial.
+// It starts dialing right away, Wait blocks until a connection is up.
+func (s *Server) Link(rawURL string, opts ...LinkOption) *Link {
+	l := &Link{
+		s:          s,
+		url:        rawURL,
+		minBackoff: defaultLinkMinBackoff,
+		maxBackoff: defaultLinkMaxBackoff,
+		done:       make(chan struct{}),
+		up:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	spawn("link", l.run)
+	return l
+}
+
+// Keeps a client connection to a ws:// or wss:// URL up, using the shared server Dial uses. See Server.Link.
+func NewLink(rawURL string, opts ...LinkOption) *Link {
+	return sharedClient().Link(rawURL, opts...)
+}
+
+// Returns the link's current connection, nil while it is down.
+func (l *Link) Conn() *Connection {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.conn
+}
+
+// Waits until the link is up and returns its connection, or until ctx ends or the link is closed.
+func (l *Link) Wait(ctx context.Context) (*Connection, error) {
+	for {
+		l.mx.Lock()
+		c, up := l.conn, l.up
+		l.mx.Unlock()
+		if c != nil {
+			return c, nil
+		}
+		select {
+		case <-up:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-l.done:
+			return nil, ErrLinkClosed
+		}
+	}
+}
+
+// Sends a message on the current connection. Fails with ErrLinkDown instead of waiting while the link
+// reconnects, use Wait first to block until it is up, or WithLinkOutbox to hold the message until then.
+func (l *Link) Send(mt MessageType, data []byte) error {
+	select {
+	case <-l.done:
+		return ErrLinkClosed
+	default:
+	}
+	l.mx.Lock()
+	c := l.conn
+	if c == nil {
+		defer l.mx.Unlock()
+		if l.outbox != nil {
+			return l.outbox.add(mt, data, l.s.clock.Now())
+		}
+		return ErrLinkDown
+	}
+	l.mx.Unlock()
+	return c.SendMessage(mt, data)
+}
+
+// Returns the link's counters.
+func (l *Link) Stats() LinkStats {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return LinkStats{
+		Up:                  l.conn != nil,
+		UpSince:             l.upSince,
+		Dials:               l.dials.Load(),
+		DialFailures:        l.dialFailures.Load(),
+		Reconnects:          l.reconnects.Load(),
+		HealthCheckFailures: l.healthFailures.Load(),
+		Failovers:           l.failovers.Load(),
+		Held:                l.outbox.len(),
+		HeldExpired:         l.outbox.expiredCount(),
+		LastError:           l.lastErr,
+	}
+}
+
+// Stops the link: its connection is closed with 1000 and no new one is dialed. Returns once the connection
+// ended, which takes at most the close timeout.
+func (l *Link) Close() error {
+	l.cancel()
+	<-l.done
+	return nil
+}
+
+// dials and serves connections until the link is closed
+func (l *Link) run() {
+	defer close(l.done)
+
+	failures := 0
+	connected := false
+	for {
+		c, err := l.connect()
+		if err == nil {
+			if connected {
+				l.reconnects.Add(1)
+			}
+			connected = true
+			if l.serve(c) >= l.maxBackoff {
+				failures = 0 // it was stable, start over with short waits
+			}
+		} else {
+			l.dialFailures.Add(1)
+			l.setErr(err)
+			if errors.Is(err, ErrServerClosed) {
+				return
+			}
+		}
+		if l.ctx.Err() != nil {
+			return
+		}
+
+		failures++
+		if !l.sleep(l.backoff(failures)) {
+			return
+		}
+	}
+}
+
+// dials a connection and runs the OnConnect hook on it
+func (l *Link) connect() (*Connection, error) {
+	l.dials.Add(1)
+	ctx := l.ctx
+	if d := l.s.handeshakeTimeout; d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	c, err := l.s.Dial(ctx, l.url, l.dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.onConnect != nil {
+		if err := l.onConnect(c.Context(), c); err != nil {
+			err = fmt.Errorf("link on connect: %w", err)
+			c.closeWithError(err, 1011, "Link setup failed")
+			<-c.closed
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// hands out c until it ends or the link is closed, running the health check meanwhile. Returns how long c
+// was up.
+func (l *Link) serve(c *Connection) time.Duration {
+	l.mx.Lock()
+	l.flushOutbox(c)
+	l.conn = c
+	l.upSince = l.s.clock.Now()
+	close(l.up)
+	l.mx.Unlock()
+	if l.onUp != nil {
+		l.onUp()
+	}
+
+	var tick <-chan time.Time
+	if l.healthCheck != nil && l.healthInterval > 0 {
+		ticker := l.s.clock.NewTicker(l.healthInterval)
+		defer ticker.Stop()
+		tick = ticker.C()
+	}
+	var recheck <-chan time.Time
+	if l.reresolveInterval > 0 {
+		ticker := l.s.clock.NewTicker(l.reresolveInterval)
+		defer ticker.Stop()
+		recheck = ticker.C()
+	}
+
+	for ended := false; !ended; {
+		select {
+		case <-c.closed:
+			ended = true
+		case <-l.ctx.Done():
+			c.Close(1000, "Link closed")
+			<-c.closed
+			ended = true
+		case <-tick:
+			if err := l.checkHealth(c); err != nil {
+				l.healthFailures.Add(1)
+				c.closeWithError(err, 1011, "Health check failed")
+			}
+		case <-recheck:
+			if l.targetMoved(c) {
+				l.failovers.Add(1)
+				c.Close(1001, "Target moved")
+			}
+		}
+	}
+
+	l.mx.Lock()
+	up := l.s.clock.Now().Sub(l.upSince)
+	l.conn = nil
+	l.upSince = time.Time{}
+	l.up = make(chan struct{})
+	l.mx.Unlock()
+
+	d, _ := c.Disposition()
+	if d.Err != nil {
+		l.setErr(d.Err)
+	}
+	if l.onDisconnect != nil && l.ctx.Err() == nil {
+		l.onDisconnect(c, d)
+	}
+	return up
+}
+
+// runs the health check once, bounded by its interval
+func (l *Link) checkHealth(c *Connection) error {
+	ctx, cancel := context.WithTimeout(c.Context(), l.healthInterval)
+	defer cancel()
+	err := l.healthCheck(ctx, c)
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err() // returned in time but only because ctx ended
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrHealthCheckFailed, err)
+	}
+	return nil
+}
+
+// resolves the link's URL again, reporting whether c's address is no longer among the results
+func (l *Link) targetMoved(c *Connection) bool {
+	ctx, cancel := context.WithTimeout(l.ctx, l.reresolveInterval)
+	defer cancel()
+	addrs, err := l.s.resolveAddrs(ctx, l.url, l.dialOpts)
+	if err != nil {
+		l.setErr(err)
+		return false
+	}
+	remote, err := netip.ParseAddrPort(c.conn.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	return !slices.Contains(addrs, netip.AddrPortFrom(remote.Addr().Unmap(), remote.Port()))
+}
+
+// the wait before the attempt following n failures in a row: min doubled n-1 times, capped at max, of which
+// up to half is jitter
+func (l *Link) backoff(n int) time.Duration {
+	d := l.maxBackoff
+	if n < 32 {
+		d = min(l.minBackoff<<(n-1), l.maxBackoff)
+	}
+	if half := int64(d / 2); half > 0 {
+		d = time.Duration(half + rand.Int64N(half+1))
+	}
+	return d
+}
+
+// waits d on the server's clock, reporting false if the link was closed meanwhile
+func (l *Link) sleep(d time.Duration) bool {
+	wake := make(chan struct{})
+	t := l.s.clock.AfterFunc(d, func() { close(wake) })
+	defer t.Stop()
+	select {
+	case <-wake:
+		return true
+	case <-l.ctx.Done():
+		return false
+	}
+}
+
+func (l *Link) setErr(err error) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.lastErr = err
+}
@@ -0,0 +1,242 @@
+package simplewebsockets_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+// Starts a server on a loopback port, returning its ws:// URL. Connections it accepts are sent on conns.
+func startServer(t *testing.T, opts ...simplewebsockets.ServerOption) (*simplewebsockets.Server, string, chan *simplewebsockets.Connection) {
+	t.Helper()
+	s := simplewebsockets.NewServer(opts...)
+	conns := make(chan *simplewebsockets.Connection, 16)
+	s.OnConnect(func(c *simplewebsockets.Connection) { conns <- c })
+	if err := s.Bind("127.0.0.1:0"); err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+	go s.Serve()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return s, "ws://" + s.Addr().String(), conns
+}
+
+func waitUp(t *testing.T, l *simplewebsockets.Link) *simplewebsockets.Connection {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := l.Wait(ctx)
+	if err != nil {
+		t.Fatalf("link didn't come up: %v", err)
+	}
+	return c
+}
+
+func TestLinkReconnectsAndReplays(t *testing.T) {
+	s, url, conns := startServer(t)
+	subscribed := make(chan string, 4)
+	s.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) {
+		subscribed <- string(msg)
+	})
+
+	var disconnects atomic.Int32
+	client := simplewebsockets.NewServer()
+	l := client.Link(url,
+		simplewebsockets.WithLinkBackoff(10*time.Millisecond, 100*time.Millisecond),
+		simplewebsockets.WithLinkOnConnect(func(ctx context.Context, c *simplewebsockets.Connection) error {
+			return c.SendTextMessage("subscribe")
+		}),
+		simplewebsockets.WithLinkOnDisconnect(func(c *simplewebsockets.Connection, d simplewebsockets.Disposition) {
+			disconnects.Add(1)
+		}))
+	defer l.Close()
+
+	first := waitUp(t, l)
+	if msg := <-subscribed; msg != "subscribe" {
+		t.Fatalf("server got %q, want the subscription", msg)
+	}
+
+	// the peer goes away, the link dials again and replays the subscription
+	(<-conns).NetConn().Close()
+	select {
+	case msg := <-subscribed:
+		if msg != "subscribe" {
+			t.Fatalf("server got %q, want the replayed subscription", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription wasn't replayed")
+	}
+	<-conns
+	if c := waitUp(t, l); c == first {
+		t.Fatal("link still hands out the lost connection")
+	}
+
+	if err := l.Send(simplewebsockets.TextMessage, []byte("data")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if msg := <-subscribed; msg != "data" {
+		t.Fatalf("server got %q, want %q", msg, "data")
+	}
+
+	st := l.Stats()
+	if !st.Up || st.Reconnects != 1 || st.Dials != 2 || disconnects.Load() != 1 {
+		t.Errorf("stats %+v with %d disconnects, want up after 2 dials, 1 reconnect and 1 disconnect", st, disconnects.Load())
+	}
+}
+
+func TestLinkHealthCheck(t *testing.T) {
+	_, url, conns := startServer(t)
+
+	var checks atomic.Int32
+	client := simplewebsockets.NewServer()
+	l := client.Link(url,
+		simplewebsockets.WithLinkBackoff(10*time.Millisecond, 100*time.Millisecond),
+		simplewebsockets.WithLinkHealthCheck(20*time.Millisecond, func(ctx context.Context, c *simplewebsockets.Connection) error {
+			if checks.Add(1) == 1 {
+				return errors.New("upstream stalled")
+			}
+			return nil
+		}))
+	defer l.Close()
+
+	first := waitUp(t, l)
+	<-conns
+	select {
+	case <-conns:
+	case <-time.After(5 * time.Second):
+		t.Fatal("link didn't reconnect after the failed health check")
+	}
+	if d, _ := first.Disposition(); !errors.Is(d.Err, simplewebsockets.ErrHealthCheckFailed) {
+		t.Errorf("first connection ended with %v, want ErrHealthCheckFailed", d.Err)
+	}
+	waitUp(t, l)
+	if st := l.Stats(); st.HealthCheckFailures != 1 || !errors.Is(st.LastError, simplewebsockets.ErrHealthCheckFailed) {
+		t.Errorf("stats %+v, want 1 health check failure", st)
+	}
+}
+
+func TestLinkDialFailures(t *testing.T) {
+	// a port nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "ws://" + ln.Addr().String()
+	ln.Close()
+
+	client := simplewebsockets.NewServer()
+	l := client.Link(url, simplewebsockets.WithLinkBackoff(time.Millisecond, 5*time.Millisecond))
+	deadline := time.Now().Add(5 * time.Second)
+	for l.Stats().DialFailures < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := l.Send(simplewebsockets.TextMessage, []byte("x")); !errors.Is(err, simplewebsockets.ErrLinkDown) {
+		t.Errorf("send on a down link: %v, want ErrLinkDown", err)
+	}
+	l.Close()
+
+	st := l.Stats()
+	if st.Up || st.DialFailures < 3 || st.LastError == nil {
+		t.Errorf("stats %+v, want at least 3 failed dials and their error", st)
+	}
+}
+
+func TestLinkClose(t *testing.T) {
+	wstest.VerifyNoLeaks(t)
+	_, url, conns := startServer(t)
+
+	client := simplewebsockets.NewServer()
+	l := client.Link(url)
+	c := waitUp(t, l)
+	sc := <-conns
+
+	l.Close()
+	if d, _ := c.Disposition(); !d.Clean {
+		t.Errorf("link's connection ended with %+v, want a clean close", d)
+	}
+	<-sc.Context().Done()
+	if _, err := l.Wait(context.Background()); !errors.Is(err, simplewebsockets.ErrLinkClosed) {
+		t.Errorf("wait after close: %v, want ErrLinkClosed", err)
+	}
+	if err := l.Send(simplewebsockets.TextMessage, []byte("x")); !errors.Is(err, simplewebsockets.ErrLinkClosed) {
+		t.Errorf("send after close: %v, want ErrLinkClosed", err)
+	}
+}
+
+func TestLinkOutbox(t *testing.T) {
+	// nothing listens on the link's port until the server starts below
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	client := simplewebsockets.NewServer()
+	l := client.Link("ws://"+addr,
+		simplewebsockets.WithLinkBackoff(10*time.Millisecond, 50*time.Millisecond),
+		simplewebsockets.WithLinkOnConnect(func(ctx context.Context, c *simplewebsockets.Connection) error {
+			return c.SendTextMessage("subscribe")
+		}),
+		simplewebsockets.WithLinkOutbox(2, 0, 100*time.Millisecond))
+	defer l.Close()
+
+	send := func(msg string) error { return l.Send(simplewebsockets.TextMessage, []byte(msg)) }
+	if err := send("stale"); err != nil {
+		t.Fatalf("send while down: %v", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	for _, msg := range []string{"first", "second"} {
+		if err := send(msg); err != nil {
+			t.Fatalf("send while down: %v", err)
+		}
+	}
+	if err := send("third"); !errors.Is(err, simplewebsockets.ErrLinkOutboxFull) {
+		t.Fatalf("send to a full outbox: %v, want ErrLinkOutboxFull", err)
+	}
+	if st := l.Stats(); st.Held != 2 || st.HeldExpired != 1 {
+		t.Fatalf("stats %+v, want 2 messages held and 1 expired", st)
+	}
+
+	s := simplewebsockets.NewServer()
+	received := make(chan string, 8)
+	s.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) {
+		received <- string(msg)
+	})
+	if err := s.Bind(addr); err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+	go s.Serve()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	}()
+
+	waitUp(t, l)
+	if err := send("live"); err != nil {
+		t.Fatalf("send while up: %v", err)
+	}
+	for _, want := range []string{"subscribe", "first", "second", "live"} {
+		select {
+		case msg := <-received:
+			if msg != want {
+				t.Fatalf("server got %q, want %q", msg, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("server didn't get %q", want)
+		}
+	}
+	if st := l.Stats(); st.Held != 0 {
+		t.Errorf("stats %+v, want the outbox flushed", st)
+	}
+}
@@ -0,0 +1,116 @@
+package simplewebsockets
+
+import (
+	"errors"
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"time"
+)
+
+// Set on connections closed because they were idle while memory ran short, see WithMemoryWatermark.
+var ErrMemoryPressure = errors.New("evicted under memory pressure")
+
+// Published on every check that finds the heap above the watermark.
+type MemoryPressureEvent struct {
+	HeapBytes uint64
+	Watermark uint64
+	Evicted   int // idle connections closed by this check
+}
+
+// Setter to be passed into the creation of a server. Every interval the heap is compared to watermark bytes;
+// above it, connections give up their read and write buffers once they are empty, and connections that
+// haven't sent or received a message for evictIdle are closed with 1001 (0 never evicts). A watermark of 0
+// uses 90% of the runtime's soft memory limit (debug.SetMemoryLimit, GOMEMLIMIT), and does nothing if
+// there is none.
+func WithMemoryWatermark(watermark uint64, interval time.Duration, evictIdle time.Duration) ServerOption {
+	return func(s *Server) {
+		s.memWatermark = watermark
+		s.memInterval = interval
+		s.memEvictIdle = evictIdle
+	}
+}
+
+// Starts checking the heap against the watermark if one is configured.
+func (s *Server) startMemoryMonitor() {
+	if s.memInterval <= 0 {
+		return
+	}
+	watermark := s.memWatermark
+	if watermark == 0 {
+		limit := debug.SetMemoryLimit(-1)
+		if limit == math.MaxInt64 {
+			return // no soft limit set
+		}
+		watermark = uint64(limit) / 10 * 9
+	}
+
+	spawn("memory monitor", func() {
+		ticker := s.clock.NewTicker(s.memInterval)
+		defer ticker.Stop()
+		sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+		for {
+			select {
+			case <-ticker.C():
+			case <-s.done:
+				return
+			}
+			metrics.Read(sample)
+			heap := sample[0].Value.Uint64()
+			if heap > watermark {
+				evicted := s.relieveMemory()
+				Publish(s.events, MemoryPressureEvent{HeapBytes: heap, Watermark: watermark, Evicted: evicted})
+			}
+		}
+	})
+}
+
+// Trims the buffers of every connection and evicts idle ones. Returns the number of evicted connections.
+func (s *Server) relieveMemory() int {
+	s.connectionsMx.RLock()
+	conns := make([]*Connection, 0, len(s.connections))
+	for c := range s.connections {
+		conns = append(conns, c)
+	}
+	s.connectionsMx.RUnlock()
+
+	now := s.clock.Now()
+	evicted := 0
+	for _, c := range conns {
+		if s.memEvictIdle > 0 && now.Sub(c.lastActive()) >= s.memEvictIdle && c.IsOpen() {
+			c.closeWithError(ErrMemoryPressure, 1001, "Server under memory pressure")
+			evicted++
+			continue
+		}
+
+		// the read loop owns the read side buffers, it drops them after its next read
+		c.trimBuffers.Store(true)
+
+		// skip connections in the middle of a write rather than waiting for them
+		if c.writeMx.TryLock() {
+			c.writeBuf = nil
+			c.writeMx.Unlock()
+		}
+	}
+	return evicted
+}
+
+// Called by the read loop between reads. Drops the frame and message buffers if a trim was requested and
+// they hold no partial data.
+func (c *Connection) trimReadBuffers(msg *[]byte) {
+	if !c.trimBuffers.Load() || len(c.frameBuffer) > 0 || len(*msg) > 0 {
+		return
+	}
+	c.trimBuffers.Store(false)
+	c.frameBuffer = nil
+	*msg = nil
+}
+
+// when the connection last sent or received a message, or was opened
+func (c *Connection) lastActive() time.Time {
+	return time.Unix(0, c.activeAt.Load())
+}
+
+func (c *Connection) markActive() {
+	c.activeAt.Store(c.server.clock.Now().UnixNano())
+}
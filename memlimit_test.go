@@ -0,0 +1,82 @@
+package simplewebsockets_test
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+func TestMemoryWatermark(t *testing.T) {
+	const evictIdle = 30 * time.Second
+	// any heap is above a 1 byte watermark
+	sim := wstest.NewSimulation(2006, simplewebsockets.WithMemoryWatermark(1, time.Second, evictIdle))
+	sim.Server.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) {
+		c.SendTextMessage(string(msg))
+	})
+	evicted := make(chan int, 64)
+	simplewebsockets.Subscribe(sim.Server.Events(), func(e simplewebsockets.MemoryPressureEvent) {
+		if e.Evicted > 0 {
+			evicted <- e.Evicted
+		}
+	})
+	conns := make(chan *simplewebsockets.Connection, 2)
+	sim.Server.OnConnect(func(c *simplewebsockets.Connection) { conns <- c })
+
+	active, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer active.Abort()
+	activeConn := <-conns
+	idle, err := sim.Connect(rand.New(rand.NewSource(sim.Seed + 1)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer idle.Abort()
+	idleConn := <-conns
+
+	// the active client keeps talking while the clock moves on, the monitor's ticker may start late
+	start := sim.Clock.Now()
+	for step := 0; !waitDone(idleConn.Context(), 10*time.Millisecond); step++ {
+		if step == 40 {
+			t.Fatal("idle connection wasn't evicted")
+		}
+		sim.Clock.Advance(5 * time.Second)
+		active.SendText("tick")
+		if r := nextReply(t, active); r.echo != "tick" {
+			t.Fatalf("active client got %+v, want the echo", r)
+		}
+	}
+
+	if idleFor := sim.Clock.Now().Sub(start); idleFor < evictIdle {
+		t.Errorf("evicted after %v idle, want at least %v", idleFor, evictIdle)
+	}
+	if r := nextReply(t, idle); r.closeCode != 1001 {
+		t.Errorf("idle client got %+v, want a close with 1001", r)
+	}
+	if d, _ := idleConn.Disposition(); !errors.Is(d.Err, simplewebsockets.ErrMemoryPressure) {
+		t.Errorf("disposition %+v, want ErrMemoryPressure", d)
+	}
+	select {
+	case n := <-evicted:
+		if n != 1 {
+			t.Errorf("%d connections evicted, want 1", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no memory pressure event with the eviction")
+	}
+
+	// the active connection works on with trimmed buffers
+	if !activeConn.IsOpen() {
+		t.Fatal("active connection was evicted")
+	}
+	active.SendText("after the trim")
+	if r := nextReply(t, active); r.echo != "after the trim" {
+		t.Errorf("active client got %+v, want the echo", r)
+	}
+}
@@ -0,0 +1,19 @@
+package simplewebsockets
+
+// Type of a data message, the values match the websocket opcodes.
+type MessageType int
+
+const (
+	TextMessage   MessageType = 0x1
+	BinaryMessage MessageType = 0x2
+)
+
+func (mt MessageType) String() string {
+	switch mt {
+	case TextMessage:
+		return "text"
+	case BinaryMessage:
+		return "binary"
+	}
+	return "unknown"
+}
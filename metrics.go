@@ -0,0 +1,213 @@
+package simplewebsockets
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fixed bucket histogram. Counts[i] holds observations <= Bounds[i], the last count holds the rest.
+type Histogram struct {
+	mx     sync.Mutex
+	bounds []float64
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+// Point in time copy of a Histogram.
+type HistogramSnapshot struct {
+	Bounds []float64
+	Counts []int64 // len(Bounds)+1, last bucket is +Inf
+	Count  int64
+	Sum    float64
+}
+
+func newHistogram(bounds ...float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)+1),
+	}
+}
+
+func (h *Histogram) observe(v float64) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += v
+}
+
+// Returns a copy of the current buckets.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	return HistogramSnapshot{
+		Bounds: append([]float64(nil), h.bounds...),
+		Counts: append([]int64(nil), h.counts...),
+		Count:  h.count,
+		Sum:    h.sum,
+	}
+}
+
+// Close handshake metrics. A clean close completed the close handshake, an abortive one lost the
+// tcp connection or timed out waiting for the peer.
+type CloseMetrics struct {
+	Clean    int64
+	Abortive int64
+
+	// close codes received from peers, 1005 is counted for close frames without a status
+	Codes map[uint16]int64
+
+	// seconds between sending a close frame and receiving the peer's response
+	HandshakeDuration HistogramSnapshot
+}
+
+// Counts of connections closed for violating protocol limits.
+type ProtocolMetrics struct {
+	TooManyFragments int64
+}
+
+// Counts of messages the application never saw.
+type MessageMetrics struct {
+	Unhandled int64 // arrived while the connection had no OnMessage handler
+	Invalid   int64 // rejected by the message validator
+	Replayed  int64 // reused a nonce, see WithReplayProtection
+	Skewed    int64 // signed too far from server time
+
+	// payload sizes in bytes of data messages received and sent
+	InboundSize  HistogramSnapshot
+	OutboundSize HistogramSnapshot
+}
+
+// Snapshot of the server wide metrics.
+type Metrics struct {
+	Close     CloseMetrics
+	Protocol  ProtocolMetrics
+	Messages  MessageMetrics
+	Shed      ShedMetrics
+	Admission AdmissionMetrics
+}
+
+// Counts of upgrades rejected by connection limits.
+type AdmissionMetrics struct {
+	OverCapacity int64 // the server was at WithMaxConnections
+	OverIPLimit  int64 // the client was at WithMaxConnectionsPerIP
+}
+
+// Counts of work dropped by load shedding, see WithLoadShedding.
+type ShedMetrics struct {
+	Upgrades   int64 // rejected with a 503
+	Broadcasts int64 // to paused rooms
+	Messages   int64 // dropped from send queues
+}
+
+// server wide counters, updated from connection goroutines
+type serverMetrics struct {
+	cleanCloses    atomic.Int64
+	abortiveCloses atomic.Int64
+
+	closeCodesMx sync.Mutex
+	closeCodes   map[uint16]int64
+
+	closeDuration *Histogram
+
+	tooManyFragments atomic.Int64
+
+	unhandledMessages atomic.Int64
+	invalidMessages   atomic.Int64
+	replayedMessages  atomic.Int64
+	skewedMessages    atomic.Int64
+
+	inboundSize  *Histogram
+	outboundSize *Histogram
+
+	shedUpgrades   atomic.Int64
+	shedBroadcasts atomic.Int64
+	shedMessages   atomic.Int64
+
+	overCapacity atomic.Int64
+	overIPLimit  atomic.Int64
+}
+
+// bucket bounds in bytes for message size histograms
+var messageSizeBuckets = []float64{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		closeCodes:    make(map[uint16]int64),
+		closeDuration: newHistogram(0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5),
+		inboundSize:   newHistogram(messageSizeBuckets...),
+		outboundSize:  newHistogram(messageSizeBuckets...),
+	}
+}
+
+// Returns a snapshot of the server metrics.
+func (s *Server) Metrics() Metrics {
+	m := s.metrics
+
+	m.closeCodesMx.Lock()
+	codes := make(map[uint16]int64, len(m.closeCodes))
+	for code, n := range m.closeCodes {
+		codes[code] = n
+	}
+	m.closeCodesMx.Unlock()
+
+	return Metrics{
+		Close: CloseMetrics{
+			Clean:             m.cleanCloses.Load(),
+			Abortive:          m.abortiveCloses.Load(),
+			Codes:             codes,
+			HandshakeDuration: m.closeDuration.Snapshot(),
+		},
+		Protocol: ProtocolMetrics{
+			TooManyFragments: m.tooManyFragments.Load(),
+		},
+		Messages: MessageMetrics{
+			Unhandled: m.unhandledMessages.Load(),
+			Invalid:   m.invalidMessages.Load(),
+			Replayed:  m.replayedMessages.Load(),
+			Skewed:    m.skewedMessages.Load(),
+
+			InboundSize:  m.inboundSize.Snapshot(),
+			OutboundSize: m.outboundSize.Snapshot(),
+		},
+		Shed: ShedMetrics{
+			Upgrades:   m.shedUpgrades.Load(),
+			Broadcasts: m.shedBroadcasts.Load(),
+			Messages:   m.shedMessages.Load(),
+		},
+		Admission: AdmissionMetrics{
+			OverCapacity: m.overCapacity.Load(),
+			OverIPLimit:  m.overIPLimit.Load(),
+		},
+	}
+}
+
+// records the close code of a close frame received from the peer
+func (m *serverMetrics) closeCode(payload []byte) {
+	code := uint16(1005) // no status received
+	if len(payload) >= 2 {
+		code = uint16(payload[0])<<8 | uint16(payload[1])
+	}
+	m.closeCodesMx.Lock()
+	m.closeCodes[code]++
+	m.closeCodesMx.Unlock()
+}
+
+// records a completed close handshake at now, started is zero if the peer initiated it
+func (m *serverMetrics) cleanClose(started time.Time, now time.Time) {
+	m.cleanCloses.Add(1)
+	if !started.IsZero() {
+		m.closeDuration.observe(now.Sub(started).Seconds())
+	}
+}
+
+func (m *serverMetrics) abortiveClose() {
+	m.abortiveCloses.Add(1)
+}
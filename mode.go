@@ -0,0 +1,42 @@
+package simplewebsockets
+
+import "errors"
+
+// Restricts the direction data messages may flow on a connection. Control frames (ping, pong, close)
+// are always allowed.
+type ConnectionMode int32
+
+const (
+	ModeReadWrite ConnectionMode = iota
+	ModeReadOnly                 // the server only receives, outbound data messages are denied (broadcasts skip the connection)
+	ModeWriteOnly                // the server only sends, inbound data messages are discarded
+)
+
+// Returned when sending a data message on a read-only connection.
+var ErrReadOnly = errors.New("connection is read-only")
+
+// Setter to be passed into the creation of a server. Sets the mode new connections start in,
+// e.g. ModeWriteOnly for broadcast-only tickers or ModeReadOnly for ingest-only telemetry endpoints.
+func WithConnectionMode(mode ConnectionMode) ServerOption {
+	return func(s *Server) {
+		s.connMode = mode
+	}
+}
+
+// Changes the mode of the connection.
+func (c *Connection) SetMode(mode ConnectionMode) {
+	c.mode.Store(int32(mode))
+}
+
+// Returns the current mode of the connection.
+func (c *Connection) Mode() ConnectionMode {
+	return ConnectionMode(c.mode.Load())
+}
+
+// checks that data messages may be sent on the connection
+func (c *Connection) checkWritable() error {
+	if c.Mode() == ModeReadOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package simplewebsockets
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Returns the network connection the websocket runs on, for its addresses or to drop it without a closing
+// handshake. Reading from or writing to it corrupts the websocket stream. A read deadline set on it ends the
+// connection once it passes, like a lost peer. Deadlines set on it survive the library's own short lived
+// ones, e.g. the write timeout of broadcasts.
+func (c *Connection) NetConn() net.Conn {
+	return appConn{c.conn, c}
+}
+
+// The net.Conn handed out by NetConn. It remembers the deadlines the application sets.
+type appConn struct {
+	net.Conn
+	c *Connection
+}
+
+func (a appConn) Close() error {
+	return a.c.dropConn()
+}
+
+func (a appConn) SetDeadline(t time.Time) error {
+	storeDeadline(&a.c.appReadDeadline, t)
+	storeDeadline(&a.c.appWriteDeadline, t)
+	return a.Conn.SetDeadline(t)
+}
+
+func (a appConn) SetReadDeadline(t time.Time) error {
+	storeDeadline(&a.c.appReadDeadline, t)
+	return a.Conn.SetReadDeadline(t)
+}
+
+func (a appConn) SetWriteDeadline(t time.Time) error {
+	storeDeadline(&a.c.appWriteDeadline, t)
+	return a.Conn.SetWriteDeadline(t)
+}
+
+func storeDeadline(v *atomic.Int64, t time.Time) {
+	if t.IsZero() {
+		v.Store(0)
+	} else {
+		v.Store(t.UnixNano())
+	}
+}
+
+// Puts the read deadline back to the one the application set, after the library's own expired.
+func (c *Connection) restoreReadDeadline() {
+	c.conn.SetReadDeadline(loadDeadline(&c.appReadDeadline))
+}
+
+// Puts the write deadline back to the one the application set.
+func (c *Connection) restoreWriteDeadline() {
+	c.conn.SetWriteDeadline(loadDeadline(&c.appWriteDeadline))
+}
+
+func loadDeadline(v *atomic.Int64) time.Time {
+	if ns := v.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
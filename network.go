@@ -0,0 +1,23 @@
+package simplewebsockets
+
+import "fmt"
+
+// Setter to be passed into the creation of a server. Sets the network the server listens and dials on: "tcp"
+// (the default) for both IPv4 and IPv6, "tcp4" or "tcp6" for only one of them. With "tcp" an address like
+// "[::]:8080" or ":8080" binds dual-stack where the system supports it.
+func WithNetwork(network string) ServerOption {
+	return func(s *Server) {
+		s.network = network
+	}
+}
+
+// Returns the network to listen and dial on, or an error if WithNetwork got something other than TCP.
+func (s *Server) tcpNetwork() (string, error) {
+	switch s.network {
+	case "":
+		return "tcp", nil
+	case "tcp", "tcp4", "tcp6":
+		return s.network, nil
+	}
+	return "", fmt.Errorf("unsupported network %q, expected tcp, tcp4 or tcp6", s.network)
+}
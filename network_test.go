@@ -0,0 +1,84 @@
+package simplewebsockets_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+func TestListenNetwork(t *testing.T) {
+	tests := []struct {
+		network string
+		address string
+		other   string // an address of the other stack, which must be refused
+	}{
+		{"tcp4", "127.0.0.1:0", "[::1]:0"},
+		{"tcp6", "[::1]:0", "127.0.0.1:0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			if ln, err := net.Listen(tt.network, tt.address); err != nil {
+				t.Skipf("%s isn't available: %v", tt.network, err)
+			} else {
+				ln.Close()
+			}
+
+			s := simplewebsockets.NewServer(simplewebsockets.WithNetwork(tt.network))
+			if err := s.Bind(tt.other); err == nil {
+				t.Fatalf("%s server bound to %s", tt.network, s.Addr())
+			}
+			if err := s.Bind(tt.address); err != nil {
+				t.Fatalf("bind: %v", err)
+			}
+			addr := s.Addr().(*net.TCPAddr)
+			if is4 := addr.IP.To4() != nil; is4 != (tt.network == "tcp4") {
+				t.Fatalf("%s server bound to %s", tt.network, addr)
+			}
+
+			s.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) {
+				c.SendTextMessage(string(msg))
+			})
+			go s.Serve()
+			t.Cleanup(func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				s.Shutdown(ctx)
+			})
+
+			// dial over the same stack, from a client restricted to it
+			client := simplewebsockets.NewServer(simplewebsockets.WithNetwork(tt.network))
+			got := make(chan string, 1)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			c, err := client.Dial(ctx, "ws://"+addr.String(), simplewebsockets.WithConnOptions(
+				simplewebsockets.WithMessageHandler(func(msg []byte) { got <- string(msg) })))
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer c.NetConn().Close()
+
+			if err := c.SendTextMessage("over " + tt.network); err != nil {
+				t.Fatalf("send: %v", err)
+			}
+			select {
+			case msg := <-got:
+				if msg != "over "+tt.network {
+					t.Errorf("echo %q, want %q", msg, "over "+tt.network)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no echo")
+			}
+		})
+	}
+}
+
+func TestListenUnsupportedNetwork(t *testing.T) {
+	s := simplewebsockets.NewServer(simplewebsockets.WithNetwork("udp"))
+	if err := s.Bind("127.0.0.1:0"); err == nil {
+		t.Fatal("server bound on udp")
+	}
+}
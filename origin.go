@@ -0,0 +1,48 @@
+package simplewebsockets
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Matched (with errors.Is) by the error returned for upgrades rejected by WithOriginCheck.
+var ErrOriginNotAllowed = errors.New("origin not allowed")
+
+// Setter to be passed into the creation of a server. fn is called with the Origin header of every upgrade
+// request, and upgrades it returns false for are rejected with a 403. Browsers always send Origin, so this
+// keeps other sites from opening connections in a user's name. Clients that aren't browsers usually send no
+// Origin, fn gets "" for them. See AllowedOrigins for a plain allowlist.
+func WithOriginCheck(fn func(origin string) bool) ServerOption {
+	return func(s *Server) {
+		s.originCheck = fn
+	}
+}
+
+// Returns an origin check for WithOriginCheck that accepts the given origins (e.g. "https://app.example.com",
+// compared case-insensitively) and requests without an Origin header.
+func AllowedOrigins(origins ...string) func(origin string) bool {
+	return func(origin string) bool {
+		if origin == "" {
+			return true
+		}
+		for _, allowed := range origins {
+			if strings.EqualFold(origin, allowed) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Runs the origin check, if one is set.
+func (s *Server) checkOrigin(r *http.Request) error {
+	if s.originCheck == nil {
+		return nil
+	}
+	if origin := r.Header.Get("Origin"); !s.originCheck(origin) {
+		return fmt.Errorf("%w: %q", ErrOriginNotAllowed, origin)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package simplewebsockets
+
+import (
+	"errors"
+	"time"
+)
+
+// Returned by Link.Send while the link is down and its outbox has no room for the message.
+var ErrLinkOutboxFull = errors.New("link outbox is full")
+
+// Holds messages sent while the link is down, so a short outage doesn't lose them, and sends them in order
+// once the next connection is up, after WithLinkOnConnect and before Send and Wait hand it out. It holds at most
+// maxMessages messages of maxBytes payload bytes in total (0 for no limit), a message that doesn't fit fails
+// with ErrLinkOutboxFull. Messages held longer than ttl (0 for no limit) are dropped instead of sent. Messages
+// still held when the link is closed are dropped.
+func WithLinkOutbox(maxMessages int, maxBytes int64, ttl time.Duration) LinkOption {
+	return func(l *Link) {
+		l.outbox = &linkOutbox{maxMessages: maxMessages, maxBytes: maxBytes, ttl: ttl}
+	}
+}
+
+// messages held while a link is down, guarded by the link's mutex
+type linkOutbox struct {
+	maxMessages int
+	maxBytes    int64
+	ttl         time.Duration
+
+	msgs    []heldMessage
+	bytes   int64
+	expired int64
+}
+
+type heldMessage struct {
+	mt   MessageType
+	data []byte
+	at   time.Time
+}
+
+// holds a copy of data unless the outbox is full
+func (o *linkOutbox) add(mt MessageType, data []byte, now time.Time) error {
+	o.expire(now)
+	size := int64(len(data))
+	if (o.maxMessages > 0 && len(o.msgs) >= o.maxMessages) || (o.maxBytes > 0 && o.bytes+size > o.maxBytes) {
+		return ErrLinkOutboxFull
+	}
+	o.msgs = append(o.msgs, heldMessage{mt, append([]byte(nil), data...), now})
+	o.bytes += size
+	return nil
+}
+
+// removes and returns the messages that haven't expired yet, oldest first
+func (o *linkOutbox) take(now time.Time) []heldMessage {
+	if o == nil {
+		return nil
+	}
+	o.expire(now)
+	msgs := o.msgs
+	o.msgs, o.bytes = nil, 0
+	return msgs
+}
+
+// holds msgs again, ahead of the messages held since they were taken
+func (o *linkOutbox) putBack(msgs []heldMessage) {
+	for _, m := range msgs {
+		o.bytes += int64(len(m.data))
+	}
+	o.msgs = append(msgs, o.msgs...)
+}
+
+// drops the messages held longer than the ttl
+func (o *linkOutbox) expire(now time.Time) {
+	if o.ttl <= 0 {
+		return
+	}
+	n := 0
+	for n < len(o.msgs) && now.Sub(o.msgs[n].at) > o.ttl {
+		o.bytes -= int64(len(o.msgs[n].data))
+		n++
+	}
+	o.expired += int64(n)
+	o.msgs = o.msgs[n:]
+}
+
+func (o *linkOutbox) len() int {
+	if o == nil {
+		return 0
+	}
+	return len(o.msgs)
+}
+
+func (o *linkOutbox) expiredCount() int64 {
+	if o == nil {
+		return 0
+	}
+	return o.expired
+}
+
+// Sends the messages the outbox held on c, which isn't handed out yet. Messages sent on the link meanwhile
+// are held as well and sent in the next round, so all keep their order. Called with l.mx held, which it
+// releases while sending. If c fails the unsent messages are held for the next connection.
+func (l *Link) flushOutbox(c *Connection) {
+	for msgs := l.outbox.take(l.s.clock.Now()); len(msgs) > 0; msgs = l.outbox.take(l.s.clock.Now()) {
+		l.mx.Unlock()
+		sent := 0
+		for _, m := range msgs {
+			if err := c.SendMessage(m.mt, m.data); err != nil {
+				break
+			}
+			sent++
+		}
+		l.mx.Lock()
+		if sent < len(msgs) {
+			l.outbox.putBack(msgs[sent:])
+			return
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package simplewebsockets
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// How ClientPool picks the connection for a message.
+type PoolStrategy int
+
+const (
+	// Takes the connections in turn.
+	RoundRobin PoolStrategy = iota
+	// Takes the connection with the fewest messages outstanding: sends still being written plus messages
+	// waiting in its send queue, see WithSendQueue.
+	LeastLoaded
+)
+
+// Options for Server.ClientPool.
+type PoolOption func(*ClientPool)
+
+// Sets how messages are spread over the connections, RoundRobin by default.
+func WithPoolStrategy(strategy PoolStrategy) PoolOption {
+	return func(p *ClientPool) {
+		p.strategy = strategy
+	}
+}
+
+// Link options used for every connection of the pool, e.g. WithLinkDialOptions or WithLinkBackoff.
+func WithPoolLinkOptions(opts ...LinkOption) PoolOption {
+	return func(p *ClientPool) {
+		p.linkOpts = append(p.linkOpts, opts...)
+	}
+}
+
+// A fixed number of client connections to one or more URLs that messages are spread over, for producers pushing
+// more into an ingest endpoint than one connection carries. Every connection is a Link, so a failed one is
+// replaced by dialing again while the others take its share. Create it with Server.ClientPool or NewClientPool.
+type ClientPool struct {
+	members  []*poolMember
+	strategy PoolStrategy
+	linkOpts []LinkOption
+	next     atomic.Uint64
+	closed   atomic.Bool
+
+	mx sync.Mutex
+	up chan struct{} // closed and replaced when a link comes up or the pool is closed
+}
+
+type poolMember struct {
+	link     *Link
+	inflight atomic.Int64 // sends being written
+}
+
+// Counters of a pool, see ClientPool.Stats.
+type PoolStats struct {
+	Up    int         // connections that are up
+	Links []LinkStats // of every connection, in the order of ClientPool.Links
+}
+
+// Keeps size client connections up, dialing this server like Dial. Connection i dials targets[i%len(targets)],
+// so a pool of 4 over 2 URLs keeps 2 connections to each. A size below 1 means one connection per target.
+// Connections are dialed right away, Wait blocks until one is up.
+func (s *Server) ClientPool(size int, targets []string, opts ...PoolOption) (*ClientPool, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("client pool needs at least one target")
+	}
+	if size < 1 {
+		size = len(targets)
+	}
+
+	p := &ClientPool{up: make(chan struct{})}
+	for _, opt := range opts {
+		opt(p)
+	}
+	linkOpts := append(slices.Clip(p.linkOpts), func(l *Link) { l.onUp = p.wake })
+	for i := range size {
+		p.members = append(p.members, &poolMember{link: s.Link(targets[i%len(targets)], linkOpts...)})
+	}
+	return p, nil
+}
+
+// Keeps client connections to the targets up, using the shared server Dial uses. See Server.ClientPool.
+func NewClientPool(size int, targets []string, opts ...PoolOption) (*ClientPool, error) {
+	return sharedClient().ClientPool(size, targets, opts...)
+}
+
+// Returns the pool's links, one per connection.
+func (p *ClientPool) Links() []*Link {
+	links := make([]*Link, len(p.members))
+	for i, m := range p.members {
+		links[i] = m.link
+	}
+	return links
+}
+
+// Waits until at least one connection of the pool is up, or until ctx ends or the pool is closed.
+func (p *ClientPool) Wait(ctx context.Context) error {
+	for {
+		p.mx.Lock()
+		up := p.up
+		p.mx.Unlock()
+		if p.closed.Load() {
+			return ErrLinkClosed
+		}
+		for _, m := range p.members {
+			if m.link.Conn() != nil {
+				return nil
+			}
+		}
+		select {
+		case <-up:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Sends a message on a connection picked by the pool's strategy. If the write fails the connection is dropped,
+// so its link dials again, and the message is sent on the next one. Fails with ErrLinkDown if no connection
+// is up.
+func (p *ClientPool) Send(mt MessageType, data []byte) error {
+	return p.each(func(c *Connection) (bool, error) {
+		err := c.SendMessage(mt, data)
+		if err == nil || errors.Is(err, ErrReadOnly) {
+			return true, err
+		}
+		// a failed write may leave a partial frame behind, the connection is unusable
+		c.setCloseError(err)
+		c.dropConn()
+		return false, err
+	})
+}
+
+// Queues a message on a connection picked by the pool's strategy, see Connection.Enqueue. A full send queue
+// passes the message on to the next connection, ErrSendQueueFull is returned once every queue is full.
+// Requires WithSendQueue on the server the pool dials with.
+func (p *ClientPool) Enqueue(mt MessageType, data []byte, opts ...SendOption) error {
+	return p.each(func(c *Connection) (bool, error) {
+		err := c.Enqueue(mt, data, opts...)
+		if errors.Is(err, ErrSendQueueFull) || errors.Is(err, net.ErrClosed) {
+			return false, err
+		}
+		return true, err
+	})
+}
+
+// Returns the pool's counters.
+func (p *ClientPool) Stats() PoolStats {
+	var stats PoolStats
+	for _, m := range p.members {
+		st := m.link.Stats()
+		if st.Up {
+			stats.Up++
+		}
+		stats.Links = append(stats.Links, st)
+	}
+	return stats
+}
+
+// Closes every link of the pool, see Link.Close. Returns once all connections ended.
+func (p *ClientPool) Close() error {
+	p.closed.Store(true)
+	p.wake()
+	var wg sync.WaitGroup
+	for _, m := range p.members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.link.Close()
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// calls send with the pool's connections that are up, in the order of its strategy, until one reports the
+// message as done. Returns the error of the last attempt.
+func (p *ClientPool) each(send func(c *Connection) (done bool, err error)) error {
+	if p.closed.Load() {
+		return ErrLinkClosed
+	}
+	err := ErrLinkDown
+	for _, m := range p.order() {
+		c := m.link.Conn()
+		if c == nil {
+			continue
+		}
+		m.inflight.Add(1)
+		done, sendErr := send(c)
+		m.inflight.Add(-1)
+		if done {
+			return sendErr
+		}
+		err = sendErr
+	}
+	return err
+}
+
+// the members in the order they should be tried
+func (p *ClientPool) order() []*poolMember {
+	n := len(p.members)
+	start := int(p.next.Add(1) % uint64(n))
+	order := append(slices.Clone(p.members[start:]), p.members[:start]...)
+	if p.strategy == LeastLoaded {
+		// stable, so ties are still taken in turn
+		slices.SortStableFunc(order, func(a, b *poolMember) int {
+			return int(a.load() - b.load())
+		})
+	}
+	return order
+}
+
+// messages outstanding on the member's connection
+func (m *poolMember) load() int64 {
+	load := m.inflight.Load()
+	if c := m.link.Conn(); c != nil && c.queue != nil {
+		load += int64(c.queue.len())
+	}
+	return load
+}
+
+func (p *ClientPool) wake() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	close(p.up)
+	p.up = make(chan struct{})
+}
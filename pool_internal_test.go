@@ -0,0 +1,81 @@
+package simplewebsockets
+
+import (
+	"testing"
+	"time"
+)
+
+// Returns a pool whose links are up on unconnected connections, so the strategy can be tested without a peer.
+func fakePool(strategy PoolStrategy, size int) *ClientPool {
+	p := &ClientPool{strategy: strategy, up: make(chan struct{})}
+	for range size {
+		p.members = append(p.members, &poolMember{link: &Link{conn: &Connection{}}})
+	}
+	return p
+}
+
+// sends one message through p, returning the index of the member that took it
+func picked(t *testing.T, p *ClientPool) int {
+	t.Helper()
+	idx := -1
+	p.each(func(c *Connection) (bool, error) {
+		for i, m := range p.members {
+			if m.link.conn == c {
+				idx = i
+			}
+		}
+		return true, nil
+	})
+	return idx
+}
+
+func TestClientPoolLeastLoaded(t *testing.T) {
+	p := fakePool(LeastLoaded, 3)
+
+	// ties are taken in turn
+	seen := make(map[int]bool)
+	for range 3 {
+		seen[picked(t, p)] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("idle pool used members %v, want all 3 in turn", seen)
+	}
+
+	p.members[0].inflight.Store(2)
+	q := newSendQueue(8)
+	for range 3 {
+		q.push(&queuedMessage{}, time.Now())
+	}
+	p.members[1].link.conn.queue = q
+	for range 3 {
+		if i := picked(t, p); i != 2 {
+			t.Fatalf("picked member %d, want the idle member 2", i)
+		}
+	}
+
+	p.members[2].inflight.Store(5)
+	if i := picked(t, p); i != 0 {
+		t.Fatalf("picked member %d, want member 0 with 2 sends in flight", i)
+	}
+
+	// a member that is down is skipped
+	p.members[0].link.conn = nil
+	if i := picked(t, p); i != 1 {
+		t.Fatalf("picked member %d, want member 1 with 3 queued messages", i)
+	}
+}
+
+func TestClientPoolFailsOver(t *testing.T) {
+	p := fakePool(RoundRobin, 3)
+	var tried []*Connection
+	err := p.each(func(c *Connection) (bool, error) {
+		tried = append(tried, c)
+		return len(tried) == 3, nil
+	})
+	if err != nil || len(tried) != 3 {
+		t.Fatalf("message was tried on %d members with %v, want it taken by the third", len(tried), err)
+	}
+	if tried[0] == tried[1] || tried[1] == tried[2] || tried[0] == tried[2] {
+		t.Fatal("a member was tried twice")
+	}
+}
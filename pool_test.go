@@ -0,0 +1,146 @@
+package simplewebsockets_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+func waitPoolUp(t *testing.T, p *simplewebsockets.ClientPool, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Stats().Up < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d pool connections up", p.Stats().Up, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClientPoolRoundRobin(t *testing.T) {
+	s, url, _ := startServer(t)
+	var (
+		mx       sync.Mutex
+		received = make(map[*simplewebsockets.Connection]int)
+		got      = make(chan struct{}, 16)
+	)
+	s.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) {
+		mx.Lock()
+		received[c]++
+		mx.Unlock()
+		got <- struct{}{}
+	})
+
+	client := simplewebsockets.NewServer()
+	p, err := client.ClientPool(3, []string{url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	waitPoolUp(t, p, 3)
+
+	for i := 0; i < 9; i++ {
+		if err := p.Send(simplewebsockets.TextMessage, []byte("event")); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 9; i++ {
+		select {
+		case <-got:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("server got %d of 9 messages", i)
+		}
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+	if len(received) != 3 {
+		t.Fatalf("messages went to %d connections, want 3", len(received))
+	}
+	for c, n := range received {
+		if n != 3 {
+			t.Errorf("connection %d got %d messages, want 3", c.ID(), n)
+		}
+	}
+}
+
+func TestClientPoolReplacesFailedConnection(t *testing.T) {
+	_, urlA, connsA := startServer(t)
+	_, urlB, connsB := startServer(t)
+
+	client := simplewebsockets.NewServer()
+	p, err := client.ClientPool(2, []string{urlA, urlB},
+		simplewebsockets.WithPoolLinkOptions(simplewebsockets.WithLinkBackoff(10*time.Millisecond, 100*time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	waitPoolUp(t, p, 2)
+	<-connsB
+
+	// target A drops its connection, the pool keeps sending on B while A's is replaced
+	(<-connsA).NetConn().Close()
+	for i := 0; i < 20; i++ {
+		if err := p.Send(simplewebsockets.BinaryMessage, []byte{byte(i)}); err != nil {
+			t.Fatalf("send %d while a connection was lost: %v", i, err)
+		}
+	}
+	select {
+	case <-connsA:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lost connection wasn't replaced")
+	}
+	waitPoolUp(t, p, 2)
+
+	st := p.Stats()
+	if st.Links[0].Reconnects != 1 || st.Links[1].Reconnects != 0 {
+		t.Errorf("reconnects %d and %d, want only the first link to reconnect", st.Links[0].Reconnects, st.Links[1].Reconnects)
+	}
+}
+
+func TestClientPoolDownAndClosed(t *testing.T) {
+	client := simplewebsockets.NewServer()
+	if _, err := client.ClientPool(2, nil); err == nil {
+		t.Fatal("pool without targets was created")
+	}
+
+	// a port nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "ws://" + ln.Addr().String()
+	ln.Close()
+
+	p, err := client.ClientPool(0, []string{url, url},
+		simplewebsockets.WithPoolLinkOptions(simplewebsockets.WithLinkBackoff(time.Millisecond, 5*time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(p.Links()); n != 2 {
+		t.Fatalf("pool of size 0 has %d links, want one per target", n)
+	}
+	if err := p.Send(simplewebsockets.TextMessage, []byte("x")); !errors.Is(err, simplewebsockets.ErrLinkDown) {
+		t.Errorf("send with no connection up: %v, want ErrLinkDown", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait with no connection up: %v, want the context's error", err)
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- p.Wait(context.Background()) }()
+	p.Close()
+	if err := <-waited; !errors.Is(err, simplewebsockets.ErrLinkClosed) {
+		t.Errorf("wait interrupted by close: %v, want ErrLinkClosed", err)
+	}
+	if err := p.Send(simplewebsockets.TextMessage, []byte("x")); !errors.Is(err, simplewebsockets.ErrLinkClosed) {
+		t.Errorf("send after close: %v, want ErrLinkClosed", err)
+	}
+}
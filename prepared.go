@@ -0,0 +1,97 @@
+package simplewebsockets
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A message encoded once and sent as is to any number of connections. Connections only differ in their
+// checksum envelope, extension and compression level, so the frame is built on first use for each such
+// combination and reused after that. Safe for concurrent use.
+type PreparedMessage struct {
+	mt   MessageType
+	data []byte
+
+	mx      sync.Mutex
+	encoded map[messageEncoding]encodedMessage
+}
+
+// a prepared frame and, if compressed, its payload size before and after compression
+type encodedMessage struct {
+	frame     []byte
+	raw, wire int
+}
+
+type messageEncoding struct {
+	checksum  ChecksumAlgorithm
+	transform byte   // RSV bits of the extension, see messageTransform
+	level     int    // compression level, if transform is deflate
+	dict      string // id of the preset dictionary, if transform is deflate with one
+}
+
+// Prepares a text or binary message for SendPrepared. data must not be modified afterwards.
+func NewPreparedMessage(mt MessageType, data []byte) (*PreparedMessage, error) {
+	if mt != TextMessage && mt != BinaryMessage {
+		return nil, fmt.Errorf("unsupported message type %v", mt)
+	}
+	return newPreparedMessage(mt, data), nil
+}
+
+func newPreparedMessage(mt MessageType, data []byte) *PreparedMessage {
+	return &PreparedMessage{mt: mt, data: data, encoded: make(map[messageEncoding]encodedMessage)}
+}
+
+// Sends a prepared message, writing the frame bytes built for connections like c.
+func (c *Connection) SendPrepared(pm *PreparedMessage) error {
+	return c.sendPrepared(pm, 0)
+}
+
+// sends pm, dropping the connection if the write doesn't finish within timeout (0 for no limit)
+func (c *Connection) sendPrepared(pm *PreparedMessage, timeout time.Duration) error {
+	if c.client {
+		return c.writeMessage(byte(pm.mt), pm.data) // needs its own mask key
+	}
+	e := pm.frame(c)
+	err := c.writeBroadcast(e.frame, int64(len(pm.data)), timeout)
+	if err == nil && e.raw > 0 {
+		c.countCompressed(Outbound, e.raw, e.wire)
+	}
+	return err
+}
+
+// returns the encoding of the message for c, building it on first use
+func (pm *PreparedMessage) frame(c *Connection) encodedMessage {
+	s := c.server
+	enc := messageEncoding{checksum: c.checksum, transform: c.messageTransform(byte(pm.mt), len(pm.data))}
+	if enc.transform == rsvCompressed {
+		enc.level = s.compressionLevel
+		if c.deflate == deflateDict {
+			enc.dict = s.compressionDictID
+		}
+	}
+
+	pm.mx.Lock()
+	defer pm.mx.Unlock()
+	if e, ok := pm.encoded[enc]; ok {
+		return e
+	}
+
+	payload := pm.data
+	if pm.mt == BinaryMessage {
+		payload = c.checksum.seal(pm.data)
+	}
+	wire, rsv := s.transformPayload(enc.transform, c.deflate, payload)
+	frames := msgToFrames(wire, streamFrameSize)
+	frames[0].Opcode = byte(pm.mt)
+	frames[0].RSV = rsv
+	var e encodedMessage
+	for _, f := range frames {
+		e.frame = f.AppendTo(e.frame)
+	}
+	if rsv != 0 {
+		e.raw, e.wire = len(payload), len(wire)
+	}
+	pm.encoded[enc] = e
+	return e
+}
@@ -0,0 +1,230 @@
+package simplewebsockets
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Returned by Connection.Enqueue when the connection already has the maximum number of messages queued.
+	ErrSendQueueFull = errors.New("send queue is full")
+	// Returned by Connection.Enqueue when the server was created without WithSendQueue.
+	ErrNoSendQueue = errors.New("send queues are not enabled")
+)
+
+// Setter to be passed into the creation of a server. Gives every connection a queue of up to size outbound
+// messages, written in order by a goroutine of the connection. Connection.Enqueue adds to the queue without
+// waiting for a slow consumer.
+func WithSendQueue(size int) ServerOption {
+	return func(s *Server) {
+		s.sendQueueSize = size
+	}
+}
+
+// Per message options for Connection.Enqueue.
+type SendOption func(*queuedMessage)
+
+// Drops the message if it hasn't been written within d of being queued, e.g. for price ticks that are
+// worthless once stale. Dropped messages are counted in ConnectionStats.Expired.
+func TTL(d time.Duration) SendOption {
+	return func(m *queuedMessage) {
+		m.ttl = d
+	}
+}
+
+// Conflates queued messages: a message replaces the queued message with the same key instead of being
+// appended, so slow consumers of e.g. market data get the latest state rather than a backlog of stale
+// updates. The replacement keeps the older message's place in the queue. Replaced messages are counted
+// in ConnectionStats.Conflated.
+func ConflationKey(key string) SendOption {
+	return func(m *queuedMessage) {
+		m.key = key
+	}
+}
+
+type queuedMessage struct {
+	mt      MessageType
+	data    []byte
+	ttl     time.Duration
+	expires time.Time // zero if the message never expires
+	key     string    // conflation key, "" if the message is never conflated
+
+	priority Priority
+}
+
+func (m *queuedMessage) expired(now time.Time) bool {
+	return !m.expires.IsZero() && !now.Before(m.expires)
+}
+
+// Bounded FIFO of messages waiting to be written.
+type sendQueue struct {
+	mx     sync.Mutex
+	cond   *sync.Cond
+	items  []*queuedMessage
+	size   int
+	closed bool
+	keyed  map[string]*queuedMessage // queued messages by conflation key
+
+	expired   atomic.Int64
+	conflated atomic.Int64
+}
+
+func newSendQueue(size int) *sendQueue {
+	q := &sendQueue{size: size, keyed: make(map[string]*queuedMessage)}
+	q.cond = sync.NewCond(&q.mx)
+	return q
+}
+
+func (q *sendQueue) push(m *queuedMessage, now time.Time) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	if q.closed {
+		return net.ErrClosed
+	}
+	if old, ok := q.keyed[m.key]; ok && m.key != "" {
+		*old = *m
+		q.conflated.Add(1)
+		return nil
+	}
+	if len(q.items) >= q.size {
+		q.dropExpired(now)
+		if len(q.items) >= q.size {
+			return ErrSendQueueFull
+		}
+	}
+	q.items = append(q.items, m)
+	if m.key != "" {
+		q.keyed[m.key] = m
+	}
+	q.cond.Signal()
+	return nil
+}
+
+// Waits for the next message that hasn't expired. Returns nil once the queue is closed.
+func (q *sendQueue) pop(clock Clock) *queuedMessage {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	for {
+		for len(q.items) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			return nil
+		}
+		m := q.items[0]
+		q.items[0] = nil
+		q.items = q.items[1:]
+		q.forget(m)
+		if m.expired(clock.Now()) {
+			q.expired.Add(1)
+			continue
+		}
+		return m
+	}
+}
+
+// removes expired messages, callers must hold mx
+func (q *sendQueue) dropExpired(now time.Time) {
+	kept := q.items[:0]
+	for _, m := range q.items {
+		if m.expired(now) {
+			q.expired.Add(1)
+			q.forget(m)
+			continue
+		}
+		kept = append(kept, m)
+	}
+	clear(q.items[len(kept):])
+	q.items = kept
+}
+
+// removes m from the conflation index, callers must hold mx
+func (q *sendQueue) forget(m *queuedMessage) {
+	if m.key != "" && q.keyed[m.key] == m {
+		delete(q.keyed, m.key)
+	}
+}
+
+func (q *sendQueue) len() int {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	return len(q.items)
+}
+
+// Reports whether a message with the conflation key is still waiting to be written.
+func (q *sendQueue) pending(key string) bool {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	_, ok := q.keyed[key]
+	return ok
+}
+
+// Discards queued messages and wakes the writer.
+func (q *sendQueue) close() {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	q.closed = true
+	q.items = nil
+	clear(q.keyed)
+	q.cond.Broadcast()
+}
+
+// Queues a data message to be written by the connection's writer goroutine. data is copied, so the caller
+// may reuse it. Requires WithSendQueue.
+func (c *Connection) Enqueue(mt MessageType, data []byte, opts ...SendOption) error {
+	q := c.queue
+	if q == nil {
+		return ErrNoSendQueue
+	}
+	if err := c.checkWritable(); err != nil {
+		return err
+	}
+
+	m := &queuedMessage{mt: mt, data: append([]byte(nil), data...)}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if c.server.shouldShed(m.priority) {
+		c.server.metrics.shedMessages.Add(1)
+		return ErrLoadShed
+	}
+	now := c.server.clock.Now()
+	if m.ttl > 0 {
+		m.expires = now.Add(m.ttl)
+	}
+	return q.push(m, now)
+}
+
+// Starts the writer goroutine draining the connection's send queue. The returned function stops it and
+// discards whatever is still queued.
+func (c *Connection) startSendQueue() (stop func()) {
+	q := c.queue
+	if q == nil {
+		return func() {}
+	}
+	spawn("send queue", func() {
+		for {
+			m := q.pop(c.server.clock)
+			if m == nil {
+				return
+			}
+			err := c.writeMessage(byte(m.mt), m.data)
+			if errors.Is(err, ErrReadOnly) {
+				continue // mode changed after the message was queued
+			}
+			if err != nil {
+				// a failed write may leave a partial frame behind, the connection is unusable
+				c.setCloseError(err)
+				c.dropConn()
+				q.close()
+				return
+			}
+		}
+	})
+	return q.close
+}
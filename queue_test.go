@@ -0,0 +1,98 @@
+package simplewebsockets_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+// Connects a simulated client to a server with send queues, returning the server's end.
+func queueClient(t *testing.T) (*wstest.Simulation, *wstest.SimClient, *simplewebsockets.Connection) {
+	t.Helper()
+	sim := wstest.NewSimulation(1976, simplewebsockets.WithSendQueue(16))
+	conns := make(chan *simplewebsockets.Connection, 1)
+	sim.Server.OnConnect(func(c *simplewebsockets.Connection) { conns <- c })
+	c, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(c.Abort)
+	return sim, c, <-conns
+}
+
+// Holds the server's end with an unfinished message, so queued messages wait behind it like they would for a
+// slow consumer. The send queue's writer may already have taken the first of them and waits as well. The
+// returned func completes the held message, "held", and lets the queue drain.
+func holdWriter(t *testing.T, c *simplewebsockets.Connection) (release func()) {
+	t.Helper()
+	w, err := c.NextWriter(simplewebsockets.TextMessage)
+	if err != nil {
+		t.Fatalf("next writer: %v", err)
+	}
+	w.Write([]byte("held"))
+	return func() { w.Close() }
+}
+
+func enqueue(t *testing.T, c *simplewebsockets.Connection, msg string, opts ...simplewebsockets.SendOption) {
+	t.Helper()
+	if err := c.Enqueue(simplewebsockets.TextMessage, []byte(msg), opts...); err != nil {
+		t.Fatalf("enqueue %q: %v", msg, err)
+	}
+}
+
+// Reads messages from c until it got as many as want, comparing them.
+func expectMessages(t *testing.T, c *wstest.SimClient, want ...string) {
+	t.Helper()
+	timer := time.AfterFunc(5*time.Second, c.Abort)
+	defer timer.Stop()
+	for _, w := range want {
+		_, msg, err := c.NextMessage()
+		if err != nil {
+			t.Fatalf("reading %q: %v", w, err)
+		}
+		if string(msg) != w {
+			t.Fatalf("got %q, want %q", msg, w)
+		}
+	}
+}
+
+func TestQueueTTL(t *testing.T) {
+	sim, c, sc := queueClient(t)
+	release := holdWriter(t, sc)
+	enqueue(t, sc, "blocking")
+	enqueue(t, sc, "tick 1", simplewebsockets.TTL(time.Second))
+	enqueue(t, sc, "tick 2", simplewebsockets.TTL(time.Minute))
+	enqueue(t, sc, "order filled")
+
+	sim.Clock.Advance(2 * time.Second)
+	release()
+	expectMessages(t, c, "held", "blocking", "tick 2", "order filled")
+	if st := sc.Stats(); st.Expired != 1 {
+		t.Errorf("stats %+v, want 1 expired message", st)
+	}
+}
+
+func TestQueueConflation(t *testing.T) {
+	_, c, sc := queueClient(t)
+	release := holdWriter(t, sc)
+	enqueue(t, sc, "blocking")
+	enqueue(t, sc, "AAPL 101", simplewebsockets.ConflationKey("AAPL"))
+	enqueue(t, sc, "MSFT 330", simplewebsockets.ConflationKey("MSFT"))
+	enqueue(t, sc, "AAPL 102", simplewebsockets.ConflationKey("AAPL"))
+	enqueue(t, sc, "AAPL 103", simplewebsockets.ConflationKey("AAPL"))
+	enqueue(t, sc, "unkeyed")
+
+	// the latest AAPL price takes the place of the first
+	release()
+	expectMessages(t, c, "held", "blocking", "AAPL 103", "MSFT 330", "unkeyed")
+	if st := sc.Stats(); st.Conflated != 2 {
+		t.Errorf("stats %+v, want 2 conflated messages", st)
+	}
+
+	// once written, a key starts over at the end of the queue
+	enqueue(t, sc, "AAPL 104", simplewebsockets.ConflationKey("AAPL"))
+	expectMessages(t, c, "AAPL 104")
+}
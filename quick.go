@@ -0,0 +1,42 @@
+package simplewebsockets
+
+// Serves a single connection from start to end, see Handle. It can read the connection with
+// ReadMessage in a plain loop instead of setting handlers.
+type HandlerFunc func(c *Connection)
+
+// Creates a server with options that runs fn in its own goroutine for every new connection, and closes the
+// connection with 1000 once fn returns. Meant for scripts, tests and examples; use OnConnect and the
+// message handlers for anything bigger.
+func Handle(fn HandlerFunc, options ...ServerOption) *Server {
+	s := NewServer(options...)
+	s.OnConnect(func(c *Connection) {
+		spawn("handler", func() {
+			defer c.Close(1000, "")
+			fn(c)
+		})
+	})
+	return s
+}
+
+// Listens on address and serves every connection with fn, see Handle. Blocks like Listen.
+func ListenAndHandle(address string, fn HandlerFunc, options ...ServerOption) error {
+	return Handle(fn, options...).Listen(address)
+}
+
+// Listens on address and sends every message back to its connection as it arrived, text as text and
+// binary as binary. Handy for trying out clients. Blocks like Listen.
+func Echo(address string, options ...ServerOption) error {
+	return ListenAndHandle(address, echo, options...)
+}
+
+func echo(c *Connection) {
+	for {
+		mt, data, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		if c.SendMessage(mt, data) != nil {
+			return
+		}
+	}
+}
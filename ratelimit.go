@@ -0,0 +1,53 @@
+package simplewebsockets
+
+import (
+	"sync"
+	"time"
+)
+
+// Simple token bucket used for per tenant limits. A rate of 0 means unlimited.
+type rateLimiter struct {
+	mx     sync.Mutex
+	rate   float64 // tokens added per second
+	burst  float64 // max tokens held at once
+	tokens float64
+	last   time.Time
+	clock  Clock
+}
+
+func newRateLimiter(rate float64, burst float64, clock Clock) *rateLimiter {
+	if burst < rate {
+		burst = rate
+	}
+	return &rateLimiter{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   clock.Now(),
+		clock:  clock,
+	}
+}
+
+// Takes n tokens from the bucket if available. Always succeeds when the limiter is unlimited.
+func (r *rateLimiter) allow(n float64) bool {
+	if r == nil || r.rate <= 0 {
+		return true
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	// refill based on time since last call
+	now := r.clock.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+
+	if r.tokens < n {
+		return false
+	}
+	r.tokens -= n
+	return true
+}
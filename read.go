@@ -0,0 +1,86 @@
+package simplewebsockets
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Messages a pull reader (ReadMessageContext) can fall behind before the read loop waits for it.
+const inboxSize = 64
+
+// Returned by the ReadMessage methods once the connection ended and every received message was read.
+var ErrConnectionClosed = errors.New("connection closed")
+
+// A message waiting for a pull reader.
+type inboundMessage struct {
+	mt   MessageType
+	data []byte
+}
+
+// Waits for the next data message of the connection, or until ctx ends. A reader that ran out of time can
+// simply call again, the connection isn't affected. From the first call on, messages are read this way
+// instead of being passed to OnMessage, starting with any held under UnhandledBuffer. Once the connection
+// ended the remaining messages are returned, then an error matching ErrConnectionClosed.
+func (c *Connection) ReadMessageContext(ctx context.Context) (MessageType, []byte, error) {
+	inbox := c.startPull()
+	select {
+	case m := <-inbox:
+		return m.mt, m.data, nil
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	case <-c.closed:
+	}
+
+	// the read loop has stopped, hand out what it delivered before
+	select {
+	case m := <-inbox:
+		return m.mt, m.data, nil
+	default:
+	}
+	return 0, nil, context.Cause(c.ctx)
+}
+
+// Waits for the next data message of the connection, however long it takes. This is the blocking, gorilla
+// style way of reading a connection instead of setting OnMessage, see ReadMessageContext.
+func (c *Connection) ReadMessage() (MessageType, []byte, error) {
+	return c.ReadMessageContext(context.Background())
+}
+
+// Like ReadMessageContext, waiting at most d. Returns context.DeadlineExceeded when no message arrived in time.
+func (c *Connection) ReadMessageDeadline(d time.Duration) (MessageType, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return c.ReadMessageContext(ctx)
+}
+
+// Changes the size limit of the messages the connection receives, which starts at the server's
+// WithMaxMessageSize. Messages over it close the connection with 1009, 0 means no limit.
+func (c *Connection) SetReadLimit(n int64) {
+	c.maxSize.Store(n)
+}
+
+// Switches the connection to pull reads, returning the inbox messages are delivered to.
+func (c *Connection) startPull() chan inboundMessage {
+	c.handlerMx.Lock()
+	defer c.handlerMx.Unlock()
+	if c.inbox == nil {
+		c.inbox = make(chan inboundMessage, max(inboxSize, len(c.unhandled)))
+		for _, m := range c.unhandled {
+			c.inbox <- inboundMessage{m.mt, m.data}
+		}
+		c.unhandled = nil
+	}
+	return c.inbox
+}
+
+// Delivers a message to the pull reader, waiting while the inbox is full.
+func (c *Connection) pushMessage(inbox chan inboundMessage, mt MessageType, data []byte) {
+	// the read loop reuses its message buffer
+	m := inboundMessage{mt, append([]byte(nil), data...)}
+	select {
+	case inbox <- m:
+	case <-c.ctx.Done():
+	case <-c.drained: // closing, the message is dropped
+	}
+}
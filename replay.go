@@ -0,0 +1,89 @@
+package simplewebsockets
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Matched (with errors.Is) by the error reported for a signed message that was rejected as a replay.
+var ErrReplay = errors.New("replayed message")
+
+// Details about a rejected replay: either the nonce was already used on the connection, or the message
+// was signed too far from the server's current time.
+type ReplayError struct {
+	Nonce     string
+	Duplicate bool
+	Skew      time.Duration // signing time minus server time, only set if the nonce wasn't a duplicate
+}
+
+func (e *ReplayError) Error() string {
+	if e.Duplicate {
+		return fmt.Sprintf("%v: nonce %q was already used", ErrReplay, e.Nonce)
+	}
+	return fmt.Sprintf("%v: nonce %q was signed %v from server time", ErrReplay, e.Nonce, e.Skew)
+}
+
+func (e *ReplayError) Unwrap() error {
+	return ErrReplay
+}
+
+// Setter to be passed into the creation of a server. For clients that sign their messages: verify checks a
+// message's signature and returns its nonce and signing time. Messages it rejects are dropped like those
+// of a message validator. Messages signed more than maxSkew away from the server's time, or reusing one of
+// the last window nonces of their connection, are dropped and reported through OnError as a *ReplayError.
+// Rejected replays are counted in Metrics().Messages.Replayed and Skewed.
+func WithReplayProtection(verify func(c *Connection, mt MessageType, data []byte) (nonce string, signed time.Time, err error), window int, maxSkew time.Duration) ServerOption {
+	return func(s *Server) {
+		s.replayVerify = verify
+		s.replayWindow = window
+		s.replayMaxSkew = maxSkew
+	}
+}
+
+// The last nonces seen on a connection, only used by its read loop.
+type nonceWindow struct {
+	seen map[string]bool
+	ring []string // in arrival order, starting at next
+	next int
+}
+
+func newNonceWindow(size int) *nonceWindow {
+	return &nonceWindow{seen: make(map[string]bool, size), ring: make([]string, 0, size)}
+}
+
+// Records nonce, evicting the oldest one if the window is full. Reports false if nonce is already in the window.
+func (w *nonceWindow) add(nonce string) bool {
+	if w.seen[nonce] {
+		return false
+	}
+	if len(w.ring) < cap(w.ring) {
+		w.ring = append(w.ring, nonce)
+	} else {
+		delete(w.seen, w.ring[w.next])
+		w.ring[w.next] = nonce
+		w.next = (w.next + 1) % len(w.ring)
+	}
+	w.seen[nonce] = true
+	return true
+}
+
+// Verifies a signed message and drops replays. Returns false if the message must not be delivered.
+func (s *Server) checkReplay(c *Connection, mt MessageType, data []byte, trace string) (bool, error) {
+	nonce, signed, err := s.replayVerify(c, mt, data)
+	if err != nil {
+		return false, s.rejectMessage(c, withTrace(err, trace))
+	}
+
+	if skew := signed.Sub(s.clock.Now()); s.replayMaxSkew > 0 && (skew > s.replayMaxSkew || skew < -s.replayMaxSkew) {
+		s.metrics.skewedMessages.Add(1)
+		s.emitError(c, withTrace(&ReplayError{Nonce: nonce, Skew: skew}, trace))
+		return false, nil
+	}
+	if c.nonces != nil && !c.nonces.add(nonce) {
+		s.metrics.replayedMessages.Add(1)
+		s.emitError(c, withTrace(&ReplayError{Nonce: nonce, Duplicate: true}, trace))
+		return false, nil
+	}
+	return true, nil
+}
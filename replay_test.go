@@ -0,0 +1,92 @@
+package simplewebsockets_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+// Messages are "nonce|unix seconds|body", standing in for a real signature scheme.
+func verifySigned(c *simplewebsockets.Connection, mt simplewebsockets.MessageType, data []byte) (string, time.Time, error) {
+	parts := strings.SplitN(string(data), "|", 3)
+	if len(parts) != 3 {
+		return "", time.Time{}, errors.New("unsigned message")
+	}
+	sec, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return parts[0], time.Unix(sec, 0), nil
+}
+
+func TestReplayProtection(t *testing.T) {
+	sim := wstest.NewSimulation(2000, simplewebsockets.WithReplayProtection(verifySigned, 2, time.Minute))
+	handled := make(chan string, 16)
+	sim.Server.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) {
+		handled <- string(msg)
+	})
+	errs := make(chan error, 16)
+	sim.Server.OnError(func(c *simplewebsockets.Connection, err error) { errs <- err })
+	c, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Abort()
+
+	now := sim.Clock.Now()
+	signed := func(nonce string, at time.Time) string {
+		return fmt.Sprintf("%s|%d|move", nonce, at.Unix())
+	}
+	expectHandled := func(msg string) {
+		t.Helper()
+		c.SendText(msg)
+		select {
+		case got := <-handled:
+			if got != msg {
+				t.Fatalf("handled %q, want %q", got, msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q wasn't handled", msg)
+		}
+	}
+	expectRejected := func(msg string, duplicate bool) {
+		t.Helper()
+		c.SendText(msg)
+		var re *simplewebsockets.ReplayError
+		select {
+		case err := <-errs:
+			if !errors.Is(err, simplewebsockets.ErrReplay) || !errors.As(err, &re) || re.Duplicate != duplicate {
+				t.Fatalf("%q rejected with %v, want a *ReplayError with Duplicate %v", msg, err, duplicate)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q wasn't rejected", msg)
+		}
+	}
+
+	expectHandled(signed("n1", now))
+	expectHandled(signed("n2", now.Add(-30*time.Second)))
+	expectRejected(signed("n1", now), true)
+	expectRejected(signed("n3", now.Add(-2*time.Minute)), false)
+	expectRejected(signed("n4", now.Add(2*time.Minute)), false)
+
+	// the window only holds the last 2 nonces
+	expectHandled(signed("n5", now))
+	expectHandled(signed("n1", now))
+
+	if m := sim.Server.Metrics().Messages; m.Replayed != 1 || m.Skewed != 2 {
+		t.Errorf("metrics %+v, want 1 replayed and 2 skewed messages", m)
+	}
+	select {
+	case msg := <-handled:
+		t.Errorf("rejected message %q was handled", msg)
+	default:
+	}
+}
@@ -0,0 +1,123 @@
+package simplewebsockets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Looks up the SRV records of _service._tcp.<host> and dials their targets in order of priority, weighted
+// randomly within one, instead of the URL's host and port. The URL's host is still sent as the Host header
+// and used as the TLS server name. Every target, like a plain host, is dialed on all its A and AAAA records
+// by the dialer, see WithDialer.
+func WithDialSRV(service string) DialOption {
+	return func(d *dialConfig) {
+		d.srvService = service
+	}
+}
+
+// the resolver the dialer uses
+func (d *dialConfig) resolver() *net.Resolver {
+	if d.dialer.Resolver != nil {
+		return d.dialer.Resolver
+	}
+	return net.DefaultResolver
+}
+
+// the host:port addresses to dial for u in order: the URL's own, or the targets of its SRV records
+func (d *dialConfig) targets(ctx context.Context, u *url.URL, defaultPort string) ([]string, error) {
+	if d.srvService == "" {
+		port := u.Port()
+		if port == "" {
+			port = defaultPort
+		}
+		return []string{net.JoinHostPort(u.Hostname(), port)}, nil
+	}
+
+	_, records, err := d.resolver().LookupSRV(ctx, d.srvService, "tcp", u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, srv := range records {
+		if host := strings.TrimSuffix(srv.Target, "."); host != "" { // "." means the service isn't offered
+			targets = append(targets, net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no SRV targets for _%s._tcp.%s", d.srvService, u.Hostname())
+	}
+	return targets, nil
+}
+
+// Dials the targets in order until one connects. When ctx has a deadline every target gets an equal share
+// of the time left, so one that doesn't answer leaves time for the next.
+func dialTargets(ctx context.Context, targets []string, dial func(ctx context.Context, addr string) (net.Conn, error)) (net.Conn, error) {
+	if len(targets) == 1 {
+		return dial(ctx, targets[0])
+	}
+	var errs []error
+	for i, addr := range targets {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok {
+			attemptCtx, cancel = context.WithTimeout(ctx, time.Until(deadline)/time.Duration(len(targets)-i))
+		}
+		conn, err := dial(attemptCtx, addr)
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Resolves the addresses a dial of rawURL with opts may connect to now: every address of every target.
+func (s *Server) resolveAddrs(ctx context.Context, rawURL string, opts []DialOption) ([]netip.AddrPort, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	cfg := newDialConfig(opts)
+	network, err := s.tcpNetwork()
+	if err != nil {
+		return nil, err
+	}
+	port := "80"
+	if u.Scheme == "wss" {
+		port = "443"
+	}
+	targets, err := cfg.targets(ctx, u, port)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []netip.AddrPort
+	for _, target := range targets {
+		host, portStr, err := net.SplitHostPort(target)
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("bad port in %q: %w", target, err)
+		}
+		ips, err := cfg.resolver().LookupNetIP(ctx, "ip"+strings.TrimPrefix(network, "tcp"), host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, netip.AddrPortFrom(ip.Unmap(), uint16(port)))
+		}
+	}
+	return addrs, nil
+}
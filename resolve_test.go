@@ -0,0 +1,202 @@
+package simplewebsockets_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+// A DNS server answering A and SRV queries from its records. The resolver reaches it over net.Pipe
+// connections, on which it speaks DNS over TCP.
+type fakeDNS struct {
+	mx  sync.Mutex
+	a   map[string]netip.Addr // by fully qualified name
+	srv map[string][]*net.SRV
+}
+
+func newFakeDNS() *fakeDNS {
+	return &fakeDNS{a: make(map[string]netip.Addr), srv: make(map[string][]*net.SRV)}
+}
+
+func (d *fakeDNS) setSRV(name string, records ...*net.SRV) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.srv[name] = records
+}
+
+func (d *fakeDNS) dialer() *net.Dialer {
+	return &net.Dialer{Resolver: &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go d.serve(server)
+			return client, nil
+		},
+	}}
+}
+
+func (d *fakeDNS) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [2]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+		resp := d.answer(query)
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...)); err != nil {
+			return
+		}
+	}
+}
+
+func (d *fakeDNS) answer(query []byte) []byte {
+	// the question follows the 12 byte header: the name's labels, then its type and class
+	end := 12
+	var labels []string
+	for query[end] != 0 {
+		n := int(query[end])
+		labels = append(labels, string(query[end+1:end+1+n]))
+		end += 1 + n
+	}
+	end += 5
+	name := strings.ToLower(strings.Join(labels, ".")) + "."
+	qtype := binary.BigEndian.Uint16(query[end-4:])
+
+	var answers [][]byte
+	d.mx.Lock()
+	switch qtype {
+	case 1: // A
+		if ip, ok := d.a[name]; ok {
+			answers = append(answers, ip.AsSlice())
+		}
+	case 33: // SRV
+		for _, srv := range d.srv[name] {
+			rdata := binary.BigEndian.AppendUint16(nil, srv.Priority)
+			rdata = binary.BigEndian.AppendUint16(rdata, srv.Weight)
+			rdata = binary.BigEndian.AppendUint16(rdata, srv.Port)
+			for _, label := range strings.Split(strings.TrimSuffix(srv.Target, "."), ".") {
+				rdata = append(append(rdata, byte(len(label))), label...)
+			}
+			answers = append(answers, append(rdata, 0))
+		}
+	}
+	d.mx.Unlock()
+
+	resp := append([]byte(nil), query[:2]...)
+	resp = append(resp, 0x81, 0x80, 0, 1) // response, recursion available, no error, 1 question
+	resp = binary.BigEndian.AppendUint16(resp, uint16(len(answers)))
+	resp = append(resp, 0, 0, 0, 0)
+	resp = append(resp, query[12:end]...)
+	for _, rdata := range answers {
+		resp = append(resp, 0xc0, 12) // the question's name
+		resp = binary.BigEndian.AppendUint16(resp, qtype)
+		resp = append(resp, 0, 1, 0, 0, 0, 0) // class IN, TTL 0
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(rdata)))
+		resp = append(resp, rdata...)
+	}
+	return resp
+}
+
+func port(s *simplewebsockets.Server) uint16 {
+	return uint16(s.Addr().(*net.TCPAddr).Port)
+}
+
+func TestDialSRV(t *testing.T) {
+	sb, _, conns := startServer(t)
+	// a port nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := uint16(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+
+	dns := newFakeDNS()
+	dns.a["a.example.test."] = netip.MustParseAddr("127.0.0.1")
+	dns.a["b.example.test."] = netip.MustParseAddr("127.0.0.1")
+	client := simplewebsockets.NewServer()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dial := func() (*simplewebsockets.Connection, error) {
+		return client.Dial(ctx, "ws://example.test", simplewebsockets.WithDialer(dns.dialer()), simplewebsockets.WithDialSRV("ws"))
+	}
+
+	if _, err := dial(); err == nil {
+		t.Fatal("dialed without SRV records")
+	}
+
+	// the preferred target is down, the next one is dialed
+	dns.setSRV("_ws._tcp.example.test.",
+		&net.SRV{Target: "a.example.test.", Port: down, Priority: 0},
+		&net.SRV{Target: "b.example.test.", Port: port(sb), Priority: 1})
+	c, err := dial()
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.NetConn().Close()
+	select {
+	case <-conns:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second target didn't get the connection")
+	}
+
+	dns.setSRV("_ws._tcp.example.test.", &net.SRV{Target: "a.example.test.", Port: down})
+	if _, err := dial(); err == nil {
+		t.Fatal("dialed a target that is down")
+	}
+}
+
+func TestLinkReresolve(t *testing.T) {
+	sa, _, connsA := startServer(t)
+	sb, _, connsB := startServer(t)
+
+	dns := newFakeDNS()
+	dns.a["a.example.test."] = netip.MustParseAddr("127.0.0.1")
+	dns.a["b.example.test."] = netip.MustParseAddr("127.0.0.1")
+	dns.setSRV("_ws._tcp.example.test.", &net.SRV{Target: "a.example.test.", Port: port(sa)})
+
+	client := simplewebsockets.NewServer()
+	l := client.Link("ws://example.test",
+		simplewebsockets.WithLinkDialOptions(simplewebsockets.WithDialer(dns.dialer()), simplewebsockets.WithDialSRV("ws")),
+		simplewebsockets.WithLinkBackoff(10*time.Millisecond, 100*time.Millisecond),
+		simplewebsockets.WithLinkReresolve(20*time.Millisecond))
+	defer l.Close()
+
+	first := waitUp(t, l)
+	<-connsA
+
+	// the service moves to b, the link follows
+	dns.setSRV("_ws._tcp.example.test.", &net.SRV{Target: "b.example.test.", Port: port(sb)})
+	select {
+	case <-connsB:
+	case <-time.After(5 * time.Second):
+		t.Fatal("link didn't move to the new target")
+	}
+	if d, _ := first.Disposition(); !d.Clean || d.Code != 1001 {
+		t.Errorf("first connection ended with %+v, want a clean close with 1001", d)
+	}
+	waitUp(t, l)
+	if st := l.Stats(); st.Failovers != 1 {
+		t.Errorf("stats %+v, want 1 failover", st)
+	}
+
+	// a failed lookup keeps the connection
+	dns.setSRV("_ws._tcp.example.test.")
+	time.Sleep(100 * time.Millisecond)
+	if st := l.Stats(); !st.Up || st.Failovers != 1 || st.LastError == nil || errors.Is(st.LastError, context.Canceled) {
+		t.Errorf("stats %+v, want the link up with the lookup's error", st)
+	}
+}
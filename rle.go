@@ -0,0 +1,83 @@
+package simplewebsockets
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RSV2, set on the first frame of a text message encoded with the run-length extension
+const rsvRLE = 0x2
+
+// Name of the run-length extension in Sec-WebSocket-Extensions.
+const rleExtension = "x-simplewebsockets-rle"
+
+// 0xff never occurs in UTF-8, so it can mark runs in text without escaping
+const rleMarker = 0xff
+
+// Shorter runs are copied as is, the marker takes three bytes.
+const minRLERun = 4
+
+// Setter to be passed into the creation of a server. Negotiates the "x-simplewebsockets-rle" extension with
+// clients that offer it, for embedded clients too small for permessage-deflate. Text messages with runs of 4 or
+// more equal bytes are sent with RSV2 set and each run replaced by 0xff, the run length (1-255) and the byte.
+// Text messages from the client may use the same encoding. permessage-deflate is preferred when both are offered.
+func WithTextRLE() ServerOption {
+	return func(s *Server) {
+		s.textRLE = true
+	}
+}
+
+// reports whether the upgrade request offers the run-length extension
+func (s *Server) negotiateRLE(offers []string) bool {
+	if !s.textRLE {
+		return false
+	}
+	for _, offer := range offers {
+		if strings.TrimSpace(offer) == rleExtension { // takes no parameters
+			return true
+		}
+	}
+	return false
+}
+
+// Replaces runs of equal bytes in text with 0xff, length, byte.
+func encodeRLE(text []byte) []byte {
+	out := make([]byte, 0, len(text))
+	for i := 0; i < len(text); {
+		b := text[i]
+		run := 1
+		for i+run < len(text) && text[i+run] == b && run < 255 {
+			run++
+		}
+		if run >= minRLERun {
+			out = append(out, rleMarker, byte(run), b)
+		} else {
+			out = append(out, text[i:i+run]...)
+		}
+		i += run
+	}
+	return out
+}
+
+// Expands a run-length encoded text message, failing with ErrMessageTooLarge beyond limit bytes (0 means no limit).
+func decodeRLE(data []byte, limit int64) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] != rleMarker {
+			out = append(out, data[i])
+		} else {
+			if i+2 >= len(data) || data[i+1] == 0 {
+				return nil, errors.New("truncated run")
+			}
+			for n := data[i+1]; n > 0; n-- {
+				out = append(out, data[i+2])
+			}
+			i += 2
+		}
+		if limit > 0 && int64(len(out)) > limit {
+			return nil, fmt.Errorf("%w: expanded beyond %d bytes", ErrMessageTooLarge, limit)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,123 @@
+package simplewebsockets_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+// Upgrades a raw connection to addr, offering the run-length extension if offer is set. Returns the connection,
+// a reader positioned at the first frame and the negotiated extensions.
+func rleConn(t *testing.T, addr string, offer bool) (net.Conn, *bufio.Reader, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req := "GET / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+	if offer {
+		req += "Sec-WebSocket-Extensions: x-simplewebsockets-rle\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade: %v %v", resp, err)
+	}
+	return conn, br, resp.Header.Get("Sec-WebSocket-Extensions")
+}
+
+// Sends a masked text frame with the reserved bits rsv.
+func sendRSV(t *testing.T, conn net.Conn, rsv byte, payload []byte) {
+	t.Helper()
+	f := simplewebsockets.NewFrame(0x1, payload, true, true, [4]byte{1, 2, 3, 4})
+	f.RSV = rsv
+	if _, err := conn.Write(f.FrameToBytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readRawFrame(t *testing.T, br *bufio.Reader) (simplewebsockets.Header, []byte) {
+	t.Helper()
+	h, err := simplewebsockets.ParseHeader(br)
+	if err != nil {
+		t.Fatalf("reading a frame: %v", err)
+	}
+	payload, err := io.ReadAll(h.PayloadReader(br))
+	if err != nil {
+		t.Fatalf("reading a frame: %v", err)
+	}
+	return h, payload
+}
+
+func TestTextRLE(t *testing.T) {
+	s, _, _ := startServer(t, simplewebsockets.WithTextRLE())
+	received := make(chan string, 1)
+	s.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) {
+		received <- string(msg)
+		c.SendTextMessage(string(msg))
+	})
+	conn, br, ext := rleConn(t, s.Addr().String(), true)
+	if ext != "x-simplewebsockets-rle" {
+		t.Fatalf("extensions %q, want the run-length extension", ext)
+	}
+	encoded := []byte{'a', 0xff, 10, 'x', 'b'}
+	sendRSV(t, conn, 0x2, encoded)
+	select {
+	case msg := <-received:
+		if want := "a" + strings.Repeat("x", 10) + "b"; msg != want {
+			t.Fatalf("handler got %q, want %q", msg, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler got no message")
+	}
+	if h, payload := readRawFrame(t, br); h.RSV != 0x2 || !bytes.Equal(payload, encoded) {
+		t.Errorf("echo with RSV %x and payload % x, want RSV2 and % x", h.RSV, payload, encoded)
+	}
+
+	// short runs don't shrink, they are sent as is
+	sendRSV(t, conn, 0, []byte("aab"))
+	<-received
+	if h, payload := readRawFrame(t, br); h.RSV != 0 || string(payload) != "aab" {
+		t.Errorf("echo with RSV %x and payload %q, want it plain", h.RSV, payload)
+	}
+}
+
+func TestTextRLERejected(t *testing.T) {
+	s, _, _ := startServer(t, simplewebsockets.WithTextRLE())
+	tests := []struct {
+		name    string
+		offer   bool
+		payload []byte
+		code    uint16
+	}{
+		{"not negotiated", false, []byte{0xff, 10, 'x'}, 1002},
+		{"truncated run", true, []byte{'a', 0xff, 10}, 1007},
+		{"empty run", true, []byte{0xff, 0, 'x'}, 1007},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, br, _ := rleConn(t, s.Addr().String(), tt.offer)
+			sendRSV(t, conn, 0x2, tt.payload)
+			h, payload := readRawFrame(t, br)
+			if h.Opcode != 0x8 || len(payload) < 2 || binary.BigEndian.Uint16(payload) != tt.code {
+				t.Errorf("got opcode %x with % x, want a close with %d", h.Opcode, payload, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package simplewebsockets
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// A named group of connections within a tenant. Connections leave all their rooms when they disconnect.
+type Room struct {
+	name   string
+	tenant *Tenant
+
+	mx      sync.RWMutex
+	members map[*Connection]bool
+
+	sendMx sync.Mutex // orders broadcasts with backfill joins
+
+	priority atomic.Int32 // Priority, see SetPriority
+}
+
+// Returns the name of the room.
+func (r *Room) Name() string {
+	return r.name
+}
+
+// Returns the tenant the room belongs to.
+func (r *Room) Tenant() *Tenant {
+	return r.tenant
+}
+
+// Adds a connection to the room. Connections can only join rooms of their own tenant.
+func (r *Room) Join(c *Connection) error {
+	if err := r.authorize(c, ActionJoin); err != nil {
+		return err
+	}
+	return r.join(c)
+}
+
+// adds a connection the room authorizer already let in
+func (r *Room) join(c *Connection) error {
+	if c.tenant != r.tenant {
+		return fmt.Errorf("connection of tenant %q can't join room %q of tenant %q", c.tenant.id, r.name, r.tenant.id)
+	}
+	if !c.IsOpen() {
+		return fmt.Errorf("connection is closing or closed")
+	}
+
+	// removeConnection may be collecting the rooms to leave right now, joining has to be all or nothing
+	c.roomsMx.Lock()
+	if c.leftRooms {
+		c.roomsMx.Unlock()
+		return fmt.Errorf("connection is closing or closed")
+	}
+	c.rooms[r] = true
+	r.mx.Lock()
+	r.members[c] = true
+	r.mx.Unlock()
+	c.roomsMx.Unlock()
+
+	Publish(r.tenant.server.events, RoomJoinEvent{Conn: c, Room: r})
+	return nil
+}
+
+// Removes a connection from the room.
+func (r *Room) Leave(c *Connection) error {
+	if err := r.authorize(c, ActionLeave); err != nil {
+		return err
+	}
+	r.leave(c)
+	return nil
+}
+
+// removes a connection without asking the room authorizer
+func (r *Room) leave(c *Connection) {
+	r.mx.Lock()
+	_, member := r.members[c]
+	delete(r.members, c)
+	r.mx.Unlock()
+
+	c.roomsMx.Lock()
+	delete(c.rooms, r)
+	c.roomsMx.Unlock()
+
+	if member {
+		Publish(r.tenant.server.events, RoomLeaveEvent{Conn: c, Room: r})
+	}
+}
+
+// Returns the current members of the room.
+func (r *Room) Members() []*Connection {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	conns := make([]*Connection, 0, len(r.members))
+	for c := range r.members {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Returns current number of members in the room.
+func (r *Room) Len() int {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	return len(r.members)
+}
+
+// Sends a text message to every member of the room.
+func (r *Room) BroadcastText(msg string) error {
+	return r.Broadcast(TextMessage, []byte(msg)).Err
+}
+
+// Sends a binary message to every member of the room.
+func (r *Room) BroadcastBinary(msg []byte) error {
+	return r.Broadcast(BinaryMessage, msg).Err
+}
@@ -0,0 +1,49 @@
+package simplewebsockets
+
+// Setter to be passed into the creation of a server. Messages a connection sends to a room (Room.BroadcastFrom,
+// Room.EmitFrom) go back to the sender too if echo is true. By default the other members only get them.
+func WithSenderEcho(echo bool) ServerOption {
+	return func(s *Server) {
+		s.senderEcho = echo
+	}
+}
+
+// Setter to be passed into the creation of a server. Events sent with Room.EmitFrom carry fn's name for the
+// sender in the "from" field of the envelope, e.g. a user name, so clients can show who said what. Without
+// it events aren't attributed.
+func WithSenderAttribution(fn func(c *Connection) string) ServerOption {
+	return func(s *Server) {
+		s.senderAttribution = fn
+	}
+}
+
+// OnRoomMessage is called for every message a connection sends to a room, before the room's members get it.
+func (s *Server) OnRoomMessage(fn func(room *Room, sender *Connection, msg []byte)) {
+	s.setHandler(&s.onRoomMessage, func() func() {
+		return Subscribe(s.events, func(e RoomMessageEvent) { fn(e.Room, e.Sender, e.Data) })
+	})
+}
+
+// Sends a message on behalf of sender to the members of the room, leaving sender out unless WithSenderEcho.
+// The room authorizer (WithRoomAuthorizer) is asked first and a RoomMessageEvent is published.
+func (r *Room) BroadcastFrom(sender *Connection, mt MessageType, data []byte) BroadcastResult {
+	if err := r.authorize(sender, ActionEmit); err != nil {
+		return BroadcastResult{Err: err}
+	}
+	s := r.tenant.server
+	Publish(s.events, RoomMessageEvent{Room: r, Sender: sender, Type: mt, Data: data})
+
+	var except *Connection
+	if !s.senderEcho {
+		except = sender
+	}
+	return r.broadcast(mt, data, except)
+}
+
+// Returns the sender's name for the "from" field of an event envelope, "" without WithSenderAttribution.
+func (s *Server) attribute(sender *Connection) string {
+	if s.senderAttribution == nil {
+		return ""
+	}
+	return s.senderAttribution(sender)
+}
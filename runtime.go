@@ -0,0 +1,105 @@
+package simplewebsockets
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Infrastructure shared by several servers in one process, e.g. a gateway hosting a few websocket products:
+// the handler worker pool, compressor pools and a registry of the servers for collecting their metrics.
+type Runtime struct {
+	workers  int
+	poolOnce sync.Once
+	pool     *workerPool
+
+	mx        sync.Mutex
+	deflaters map[int]*sync.Pool // by compression level
+	servers   map[*Server]bool
+}
+
+// Creates a runtime to share between servers with WithRuntime. workers is the size of the handler pool shared
+// by all servers in DispatchSharedPool mode, 0 means one worker per CPU.
+func NewRuntime(workers int) *Runtime {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &Runtime{
+		workers:   workers,
+		deflaters: make(map[int]*sync.Pool),
+		servers:   make(map[*Server]bool),
+	}
+}
+
+// Setter to be passed into the creation of a server. The server uses rt's pools instead of its own, so
+// WithWorkerPool only selects the shared pool and its size is rt's. The server leaves rt on Shutdown.
+func WithRuntime(rt *Runtime) ServerOption {
+	return func(s *Server) {
+		s.runtime = rt
+	}
+}
+
+// Setter to be passed into the creation of a server. Names the server, e.g. for the metrics of a Runtime.
+func WithName(name string) ServerOption {
+	return func(s *Server) {
+		s.name = name
+	}
+}
+
+// Returns the name given with WithName.
+func (s *Server) Name() string {
+	return s.name
+}
+
+// Returns the servers using the runtime.
+func (rt *Runtime) Servers() []*Server {
+	rt.mx.Lock()
+	defer rt.mx.Unlock()
+	servers := make([]*Server, 0, len(rt.servers))
+	for s := range rt.servers {
+		servers = append(servers, s)
+	}
+	return servers
+}
+
+// Returns a snapshot of every server's metrics by server name. Servers sharing a name are reported under
+// one of them, so give them distinct ones with WithName.
+func (rt *Runtime) Metrics() map[string]Metrics {
+	servers := rt.Servers()
+	metrics := make(map[string]Metrics, len(servers))
+	for _, s := range servers {
+		metrics[s.name] = s.Metrics()
+	}
+	return metrics
+}
+
+func (rt *Runtime) register(s *Server) {
+	rt.mx.Lock()
+	defer rt.mx.Unlock()
+	rt.servers[s] = true
+}
+
+func (rt *Runtime) unregister(s *Server) {
+	rt.mx.Lock()
+	defer rt.mx.Unlock()
+	delete(rt.servers, s)
+}
+
+// the shared handler pool, started by the first server that needs it
+func (rt *Runtime) workerPool() *workerPool {
+	rt.poolOnce.Do(func() {
+		rt.pool = newWorkerPool(rt.workers)
+	})
+	return rt.pool
+}
+
+// the shared pool of compressors at level
+func (rt *Runtime) deflaterPool(level int) *sync.Pool {
+	rt.mx.Lock()
+	defer rt.mx.Unlock()
+	p, ok := rt.deflaters[level]
+	if !ok {
+		p = &sync.Pool{}
+		rt.deflaters[level] = p
+	}
+	return p
+}
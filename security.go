@@ -0,0 +1,85 @@
+package simplewebsockets
+
+import "time"
+
+// Setter to be passed into the creation of a server. Closes connections with 1002 when a client sends an
+// unmasked frame, as RFC 6455 requires. Off by default for compatibility with lenient clients.
+func WithMaskRequired(required bool) ServerOption {
+	return func(s *Server) {
+		s.requireMask = required
+	}
+}
+
+// Setter to be passed into the creation of a server. Closes connections with 1007 when a text message
+// isn't valid UTF-8.
+func WithUTF8Validation(enabled bool) ServerOption {
+	return func(s *Server) {
+		s.validateUTF8 = enabled
+	}
+}
+
+// Setter to be passed into the creation of a server. Limits every connection to n inbound messages per
+// second, on top of its tenant's limit; exceeding it closes the connection with 1008. 0 means no limit.
+func WithConnectionRateLimit(n int) ServerOption {
+	return func(s *Server) {
+		s.connMessageRate = n
+	}
+}
+
+// Bundle of hardening settings, see WithSecurityProfile.
+type SecurityProfile int
+
+const (
+	// Large limits, no protocol enforcement beyond parsing. For trusted clients on private networks.
+	SecurityPermissive SecurityProfile = iota
+	// Masking and UTF-8 enforced, moderate limits and timeouts. Cross-origin browsers are allowed.
+	SecurityBalanced
+	// Everything in Balanced with tight limits, and upgrades from other origins rejected (see
+	// WithCSRFProtection to trust additional origins).
+	SecurityStrict
+)
+
+// Setter to be passed into the creation of a server. Sets the origin policy, size and fragment limits,
+// per connection message rate, UTF-8 validation, masking enforcement, and handshake, frame and keepalive
+// timeouts together. Pass it first: options after it override single settings.
+func WithSecurityProfile(profile SecurityProfile) ServerOption {
+	return func(s *Server) {
+		switch profile {
+		case SecurityStrict:
+			s.checkOrigins = true
+			s.maxMessageSize = 64 * 1024
+			s.maxFrameSize = 16 * 1024
+			s.maxFragments = 8
+			s.connMessageRate = 50
+			s.validateUTF8 = true
+			s.requireMask = true
+			s.handeshakeTimeout = 5 * time.Second
+			s.frameReadTimeout = 10 * time.Second
+			s.keepaliveInterval, s.maxMissedPings = 30*time.Second, 2
+
+		case SecurityBalanced:
+			s.checkOrigins = false
+			s.maxMessageSize = 1024 * 1024
+			s.maxFrameSize = 64 * 1024
+			s.maxFragments = 64
+			s.connMessageRate = 500
+			s.validateUTF8 = true
+			s.requireMask = true
+			s.handeshakeTimeout = 10 * time.Second
+			s.frameReadTimeout = 30 * time.Second
+			s.keepaliveInterval, s.maxMissedPings = 60*time.Second, 3
+
+		default:
+			s.checkOrigins = false
+			s.maxMessageSize = 16 * 1024 * 1024
+			s.maxFrameSize = 16 * 1024 * 1024
+			s.maxFragments = 0
+			s.connMessageRate = 0
+			s.validateUTF8 = false
+			s.requireMask = false
+			s.handeshakeTimeout = 30 * time.Second
+			s.frameReadTimeout = 0
+			s.keepaliveInterval, s.maxMissedPings = 0, 0
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package simplewebsockets
+
+import "time"
+
+const (
+	// largest frame we send: the default maxFrameSize, so a peer with default limits, such as another
+	// server of this package, accepts every frame. Longer messages are fragmented.
+	streamFrameSize = 16 * 1024
+	// round trip time from which a link counts as slow, see sendPlan
+	slowLinkRTT = 100 * time.Millisecond
+)
+
+// Sends a binary message, picking buffered or streamed writing and the frame size from the message size, the
+// round trip time measured with keepalive pings (see ConnectionStats.RTT) and the depth of the send queue.
+// Use SendBinaryMessageBuffered or SendBinaryMessageStreamed to decide yourself.
+func (c *Connection) SendBinaryMessage(msg []byte) error {
+	streamed, fs := c.sendPlan(len(msg))
+	if streamed {
+		return c.SendBinaryMessageStreamed(msg, fs)
+	}
+	return c.SendBinaryMessageBuffered(msg, fs)
+}
+
+// Sends a text message like SendBinaryMessage picks the way to send a binary one.
+func (c *Connection) SendTextMessage(msg string) error {
+	streamed, fs := c.sendPlan(len(msg))
+	if streamed {
+		return c.SendTextMessageStreamed(msg, fs)
+	}
+	return c.SendTextMessageBuffered(msg, fs)
+}
+
+// Sends data as a text or binary message, see SendTextMessage and SendBinaryMessage.
+func (c *Connection) SendMessage(mt MessageType, data []byte) error {
+	if mt == TextMessage {
+		return c.SendTextMessage(string(data))
+	}
+	return c.SendBinaryMessage(data)
+}
+
+// Picks how to send a message of size bytes. Messages that fit the retained write buffer go out in one write,
+// in frames of at most streamFrameSize. On a slow link larger messages are buffered too, so the kernel gets
+// the whole message to fill the window with. A send queue a quarter full means the peer drains slowly, so
+// large messages are streamed in small frames instead of buffered whole while waiting on it.
+func (c *Connection) sendPlan(size int) (streamed bool, fs int) {
+	limit, fs := maxRetainedWriteBuf, streamFrameSize
+	if c.RTT() >= slowLinkRTT {
+		limit = 4 * maxRetainedWriteBuf
+	}
+	if q := c.queue; q != nil && q.len()*4 >= c.server.sendQueueSize {
+		limit, fs = streamFrameSize/2, streamFrameSize/2
+	}
+	if size <= limit {
+		return false, min(max(size, 1), fs)
+	}
+	return true, fs
+}
+
+// Returns the smoothed round trip time measured with keepalive pings, 0 until the first pong arrived.
+func (c *Connection) RTT() time.Duration {
+	return time.Duration(c.rtt.Load())
+}
+
+// Takes a round trip sample if the pong answers an outstanding keepalive ping.
+func (c *Connection) observePong() {
+	sent := c.pingSentAt.Swap(0)
+	if sent == 0 {
+		return
+	}
+	sample := c.server.clock.Now().UnixNano() - sent
+	prev := c.rtt.Load()
+	c.rttSpike.Store(prev != 0 && sample > 2*prev)
+	if prev != 0 {
+		sample = prev + (sample-prev)/8 // same smoothing as TCP's SRTT
+	}
+	c.rtt.Store(sample)
+}
@@ -0,0 +1,115 @@
+package simplewebsockets_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+// Messages longer than the default maxFrameSize must reach a peer with default limits, whichever way they
+// are sent.
+func TestSendLargerThanFrameLimit(t *testing.T) {
+	text := strings.Repeat("0123456789abcdef", 20*1024/16) // 20KB
+	binary := bytes.Repeat([]byte{0xa5}, 30*1024)
+	prepared, err := simplewebsockets.NewPreparedMessage(simplewebsockets.TextMessage, []byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		send func(c *simplewebsockets.Connection) error
+		want string
+	}{
+		{"text", func(c *simplewebsockets.Connection) error { return c.SendTextMessage(text) }, text},
+		{"binary", func(c *simplewebsockets.Connection) error { return c.SendBinaryMessage(binary) }, string(binary)},
+		{"json", func(c *simplewebsockets.Connection) error { return c.SendJSON(text) }, `"` + text + `"`},
+		{"prepared", func(c *simplewebsockets.Connection) error { return c.SendPrepared(prepared) }, text},
+		{"room broadcast", func(c *simplewebsockets.Connection) error {
+			room := c.Tenant().Room("large")
+			if err := room.Join(c); err != nil {
+				return err
+			}
+			return room.Broadcast(simplewebsockets.TextMessage, []byte(text)).Err
+		}, text},
+		{"writer", func(c *simplewebsockets.Connection) error {
+			w, err := c.NextWriter(simplewebsockets.TextMessage)
+			if err != nil {
+				return err
+			}
+			w.Write([]byte(text))
+			return w.Close()
+		}, text},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, url, conns := startServer(t)
+			// the client's messages come back the way the test sends them
+			s.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) {
+				if err := tt.send(c); err != nil {
+					t.Errorf("server send: %v", err)
+				}
+			})
+
+			got := make(chan string, 1)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			c, err := simplewebsockets.NewServer().Dial(ctx, url, simplewebsockets.WithConnOptions(
+				simplewebsockets.WithMessageHandler(func(msg []byte) { got <- string(msg) })))
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer c.Close(1000, "")
+			<-conns
+
+			if err := tt.send(c); err != nil {
+				t.Fatalf("client send: %v", err)
+			}
+			select {
+			case msg := <-got:
+				if msg != tt.want {
+					t.Errorf("client got %d bytes, want %d", len(msg), len(tt.want))
+				}
+			case <-c.Context().Done():
+				d, _ := c.Disposition()
+				t.Fatalf("connection ended with %+v", d)
+			case <-time.After(5 * time.Second):
+				t.Fatal("no message")
+			}
+		})
+	}
+}
+
+// Messages streamed because they don't fit the write buffer are fragmented the same way.
+func TestSendStreamedLargerThanFrameLimit(t *testing.T) {
+	const size = 200 * 1024
+	s, url, _ := startServer(t, simplewebsockets.WithMaxMessageSize(size))
+	got := make(chan []byte, 1)
+	s.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) { got <- msg })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := simplewebsockets.NewServer().Dial(ctx, url)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close(1000, "")
+
+	msg := bytes.Repeat([]byte("stream"), size/6)
+	if err := c.SendBinaryMessage(msg); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	select {
+	case m := <-got:
+		if !bytes.Equal(m, msg) {
+			t.Errorf("server got %d bytes, want %d", len(m), len(msg))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message")
+	}
+}
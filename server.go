@@ -1,15 +1,22 @@
 package simplewebsockets
 
 import (
-	"bytes"
+	"context"
+	"crypto/rand"
 	"crypto/sha1"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash"
 	"net"
+	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 // Close state enum
@@ -25,13 +32,22 @@ const (
 type Connection struct {
 	conn    net.Conn
 	writeMx sync.Mutex
+	sendMx  sync.Mutex // held for a whole data message so fragments of different messages never interleave
 
 	OnMessage func([]byte)
 	OnClose   func([]byte)
 
+	handlerMx sync.Mutex          // guards OnMessage once the connection is served, see SetOnMessage
+	unhandled []unhandledMessage  // messages buffered under UnhandledBuffer
+	flushing  bool
+	inbox     chan inboundMessage // nil until the first pull read, see ReadMessageContext
+	onTyped   func(MessageType, []byte)
+	onPing    func([]byte)
+	onPong    func([]byte)
+
 	readBuf    []byte
 	writeBuf   []byte
-	maxSize    int64
+	maxSize    atomic.Int64 // see SetReadLimit
 	maxFrameSize int64
 
 	frameBuffer []byte // Accumulates bytes until we have complete frames
@@ -40,40 +56,265 @@ type Connection struct {
 	closeState  CloseState
 	closeMx     sync.Mutex
 	closeReason []byte
+	closeOnce   sync.Once // guards the close callbacks, see notifyClosed
+	closeErr    error     // first error that made the server end the connection
+	disposition *Disposition
+	closed      chan struct{} // closed once the disposition is known
+
+	ctx    context.Context // cancelled when the connection ends
+	cancel context.CancelCauseFunc
+
+	tenant    *Tenant
+	rooms     map[*Room]bool
+	roomsMx   sync.Mutex
+	leftRooms bool // removeConnection took the rooms to leave, joining any more would leak the connection
+
+	id    uint64
+	usage usageCounters
+
+	checksum      ChecksumAlgorithm
+	msgOpcode     byte // opcode of the message currently being reassembled
+	msgFragments  int  // data frames received for the current message
+	msgInProgress bool // a fragmented message was started and awaits its final frame
+
+	server       *Server
+	closeStarted time.Time // when we sent our close frame
+
+	timings readLoopTimings
+
+	handlerQueue     chan func()   // only used with DispatchPerConnection
+	handlerQueueDone chan struct{} // closed when the connection's handler queue stops
+
+	mode atomic.Int32
+
+	frameTimer    Timer // running while a partial frame is buffered
+	frameTimedOut atomic.Bool
+
+	uploadMx      sync.Mutex
+	pendingUpload *Upload
+	activeUpload  *Upload      // only touched by the read loop
+	streams       *streamInbox // messages waiting for NextReader
+
+	drained   chan struct{} // closed once the connection is going away, see drainReads
+	drainOnce sync.Once
+
+	queue *sendQueue // nil unless WithSendQueue
+
+	heardFrom    atomic.Bool  // a frame arrived since the last keepalive tick
+	missedPings  atomic.Int32
+	pingSentAt   atomic.Int64 // unix nanos of the keepalive ping awaiting its pong, 0 if none
+	rtt          atomic.Int64 // smoothed round trip time in nanoseconds
+	rttSpike     atomic.Bool  // the last sample was over twice the smoothed round trip time
+	pingInterval atomic.Int64 // current keepalive interval in nanoseconds, see WithAdaptiveKeepalive
+
+	nonces *nonceWindow // nil unless WithReplayProtection
+
+	client  bool          // we dialed the connection and must mask our frames
+	maskKey func() [4]byte // generates their mask keys if set, see WithMaskKeyGenerator
+
+	msgLimiter *rateLimiter // nil unless WithConnectionRateLimit
+
+	fingerprint *ClientFingerprint
+
+	deflate       deflateMode // permessage-deflate was negotiated, with or without the preset dictionary
+	msgCompressed bool        // the message being reassembled is compressed
+	rle           bool        // the run-length extension was negotiated
+	msgRLE        bool        // the message being reassembled is run-length encoded
+
+	subprotocol     string
+	envelopeVersion int // see WithEnvelopeVersions
+	compression     compressionCounters
+	request         *http.Request // the upgrade request, nil for dialed connections
+
+	appReadDeadline  atomic.Int64 // unix nanoseconds of the deadlines set through NetConn, 0 for none
+	appWriteDeadline atomic.Int64
+
+	activeAt    atomic.Int64 // unix nanoseconds of the last message in either direction
+	trimBuffers atomic.Bool  // drop the read buffers once empty, see WithMemoryWatermark
+	admitted    atomic.Bool  // holds one of the server's connection slots, see WithMaxConnections
+	clientKey   string       // counted under this client for WithMaxConnectionsPerIP, guarded by the server's perIPMx
 }
 
 // Represents a websockets server and manages its attributes and events.
 type Server struct {
-	connections   map[*Connection]bool
-	connectionsMx sync.RWMutex
+	connections    map[*Connection]bool
+	connectionsMx  sync.RWMutex
+	maxConnections int
+	admitted       atomic.Int64 // connections holding a slot under maxConnections
+
+	maxConnectionsPerIP int
+	connectionKey       func(*http.Request) string
+	perIP               map[string]int // connections by client key, see WithConnectionKey
+	perIPMx             sync.Mutex
 
 	maxMessageSize int64
 	maxFrameSize   int64
+	maxFragments   int
 
 	handeshakeTimeout time.Duration
 	readTimeout       time.Duration
 	writeTimeout      time.Duration
 
-	onConnect    func(*Connection)
-	onDisconnect func(*Connection)
-	onError      func(*Connection, error)
+	events   *EventBus
+	setterMx sync.Mutex
+
+	// subscriptions made by the OnX setters
+	onConnect     func()
+	onDisconnect  func()
+	onError       func()
+	onRoomMessage func()
+
+	onMessageCtx func(context.Context, *Connection, []byte)
+	onUpgrading  func(*http.Request) (bool, int, http.Header)
+
+	tracing        bool
+	traceExtractor func(MessageType, []byte) string
+
+	tenants        map[string]*Tenant
+	tenantsMx      sync.Mutex
+	tenantResolver func(*http.Request) string
+	tenantLimits   TenantLimits
+
+	nextConnID    atomic.Uint64
+	usageInterval time.Duration
+	onUsage       func(UsageReport)
+	usageOnce     sync.Once
+
+	checksum ChecksumAlgorithm
+
+	metrics *serverMetrics
+
+	diagSampleEvery int
+
+	closeCodeMapper func(error) (uint16, string)
+	closeReasons    func(*Connection, uint16, string) string
+
+	connMode ConnectionMode
+
+	clock Clock
+
+	handshakeDiagnostics bool
+
+	tlsConfig *tls.Config
+
+	compression      bool
+	compressionLevel int
+	deflaters        *sync.Pool // *flate.Writer at compressionLevel, shared through the runtime
+
+	compressionDict   []byte
+	compressionDictID string     // names compressionDict in the extension negotiation
+	dictDeflaters     *sync.Pool // *flate.Writer with compressionDict
+
+	textRLE bool
+
+	subprotocols        []string
+	subprotocolSelector func(*http.Request, []string) string
+	envelopeVersions    []int // newest first
+
+	csrfCookie     string
+	checkOrigins   bool
+	trustedOrigins []string
+	originCheck    func(string) bool
+
+	roomAuthorizer func(*Connection, string, Action) error
+
+	fingerprinter func(*http.Request, *ClientFingerprint) error
+
+	requireMask     bool
+	validateUTF8    bool
+	connMessageRate int
+
+	frameReadTimeout time.Duration
+
+	unhandledPolicy UnhandledPolicy
+
+	network    string // see WithNetwork
+	listener   net.Listener
+	listenerMx sync.Mutex
+	ready      chan struct{} // closed by Bind
+
+	shuttingDown atomic.Bool
+	done         chan struct{} // closed by Shutdown, stops the server's background goroutines
+	handedOff    atomic.Bool   // the listener went to another process, see HandOff
+
+	shedInterval time.Duration
+	shedProbes   []LoadProbe
+	shedLevel    atomic.Int32
+
+	memWatermark uint64
+	memInterval  time.Duration
+	memEvictIdle time.Duration
+
+	sendQueueSize int
+
+	validator        func(MessageType, []byte) error
+	invalidCloseCode uint16
+
+	replayVerify  func(*Connection, MessageType, []byte) (string, time.Time, error)
+	replayWindow  int
+	replayMaxSkew time.Duration
+
+	largeMessageThreshold int64
+	onLargeMessage        func(*Connection, Direction, int64)
+
+	broadcastWorkers      int
+	broadcastWriteTimeout time.Duration
+
+	zeroCopyReads bool
+
+	keepaliveInterval time.Duration
+	maxMissedPings    int
+	pongTimeout       time.Duration
+	minPingInterval   time.Duration
+	maxPingInterval   time.Duration // 0 unless WithAdaptiveKeepalive
+
+	messageStore MessageStore
+
+	dispatchMode DispatchMode
+	workers      int
+	orderingKey  func([]byte) string
+	pool         *workerPool
+
+	name    string
+	runtime *Runtime // nil unless WithRuntime
+
+	codec Codec
+	cors  CORSConfig
+
+	senderEcho        bool
+	senderAttribution func(*Connection) string
 }
 
 type ServerOption func(*Server)
 
 // OnConnect is called when a client first connects to the server succesfully (after the http handshake).
 func (s *Server) OnConnect(fn func(*Connection)) {
-	s.onConnect = fn
+	s.setHandler(&s.onConnect, func() func() {
+		return Subscribe(s.events, func(e ConnectEvent) { fn(e.Conn) })
+	})
 }
 
-// OnDisconnect is called after a clean disconnect (no error has occured and the server and client have completed a closing handshake).
+// OnDisconnect is called exactly once when a connection ends, after the closing handshake or when the connection is lost.
+// Connection.Disposition tells how it ended.
 func (s *Server) OnDisconnect(fn func(*Connection)) {
-	s.onDisconnect = fn
+	s.setHandler(&s.onDisconnect, func() func() {
+		return Subscribe(s.events, func(e DisconnectEvent) { fn(e.Conn) })
+	})
+}
+
+// OnMessageCtx sets a message handler for every connection that receives the connection's context, which is
+// cancelled when the connection ends and carries the message's trace ID (see WithTraceIDs). It handles the messages
+// of connections without an OnMessage, so an OnConnect handler can still replace it for individual connections. Call it before the server starts accepting connections.
+func (s *Server) OnMessageCtx(fn func(ctx context.Context, c *Connection, msg []byte)) {
+	s.onMessageCtx = fn
 }
 
-// OnError is called on an unclean disconnect
+// OnError is called for errors a connection survives, and for accept and handshake failures. The error that ends
+// a connection is reported once by its Disposition instead (see OnDisconnect).
 func (s *Server) OnError(fn func(*Connection, error)) {
-	s.onError = fn
+	s.setHandler(&s.onError, func() func() {
+		return Subscribe(s.events, func(e ErrorEvent) { fn(e.Conn, e.Err) })
+	})
 }
 
 // Creates a new server with options. Default values are maxMessageSize = 32 kb, maxFrameSize = 16kb, readTimeout = 120 seconds, writeTimeout = 10 seconds.
@@ -81,6 +322,14 @@ func (s *Server) OnError(fn func(*Connection, error)) {
 func NewServer(options ...ServerOption) *Server {
 	s := &Server{
 		connections:       make(map[*Connection]bool),
+		perIP:             make(map[string]int),
+		tenants:           make(map[string]*Tenant),
+		metrics:           newServerMetrics(),
+		events:            newEventBus(),
+		clock:             realClock{},
+		ready:             make(chan struct{}),
+		codec:             JSONCodec,
+		done:              make(chan struct{}),
 		maxMessageSize:    32 * 1024, // 32 kb
 		maxFrameSize:      16 * 1024, // 16 kb
 		handeshakeTimeout: 30 * time.Second,
@@ -92,6 +341,17 @@ func NewServer(options ...ServerOption) *Server {
 		option(s)
 	}
 
+	s.deflaters = &sync.Pool{}
+	s.dictDeflaters = &sync.Pool{}
+	if s.runtime != nil {
+		s.deflaters = s.runtime.deflaterPool(s.compressionLevel)
+		s.runtime.register(s)
+	}
+
+	s.startDispatch()
+	s.startShedding()
+	s.startMemoryMonitor()
+
 	return s
 }
 
@@ -109,6 +369,14 @@ func WithMaxFrameSize(size int64) ServerOption {
     }
 }
 
+// Setter to be passed into the creation of a server. Messages split into more than n frames are rejected
+// with 1009, even if they stay under the size limits. 0 means no limit.
+func WithMaxFragments(n int) ServerOption {
+	return func(s *Server) {
+		s.maxFragments = n
+	}
+}
+
 // Setter to be passed into the creation of a server.
 func WithReadTimeout(seconds uint16) ServerOption {
 	return func(s *Server) {
@@ -158,8 +426,8 @@ func frameSize(data []byte, maxFrameSize int64) int {
 
 	totalFrameSize := int64(headerSize) + payloadLen
 
-	// validate against maxFrameSize
-	if totalFrameSize > maxFrameSize {
+	// validate the payload against maxFrameSize, a 64-bit length with the top bit set is negative here
+	if payloadLen < 0 || payloadLen > maxFrameSize {
 		return -2 // frame too large
 	}
 
@@ -168,25 +436,90 @@ func frameSize(data []byte, maxFrameSize int64) int {
 
 // handles frames based on opcode
 func (s *Server) processFrame(c *Connection, fr *Frame, msg *[]byte) error {
+	c.heardFrom.Store(true)
+
+	if s.requireMask && !c.client && !fr.Mask {
+		return c.closeWithError(ErrUnmaskedFrame, 1002, "Unmasked frame")
+	}
+
+	// reserved bits are only used by negotiated extensions
+	if fr.RSV != 0 && !c.extensionRSV(fr) {
+		return c.closeWithError(ErrReservedBits, 1002, "Reserved bits set")
+	}
+
+	// control frames can't be fragmented and carry at most 125 bytes, even in between fragments of a message
+	if fr.Opcode >= 0x8 && (!fr.FIN || len(fr.Payload) > 125) {
+		return c.closeWithError(ErrInvalidControlFrame, 1002, "Invalid control frame")
+	}
+
+	// limit fragments per message so tiny continuation frames can't burn cpu
+	if fr.Opcode <= 0x2 {
+		c.msgFragments++
+		if s.maxFragments > 0 && c.msgFragments > s.maxFragments {
+			s.metrics.tooManyFragments.Add(1)
+			return c.closeWithError(ErrTooManyFragments, 1009, "Too many fragments")
+		}
+		if fr.FIN {
+			c.msgFragments = 0
+		}
+	}
+
 	switch fr.Opcode {
 	case 0x0: // continue
-		if len(*msg) == 0 {
-			c.Close(1002, "Unexpected continuation frame")
-			return fmt.Errorf("continuation frame without initial frame")
+		if c.activeUpload != nil {
+			return c.writeUploadFrame(fr)
+		}
+		// a started message may still be empty, its first fragments can have no payload
+		if !c.msgInProgress {
+			return c.closeWithError(ErrUnexpectedContinuation, 1002, "Unexpected continuation frame")
 		}
+		if c.exceedsMaxSize(len(*msg) + len(fr.Payload)) {
+			return c.closeWithError(ErrMessageTooLarge, 1009, "Message too large")
+		}
+		c.msgInProgress = !fr.FIN
 		*msg = append(*msg, fr.Payload...)
 
 	case 0x1: // text frame
-		if len(*msg) > 0 {
-			c.Close(1002, "Unexpected text frame")
-			return fmt.Errorf("text frame while message in progress")
+		if c.msgInProgress || c.activeUpload != nil {
+			return c.closeWithError(fmt.Errorf("%w: text frame", ErrMessageInProgress), 1002, "Unexpected text frame")
+		}
+		if c.rateLimited() {
+			return nil
+		}
+		c.msgOpcode = fr.Opcode
+		c.msgCompressed = fr.RSV == rsvCompressed
+		c.msgRLE = fr.RSV == rsvRLE
+		if !c.msgCompressed && !c.msgRLE && c.startUpload(TextMessage) {
+			return c.writeUploadFrame(fr)
+		}
+		if c.exceedsMaxSize(len(fr.Payload)) {
+			return c.closeWithError(ErrMessageTooLarge, 1009, "Message too large")
+		}
+		c.msgInProgress = !fr.FIN
+		if fr.FIN && s.zeroCopyReads {
+			break // delivered straight from the read buffer below
 		}
 		*msg = append(*msg, fr.Payload...)
 
 	case 0x2: // binary frame
-		if len(*msg) > 0 {
-			c.Close(1002, "Unexpected binary frame")
-			return fmt.Errorf("binary frame while message in progress")
+		if c.msgInProgress || c.activeUpload != nil {
+			return c.closeWithError(fmt.Errorf("%w: binary frame", ErrMessageInProgress), 1002, "Unexpected binary frame")
+		}
+		if c.rateLimited() {
+			return nil
+		}
+		c.msgCompressed = fr.RSV == rsvCompressed
+		c.msgRLE = false
+		if !c.msgCompressed && c.startUpload(BinaryMessage) {
+			return c.writeUploadFrame(fr)
+		}
+		if c.exceedsMaxSize(len(fr.Payload)) {
+			return c.closeWithError(ErrMessageTooLarge, 1009, "Message too large")
+		}
+		c.msgOpcode = fr.Opcode
+		c.msgInProgress = !fr.FIN
+		if fr.FIN && s.zeroCopyReads {
+			break // delivered straight from the read buffer below
 		}
 		*msg = append(*msg, fr.Payload...)
 
@@ -195,44 +528,147 @@ func (s *Server) processFrame(c *Connection, fr *Frame, msg *[]byte) error {
 
 	case 0x9: // ping
 		c.SendPong(fr.Payload)
+		if fn := c.controlHandler(&c.onPing); fn != nil {
+			fn(fr.Payload)
+		}
 
 	case 0xA: // pong
-		// Handle pong if needed
+		c.observePong()
+		if fn := c.controlHandler(&c.onPong); fn != nil {
+			fn(fr.Payload)
+		}
 
 	default:
-		c.Close(1002, "Unknown opcode")
-		return fmt.Errorf("unknown opcode: %d", fr.Opcode)
+		return c.closeWithError(fmt.Errorf("%w: %d", ErrUnknownOpcode, fr.Opcode), 1002, "Unknown opcode")
 	}
 
 	// is message complete
 	if fr.FIN && (fr.Opcode == 0x1 || fr.Opcode == 0x2 || fr.Opcode == 0x0) {
-		if c.OnMessage != nil {
-			c.OnMessage(*msg)
+		data := *msg
+		if fr.Opcode != 0x0 && s.zeroCopyReads {
+			data = fr.Payload // unfragmented, aliases the read buffer
+		}
+		if c.msgCompressed {
+			inflated, err := inflateMessage(data, c.inflateDict(), c.maxSize.Load())
+			if errors.Is(err, ErrMessageTooLarge) {
+				return c.closeWithError(err, 1009, "Message too large")
+			}
+			if err != nil {
+				return c.closeWithError(err, 1007, "Invalid compressed data")
+			}
+			c.countCompressed(Inbound, len(inflated), len(data))
+			data = inflated
+		}
+		if c.msgRLE {
+			decoded, err := decodeRLE(data, c.maxSize.Load())
+			if errors.Is(err, ErrMessageTooLarge) {
+				return c.closeWithError(err, 1009, "Message too large")
+			}
+			if err != nil {
+				return c.closeWithError(err, 1007, "Invalid run-length data")
+			}
+			c.countCompressed(Inbound, len(decoded), len(data))
+			data = decoded
 		}
+
+		err := s.deliverMessage(c, c.msgOpcode, data)
 		*msg = (*msg)[:0] // reset message buffer
+		return err
 	}
 
 	return nil
 }
 
+// Enforces the tenant and connection message rate limits when a data message starts, before any of it can be
+// streamed to NextReader or an upload. Closes the connection and reports true if the message is over them.
+func (c *Connection) rateLimited() bool {
+	if c.tenant.allowMessage() && c.msgLimiter.allow(1) {
+		return false
+	}
+	c.closeWithError(ErrMessageRateLimited, 1008, "Message rate limit exceeded")
+	return true
+}
+
+// reports whether a message of n bytes is over the connection's maximum message size
+func (c *Connection) exceedsMaxSize(n int) bool {
+	limit := c.maxSize.Load()
+	return limit > 0 && int64(n) > limit
+}
+
+// Hands a complete reassembled message to the application.
+func (s *Server) deliverMessage(c *Connection, opcode byte, data []byte) error {
+	c.countMessageIn(int64(len(data)))
+
+	// write-only connections discard inbound data
+	if c.Mode() == ModeWriteOnly {
+		return nil
+	}
+
+	// verify and strip integrity checksum
+	if opcode == 0x2 {
+		body, err := c.checksum.open(data)
+		if err != nil {
+			s.emitError(c, err)
+			return nil
+		}
+		data = body
+	}
+
+	trace := s.traceID(MessageType(opcode), data)
+
+	if opcode == 0x1 && s.validateUTF8 && !utf8.Valid(data) {
+		return c.closeWithError(ErrInvalidUTF8, 1007, "Invalid UTF-8")
+	}
+
+	if s.validator != nil {
+		if err := s.validator(MessageType(opcode), data); err != nil {
+			return s.rejectMessage(c, withTrace(err, trace))
+		}
+	}
+
+	if s.replayVerify != nil {
+		if ok, err := s.checkReplay(c, MessageType(opcode), data, trace); !ok {
+			return err
+		}
+	}
+
+	if hasSubscribers[MessageEvent](s.events) {
+		Publish(s.events, MessageEvent{Conn: c, Type: MessageType(opcode), Data: data, TraceID: trace})
+	}
+
+	// a message that was compressed or inspected as a whole goes to NextReader in one piece
+	if m := c.streams.begin(MessageType(opcode)); m != nil {
+		m.Write(data)
+		m.finish(nil)
+		return nil
+	}
+
+	return s.handleMessage(c, MessageType(opcode), data, trace)
+}
+
 // Handle close frame processing
 func (s *Server) handleCloseFrame(c *Connection, fr *Frame) error {
 	c.closeMx.Lock()
 	currentState := c.closeState
 	c.closeReason = fr.Payload
+	s.metrics.closeCode(fr.Payload)
 
 	if currentState == StateOpen {
 		// client initiated close
 		c.closeState = StateClosed
 		c.closeMx.Unlock()
 
-		responseFrame, err := NewCloseFrame([2]byte{}, "")
-		if err != nil {
-			return err
-		}
-
-		// echo back status code if we have it
-		if len(fr.Payload) >= 2 {
+		// echo back status code if we have it (without the reason), or answer an invalid close frame with an error
+		responseFrame := NewEmptyCloseFrame()
+		if status, err := validateClosePayload(fr.Payload); err != nil {
+			c.setCloseError(err)
+			status, reason := s.mapCloseError(err, status, "Invalid close frame")
+			reason = c.wireCloseReason(status, reason)
+			responseFrame, err = NewCloseFrame([2]byte{byte(status >> 8), byte(status)}, reason)
+			if err != nil {
+				return err
+			}
+		} else if len(fr.Payload) >= 2 {
 			var statusBytes [2]byte
 			copy(statusBytes[:], fr.Payload[:2])
 			responseFrame, err = NewCloseFrame(statusBytes, "")
@@ -242,43 +678,27 @@ func (s *Server) handleCloseFrame(c *Connection, fr *Frame) error {
 		}
 
 		c.writeMx.Lock()
-		c.conn.Write(responseFrame.FrameToBytes())
+		c.writeEncoded(responseFrame)
 		c.writeMx.Unlock()
+		s.metrics.cleanClose(time.Time{}, s.clock.Now())
 
-		// call onClose
-		if c.OnClose != nil {
-			c.OnClose(fr.Payload)
-		}
-
-		// call onDisconnect for clean close
-		if s.onDisconnect != nil {
-			s.onDisconnect(c)
-		}
+		s.notifyClosed(c, fr.Payload, true)
 
 	} else if currentState == StateClosing {
 		// server initiated close and client responded -> clean close
 		c.closeState = StateClosed
+		started := c.closeStarted
 		c.closeMx.Unlock()
+		s.metrics.cleanClose(started, s.clock.Now())
 
-		// call onClose callback
-		if c.OnClose != nil {
-			c.OnClose(fr.Payload)
-		}
-
-		// call onDisconnect for clean close
-		if s.onDisconnect != nil {
-			s.onDisconnect(c)
-		}
+		s.notifyClosed(c, fr.Payload, true)
 	} else {
 		// already closed
 		c.closeMx.Unlock()
 	}
 
 	// remove from connections and close TCP
-	s.connectionsMx.Lock()
-	delete(s.connections, c)
-	s.connectionsMx.Unlock()
-	c.conn.Close()
+	s.removeConnection(c)
 	return fmt.Errorf("connection closed") // Signal to stop processing
 }
 
@@ -289,24 +709,38 @@ func (s *Server) handleConnection(c *Connection) {
 
 	for {
 		n, err := c.conn.Read(c.readBuf)
+		if err != nil && c.frameTimedOut.Load() {
+			c.closeWithError(ErrFrameReadTimeout, 1008, "Frame read timeout")
+			s.removeConnection(c)
+			return
+		}
 		if err != nil {
 			c.closeMx.Lock()
-			if c.closeState == StateOpen && s.onError != nil {
-				s.onError(c, err)
+			if c.closeState == StateOpen && c.closeErr == nil {
+				c.closeErr = err
+			}
+			if c.closeState != StateClosed {
+				s.metrics.abortiveClose()
 			}
 			c.closeState = StateClosed
 			c.closeMx.Unlock()
 
-			s.connectionsMx.Lock()
-			delete(s.connections, c)
-			s.connectionsMx.Unlock()
-			c.conn.Close()
+			s.removeConnection(c)
 			return
 		}
 
+		// enforce tenant bandwidth limit
+		if !c.tenant.allowBytes(n) {
+			c.closeWithError(ErrBandwidthLimited, 1008, "Bandwidth limit exceeded")
+			s.removeConnection(c)
+			return
+		}
+
+		c.countBytesIn(n)
 		c.frameBuffer = append(c.frameBuffer, c.readBuf[:n]...)
 
 		// process all complete frames in buffer
+		completed := false
 		for {
 			if len(c.frameBuffer) == 0 {
 				break // no more data left to process
@@ -321,7 +755,7 @@ func (s *Server) handleConnection(c *Connection) {
 
 			if completeFrameSize == -2 {
 				// frame too large
-				c.Close(1009, "Frame too large")
+				c.closeWithError(ErrFrameTooLarge, 1009, "Frame too large")
 				s.removeConnection(c)
 				return
 			}
@@ -334,12 +768,17 @@ func (s *Server) handleConnection(c *Connection) {
 			frameData := c.frameBuffer[:completeFrameSize]
 
 			// get frame from bytes
-			fr, err := BytesToFrame(frameData)
+			sampled := c.sampleFrame()
+			var parseStart time.Time
+			if sampled {
+				parseStart = time.Now()
+			}
+			fr, err := parseFrame(frameData, !s.zeroCopyReads)
+			if sampled {
+				c.addSample(&c.timings.parseTime, parseStart)
+			}
 			if err != nil {
-				if s.onError != nil {
-					s.onError(c, err)
-				}
-				c.Close(1002, "Protocol error")
+				c.closeWithError(err, 1002, "Protocol error")
 				s.removeConnection(c)
 				return
 			}
@@ -348,22 +787,29 @@ func (s *Server) handleConnection(c *Connection) {
 			c.frameBuffer = c.frameBuffer[completeFrameSize:]
 
 			// process frame
+			completed = true
 			if err := s.processFrame(c, fr, &msg); err != nil {
 				// error handled in processFrame
 				return
 			}
 		}
+
+		// partially received frames have a deadline to complete
+		c.watchPartialFrame(completed)
+		c.trimReadBuffers(&msg)
 	}
 }
 
-// Server-initiated close of a connection
+// Server-initiated close of a connection. If reason is empty and status is a registered application
+// close code (see RegisterCloseCode), its name is sent as reason.
 func (c *Connection) Close(status uint16, reason string) error {
-	c.closeMx.Lock()
-	defer c.closeMx.Unlock()
-
-	if c.closeState != StateOpen {
-		return fmt.Errorf("connection already closing or closed")
+	if !IsValidCloseCode(status) {
+		return fmt.Errorf("close code %d can't be sent in a close frame", status)
+	}
+	if reason == "" && IsApplicationCloseCode(status) {
+		reason = registeredCloseReason(status)
 	}
+	reason = c.wireCloseReason(status, reason)
 
 	// convert status to [2]byte
 	statusBytes := [2]byte{byte(status >> 8), byte(status & 0xFF)}
@@ -371,53 +817,129 @@ func (c *Connection) Close(status uint16, reason string) error {
 	if err != nil {
 		return err
 	}
+	return c.startClose(closeFrame)
+}
+
+// Starts the closing handshake like Close, with a close frame that carries no status code. The peer sees
+// 1005, no status received.
+func (c *Connection) CloseWithoutStatus() error {
+	return c.startClose(NewEmptyCloseFrame())
+}
+
+// sends closeFrame and waits for the peer's answer until the close timeout
+func (c *Connection) startClose(closeFrame Frame) error {
+	c.closeMx.Lock()
+	defer c.closeMx.Unlock()
+
+	if c.closeState != StateOpen {
+		return fmt.Errorf("connection already closing or closed")
+	}
 
 	c.closeState = StateClosing
+	c.closeStarted = c.server.clock.Now()
+	c.drainReads() // the peer's close frame may be queued behind messages nobody reads anymore
 
 	c.writeMx.Lock()
-	_, err = c.conn.Write(closeFrame.FrameToBytes())
+	_, err := c.writeEncoded(closeFrame)
 	c.writeMx.Unlock()
 
 	if err != nil {
 		c.closeState = StateClosed
+		if c.closeErr == nil {
+			c.closeErr = err
+		}
+		c.server.metrics.abortiveClose()
 		c.conn.Close()
 		return err
 	}
 
 	// close timeout (5 seconds)
-	go func() {
-		time.Sleep(5 * time.Second)
+	c.server.clock.AfterFunc(5*time.Second, func() {
 		c.closeMx.Lock()
 		if c.closeState == StateClosing {
 			c.closeState = StateClosed
+			c.server.metrics.abortiveClose()
 			c.conn.Close()
 		}
 		c.closeMx.Unlock()
-	}()
+	})
 
 	return nil
 }
 
-// removes a connection from the server connections map and closes it
+// removes a connection from the server connections map, its tenant and rooms, and closes it
 func (s *Server) removeConnection(c *Connection) {
+    // no-op if the closing handshake already reported the close
+    s.notifyClosed(c, nil, false)
+
     s.connectionsMx.Lock()
     delete(s.connections, c)
     s.connectionsMx.Unlock()
+
+    s.detachTenant(c)
+    s.release(c)
+
+    // messages held for a handler that never came would otherwise be replayed into a stopped connection
+    c.handlerMx.Lock()
+    c.unhandled = nil
+    c.handlerMx.Unlock()
+
+    c.roomsMx.Lock()
+    c.leftRooms = true
+    rooms := make([]*Room, 0, len(c.rooms))
+    for r := range c.rooms {
+        rooms = append(rooms, r)
+    }
+    c.roomsMx.Unlock()
+    for _, r := range rooms {
+        r.leave(c)
+    }
+
     c.conn.Close()
 }
 
-func (s *Server) performServerHandshake(c net.Conn, key []byte) error {
+// Runs OnClose and publishes the DisconnectEvent exactly once per connection, whichever of the closing
+// handshake, a close timeout or a read error ends it first. payload is the peer's close frame payload,
+// nil if none arrived.
+func (s *Server) notifyClosed(c *Connection, payload []byte, clean bool) {
+	c.closeOnce.Do(func() {
+		d := Disposition{Clean: clean, Code: 1006}
+		if clean {
+			d.Code, d.Reason = 1005, ""
+			if len(payload) >= 2 {
+				d.Code, d.Reason = binary.BigEndian.Uint16(payload), string(payload[2:])
+			}
+		}
+
+		c.closeMx.Lock()
+		d.Err = c.closeErr
+		c.disposition = &d
+		c.closeMx.Unlock()
+		c.cancel(fmt.Errorf("%w with code %d %q", ErrConnectionClosed, d.Code, d.Reason))
+		close(c.closed)
+
+		if c.OnClose != nil {
+			c.OnClose(payload)
+		}
+		Publish(s.events, DisconnectEvent{Conn: c, Disposition: d})
+	})
+}
+
+func (s *Server) performServerHandshake(c net.Conn, key []byte, extensions string, subprotocol string, header http.Header) error {
 	status := "HTTP/1.1 101 Switching Protocols"
 	upgrade := "websocket"
 	connection := "Upgrade"
-	var guid = []byte("258EAFA5-E914-47DA-95CA-C5AB0DC85B11")
+	wsAccept := acceptKey(key)
 
-	bytes := append(key, guid...)
-	hasher := sha1.New()
-	hasher.Write(bytes)
-	wsAccept := base64.StdEncoding.EncodeToString(hasher.Sum(nil))
-
-	req := fmt.Sprintf("%s\r\nUpgrade: %s\r\nConnection: %s\r\nSec-WebSocket-Accept: %s\r\n\r\n", status, upgrade, connection, wsAccept)
+	req := fmt.Sprintf("%s\r\nUpgrade: %s\r\nConnection: %s\r\nSec-WebSocket-Accept: %s\r\n", status, upgrade, connection, wsAccept)
+	if extensions != "" {
+		req += "Sec-WebSocket-Extensions: " + extensions + "\r\n"
+	}
+	if subprotocol != "" {
+		req += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	req += headerLines(header)
+	req += "\r\n"
 
 	_, err := c.Write([]byte(req))
 	if err != nil {
@@ -427,90 +949,308 @@ func (s *Server) performServerHandshake(c net.Conn, key []byte) error {
 	return nil
 }
 
-func getWebSocketKey(data []byte) (string, error) {
-	lines := strings.SplitSeq(string(data), "\r\n")
+// Computes the Sec-WebSocket-Accept value for a Sec-WebSocket-Key.
+func acceptKey(key []byte) string {
+	var guid = []byte("258EAFA5-E914-47DA-95CA-C5AB0DC85B11")
 
-	for line := range lines {
-		if strings.HasPrefix(strings.ToLower(line), "sec-websocket-key:") {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				return strings.TrimSpace(parts[1]), nil
-			}
-		}
-	}
+	hasher := sha1.New()
+	hasher.Write(key)
+	hasher.Write(guid)
+	return base64.StdEncoding.EncodeToString(hasher.Sum(nil))
+}
+
+// Responds to an upgrade request with an http error status and closes the tcp connection.
+func rejectHandshake(c net.Conn, status int) {
+	rejectHandshakeWithHeader(c, status, nil)
+}
+
+// Like rejectHandshake, adding header to the response.
+func rejectHandshakeWithHeader(c net.Conn, status int, header http.Header) {
+	resp := fmt.Sprintf("HTTP/1.1 %d %s\r\nConnection: close\r\nContent-Length: 0\r\n%s\r\n", status, http.StatusText(status), headerLines(header))
+	c.Write([]byte(resp))
+	c.Close()
+}
 
-	return "", fmt.Errorf("Sec-WebSocket-Key header not found")
+// formats header as response header lines
+func headerLines(header http.Header) string {
+	var b strings.Builder
+	header.Write(&b)
+	return b.String()
 }
 
-// Starts listening for a server, and accepts incoming connections.
+// Starts listening for a server, and accepts incoming connections. Same as Bind followed by Serve.
 func (s *Server) Listen(address string) error {
-	ln, err := net.Listen("tcp", address)
+	if err := s.Bind(address); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Binds the server to address without accepting connections yet, so callers know the listener is
+// ready before clients connect. Follow with Serve.
+func (s *Server) Bind(address string) error {
+	return s.bind(address, s.tlsConfig)
+}
+
+// binds the listener, serving TLS if config is set
+func (s *Server) bind(address string, config *tls.Config) error {
+	network, err := s.tcpNetwork()
 	if err != nil {
 		return err
 	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return s.attach(ln, config)
+}
 
-	fmt.Printf("Listening on %s \n", address)
+// makes ln the server's listener, serving TLS if config is set
+func (s *Server) attach(ln net.Listener, config *tls.Config) error {
+	if config != nil {
+		ln = newTLSListener(ln, config)
+	}
+
+	s.listenerMx.Lock()
+	defer s.listenerMx.Unlock()
+	if s.listener != nil {
+		ln.Close()
+		return errors.New("server is already bound")
+	}
+	s.listener = ln
+	close(s.ready)
+
+	fmt.Printf("Listening on %s \n", ln.Addr())
+	return nil
+}
+
+// Returns the address the server is bound to, nil before Bind. With a ":0" address this reports
+// the port the system picked.
+func (s *Server) Addr() net.Addr {
+	s.listenerMx.Lock()
+	defer s.listenerMx.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Returns a channel that is closed once the server is bound and about to accept connections.
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Accepts connections on the listener created by Bind.
+func (s *Server) Serve() error {
+	s.listenerMx.Lock()
+	ln := s.listener
+	s.listenerMx.Unlock()
+	if ln == nil {
+		return errors.New("server is not bound, call Bind first")
+	}
+
+	if s.onUsage != nil && s.usageInterval > 0 {
+		s.usageOnce.Do(func() { spawn("usage reporter", s.reportUsage) })
+	}
 
 	// begin connection loop
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			if s.onError != nil {
-				s.onError(nil, err)
+			if s.shuttingDown.Load() || s.handedOff.Load() {
+				return ErrServerClosed
 			}
+			s.emitError(nil, err)
 			continue
 		}
 
-		conn.SetDeadline(time.Now().Add(s.handeshakeTimeout))
-		hsBuf := make([]byte, 1024)
-		len, err := conn.Read(hsBuf)
+		spawn("connection", func() {
+			if err := s.ServeConn(conn); err != nil {
+				s.emitError(nil, err)
+			}
+		})
+	}
+}
 
-		if err != nil {
+// Runs the websocket handshake and then the framing on a connection obtained elsewhere (a TLS library,
+// a QUIC stream adapter, net.Pipe, ...). Blocks until the websocket connection ends, and only returns an
+// error if the handshake failed. The conn is closed when ServeConn returns.
+func (s *Server) ServeConn(conn net.Conn) error {
+	handshakeTimer := expireAfter(s.clock, conn, s.handeshakeTimeout)
+	httpReq, raw, err := readHandshakeRequest(conn)
+	if err != nil {
+		rejectHandshake(conn, http.StatusBadRequest)
+		return err
+	}
+	if err := s.checkAccepting(); err != nil {
+		rejectHandshake(conn, http.StatusServiceUnavailable)
+		return err
+	}
+
+	// look for proxies that mangled the upgrade
+	if s.handshakeDiagnostics {
+		if err := detectInterference(httpReq); err != nil {
+			rejectHandshake(conn, http.StatusBadRequest)
 			return err
 		}
+	}
 
-		req := hsBuf[:len]
+	key, err := validateUpgradeRequest(httpReq, raw)
+	if err != nil {
+		status, header := upgradeRejection(err)
+		rejectHandshakeWithHeader(conn, status, header)
+		return err
+	}
 
-		if !strings.HasPrefix(string(req), "GET ") {
-			return fmt.Errorf("client did not send handshake (not a GET http request)")
-		}
+	if err := s.checkRequestOrigin(httpReq); err != nil {
+		rejectHandshake(conn, http.StatusForbidden)
+		return err
+	}
 
-		key, err := getWebSocketKey(req)
-		if err != nil {
+	var fp *ClientFingerprint
+	if s.fingerprinter != nil {
+		if fp, err = s.checkFingerprint(conn, httpReq, raw); err != nil {
+			rejectHandshake(conn, http.StatusForbidden)
 			return err
 		}
+	}
 
-		if err := s.performServerHandshake(conn, []byte(key)); err != nil {
-			conn.Close()
-			if s.onError != nil {
-				s.onError(nil, err)
-			}
-		}
+	upgradeHeader, status, err := s.checkUpgrading(httpReq)
+	if err != nil {
+		rejectHandshakeWithHeader(conn, status, upgradeHeader)
+		return err
+	}
 
-		conn.SetDeadline(time.Time{})
+	// attribute the connection to a tenant before accepting it
+	tenantID := ""
+	if s.tenantResolver != nil {
+		tenantID = s.tenantResolver(httpReq)
+	}
 
-		c := &Connection{
-			conn:         conn,
-			maxSize:      s.maxMessageSize,
-			maxFrameSize: s.maxFrameSize,
-			readBuf:      make([]byte, 1024),
-			writeBuf:     make([]byte, 1024),
-			closeState:   StateOpen, // initialize closed state
-		}
+	c := s.newConnection(conn)
+	c.request = httpReq
+	c.fingerprint = fp
+	extensions := s.negotiateExtensions(c, httpReq)
+	if err := s.negotiateSubprotocol(c, httpReq); err != nil {
+		rejectHandshake(conn, http.StatusBadRequest)
+		return err
+	}
+
+	if err := s.admit(c); err != nil {
+		rejectUnadmitted(conn, err)
+		return err
+	}
+	if !s.attachTenant(c, tenantID) {
+		s.release(c)
+		rejectHandshake(conn, http.StatusServiceUnavailable)
+		return fmt.Errorf("tenant %q is at its connection limit", tenantID)
+	}
 
-		s.connectionsMx.Lock()
-		s.connections[c] = true
-		s.connectionsMx.Unlock()
+	if err := s.performServerHandshake(conn, []byte(key), extensions, c.subprotocol, upgradeHeader); err != nil {
+		s.detachTenant(c)
+		s.release(c)
+		conn.Close()
+		return err
+	}
 
-		if s.onConnect != nil {
-			s.onConnect(c)
-		}
+	handshakeTimer.Stop()
+	conn.SetDeadline(time.Time{})
+
+	s.serve(c)
+	return nil
+}
+
+// Bytes a connection reads from the network at once.
+const readBufferSize = 1024
+
+// Creates the server side state for a connection that is about to complete its handshake.
+func (s *Server) newConnection(conn net.Conn) *Connection {
+	c := &Connection{
+		conn:         conn,
+		maxFrameSize: s.maxFrameSize,
+		readBuf:      make([]byte, readBufferSize),
+		writeBuf:     make([]byte, 1024),
+		closeState:   StateOpen, // initialize closed state
+		rooms:        make(map[*Room]bool),
+		id:           s.nextConnID.Add(1),
+		checksum:     s.checksum,
+		server:       s,
+		closed:       make(chan struct{}),
+		streams:      newStreamInbox(),
+		drained:      make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancelCause(context.Background())
+	c.mode.Store(int32(s.connMode))
+	c.maxSize.Store(s.maxMessageSize)
+	c.markActive()
+	if s.sendQueueSize > 0 {
+		c.queue = newSendQueue(s.sendQueueSize)
+	}
+	if s.connMessageRate > 0 {
+		c.msgLimiter = newRateLimiter(float64(s.connMessageRate), float64(s.connMessageRate), s.clock)
+	}
+	if s.replayVerify != nil && s.replayWindow > 0 {
+		c.nonces = newNonceWindow(s.replayWindow)
+	}
+	return c
+}
+
+// Registers an upgraded connection and runs its read loop until it ends.
+func (s *Server) serve(c *Connection) {
+	s.connectionsMx.Lock()
+	s.connections[c] = true
+	missed := s.shuttingDown.Load() // Shutdown may have collected the connections to close before this one
+	s.connectionsMx.Unlock()
+
+	Publish(s.events, ConnectEvent{Conn: c})
+
+	stopHandlers := c.startHandlerQueue()
+	defer stopHandlers()
+	stopQueue := c.startSendQueue()
+	defer stopQueue()
+	stopKeepalive := c.startKeepalive()
+	defer stopKeepalive()
+
+	if missed {
+		c.Close(1001, "Server shutting down")
+	}
 
-		fmt.Println("Handling new connection")
-		go s.handleConnection(c)
+	fmt.Println("Handling new connection")
+	s.handleConnection(c)
+	s.removeConnection(c)
+	c.abortUploads()
+}
+
+// Sends a close frame and waits until the peer answers it, the close timeout drops the connection or ctx is done.
+// Reports whether the closing handshake completed. If the connection is already closing it just waits. When ctx
+// ends first the close carries on in the background.
+func (c *Connection) CloseAndWait(ctx context.Context, status uint16, reason string) (bool, error) {
+	if err := c.Close(status, reason); err != nil && c.IsOpen() {
+		return false, err
+	}
+
+	select {
+	case <-c.closed:
+		d, _ := c.Disposition()
+		return d.Clean, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
 	}
 }
 
+// Returns a context that is cancelled when the connection ends, for work tied to the connection's lifetime
+// (watchers, tickers, ...) to derive from. context.Cause reports how the connection ended as an error matching
+// ErrConnectionClosed. For connections upgraded from an http.Request it carries the request context's values,
+// e.g. those set by authentication middleware.
+func (c *Connection) Context() context.Context {
+	return c.ctx
+}
+
+// bases the connection's context on parent's values, before the connection is served
+func (c *Connection) inheritContext(parent context.Context) {
+	c.ctx, c.cancel = context.WithCancelCause(context.WithoutCancel(parent))
+}
+
 // Helper function to check if the connection is open
 func (c *Connection) IsOpen() bool {
 	c.closeMx.Lock()
@@ -533,7 +1273,7 @@ func (c *Connection) SendPing(body []byte) error {
 	if err != nil {
 		return err
 	}
-	_, err = c.conn.Write(f.FrameToBytes())
+	_, err = c.writeEncoded(f)
 	return err
 }
 
@@ -545,62 +1285,217 @@ func (c *Connection) SendPong(body []byte) error {
 	if err != nil {
 		return err
 	}
-	_, err = c.conn.Write(f.FrameToBytes())
+	_, err = c.writeEncoded(f)
 	return err
 }
 
-// Does a buffered write to the connection with frames.
-func (c *Connection) bufferedWrite(frames []Frame) error {
-	var buf bytes.Buffer
+// Writes a complete message in one write, in frames of at most streamFrameSize.
+func (c *Connection) writeMessage(opcode byte, payload []byte) error {
+	if err := c.checkWritable(); err != nil {
+		return err
+	}
+	if opcode == 0x2 {
+		payload = c.checksum.seal(payload)
+	}
+	frames := c.messageFrames(opcode, payload, streamFrameSize)
+	c.sendMx.Lock()
+	defer c.sendMx.Unlock()
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+	return c.bufferedWrite(frames, int64(len(payload)))
+}
+
+// Returns the tenant the connection was attributed to during the upgrade.
+func (c *Connection) Tenant() *Tenant {
+	return c.tenant
+}
+
+// Returns the upgrade request the connection was opened with, for its path, query parameters, headers and
+// cookies. Its body is empty. For WebTransport sessions this is the CONNECT request, for connections opened
+// with Dial it is nil.
+func (c *Connection) Request() *http.Request {
+	return c.request
+}
+
+// Writes raw bytes to the tcp connection and counts them. Callers must hold writeMx.
+func (c *Connection) write(b []byte) (int, error) {
+	n, err := c.conn.Write(b)
+	c.countBytesOut(n)
+	return n, err
+}
+
+// Encodes a frame into the connection's scratch buffer and writes it. Callers must hold writeMx.
+func (c *Connection) writeEncoded(f Frame) (int, error) {
+	buf := c.appendFrame(c.writeBuf[:0], f)
+	n, err := c.write(buf)
+	c.keepWriteBuf(buf)
+	return n, err
+}
+
+// Encodes f into buf. Frames we send as the client are masked with a fresh key, as RFC 6455 requires.
+func (c *Connection) appendFrame(buf []byte, f Frame) []byte {
+	if c.client {
+		f.Mask = true
+		if c.maskKey != nil {
+			f.MaskKey = c.maskKey()
+		} else {
+			rand.Read(f.MaskKey[:])
+		}
+	}
+	return f.AppendTo(buf)
+}
+
+// Largest scratch buffer kept between writes, so one big message doesn't pin its size for the connection's lifetime.
+const maxRetainedWriteBuf = 64 * 1024
+
+func (c *Connection) keepWriteBuf(buf []byte) {
+	if cap(buf) <= maxRetainedWriteBuf {
+		c.writeBuf = buf[:0]
+	}
+}
+
+// Does a buffered write to the connection with the frames of a message of size payload bytes.
+func (c *Connection) bufferedWrite(frames []Frame, size int64) error {
+	buf := c.writeBuf[:0]
 	for _, frame := range frames {
-		buf.Write(frame.FrameToBytes())
+		buf = c.appendFrame(buf, frame)
+	}
+	_, err := c.write(buf)
+	c.keepWriteBuf(buf)
+	if err == nil {
+		c.countMessageOut(size)
 	}
-	_, err := c.conn.Write(buf.Bytes())
 	return err
 }
 
-// Does a streamed write to the connection with frames.
-func (c *Connection) streamedWrite(frames []Frame) error {
+// Does a streamed write to the connection with the frames of a message of size payload bytes.
+func (c *Connection) streamedWrite(frames []Frame, size int64) error {
 	for _, frame := range frames {
-		if _, err := c.conn.Write(frame.FrameToBytes()); err != nil {
+		if _, err := c.writeEncoded(frame); err != nil {
 			return err
 		}
 	}
+	c.countMessageOut(size)
 	return nil
 }
 
 // Sends a binary message with the specified frame size. All frames of the message are first written to a buffer,
 // then sent in a single TCP write to the connection. Also see "SendBinaryMessageStreamed"
 func (c *Connection) SendBinaryMessageBuffered(msg []byte, fs int) error {
-	frames := msgToFrames(msg, fs)
+	if err := c.checkWritable(); err != nil {
+		return err
+	}
+	payload := c.checksum.seal(msg)
+	frames := c.messageFrames(0x2, payload, fs)
+	c.sendMx.Lock()
+	defer c.sendMx.Unlock()
 	c.writeMx.Lock()
 	defer c.writeMx.Unlock()
-	return c.bufferedWrite(frames)
+	return c.bufferedWrite(frames, int64(len(payload)))
+}
+
+// Sends a batch of frames in a single write after checking they form complete messages (see Frames.Validate),
+// so hand built fragments can't leave the peer with a half finished message. Binary messages get the
+// checksum envelope in their final frame if it is enabled.
+func (c *Connection) SendFrames(frames []Frame) error {
+	if err := (Frames{MsgFrames: frames}).Validate(); err != nil {
+		return err
+	}
+
+	var (
+		sizes   []int64 // payload bytes of each message, for the counters
+		size    int64
+		sum     hash.Hash32
+		encoded = make([]Frame, 0, len(frames))
+	)
+	for _, f := range frames {
+		if f.Opcode >= 0x8 {
+			encoded = append(encoded, f)
+			continue
+		}
+		if f.Opcode != 0x0 {
+			if err := c.checkWritable(); err != nil {
+				return err
+			}
+			size, sum = 0, nil
+			if f.Opcode == 0x2 {
+				sum = c.checksum.newHash()
+			}
+		}
+		if sum != nil {
+			sum.Write(f.Payload)
+			if f.FIN {
+				// the checksum covers the whole message and goes at its very end
+				f.Payload = binary.BigEndian.AppendUint32(append([]byte(nil), f.Payload...), sum.Sum32())
+				f.PayloadLength = int64(len(f.Payload))
+			}
+		}
+		size += int64(len(f.Payload))
+		if f.FIN {
+			sizes = append(sizes, size)
+		}
+		encoded = append(encoded, f)
+	}
+
+	c.sendMx.Lock()
+	defer c.sendMx.Unlock()
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+
+	buf := c.writeBuf[:0]
+	for _, f := range encoded {
+		buf = c.appendFrame(buf, f)
+	}
+	_, err := c.write(buf)
+	c.keepWriteBuf(buf)
+	if err != nil {
+		return err
+	}
+	for _, n := range sizes {
+		c.countMessageOut(n)
+	}
+	return nil
 }
 
 // Sends a binary message with the specified frame size. Each frame is sent as a seperate write to the connection.
 // Typically better for very large messages where we don't want to buffer the whole message first. Also see "SendBinaryMessageBuffered"
 func (c *Connection) SendBinaryMessageStreamed(msg []byte, fs int) error {
-	frames := msgToFrames(msg, fs)
+	if err := c.checkWritable(); err != nil {
+		return err
+	}
+	payload := c.checksum.seal(msg)
+	frames := c.messageFrames(0x2, payload, fs)
+	c.sendMx.Lock()
+	defer c.sendMx.Unlock()
 	c.writeMx.Lock()
 	defer c.writeMx.Unlock()
-	return c.streamedWrite(frames)
+	return c.streamedWrite(frames, int64(len(payload)))
 }
 
 // Sends a text message with the specified frame size. All frames of the message are first written to a buffer,
 // then sent in a single TCP write to the connection. Also see "SendTextMessageStreamed"
 func (c *Connection) SendTextMessageBuffered(msg string, fs int) error {
-	frames := msgToFrames(msg, fs)
+	if err := c.checkWritable(); err != nil {
+		return err
+	}
+	frames := c.messageFrames(0x1, []byte(msg), fs)
+	c.sendMx.Lock()
+	defer c.sendMx.Unlock()
 	c.writeMx.Lock()
 	defer c.writeMx.Unlock()
-	return c.bufferedWrite(frames)
+	return c.bufferedWrite(frames, int64(len(msg)))
 }
 
 // Sends a text message with the specified frame size. Each frame is sent as a seperate write to the connection.
 // Typically better for very large messages where we don't want to buffer the whole message first. Also see "SendBinaryMessageBuffered"
 func (c *Connection) SendTextMessageStreamed(msg string, fs int) error {
-	frames := msgToFrames(msg, fs)
+	if err := c.checkWritable(); err != nil {
+		return err
+	}
+	frames := c.messageFrames(0x1, []byte(msg), fs)
+	c.sendMx.Lock()
+	defer c.sendMx.Unlock()
 	c.writeMx.Lock()
 	defer c.writeMx.Unlock()
-	return c.streamedWrite(frames)
+	return c.streamedWrite(frames, int64(len(msg)))
 }
\ No newline at end of file
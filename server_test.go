@@ -0,0 +1,204 @@
+package simplewebsockets_test
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+// Connects a simulated client to a fresh server that echoes every message back as text.
+func echoClient(t *testing.T, opts ...simplewebsockets.ServerOption) (*wstest.Simulation, *wstest.SimClient) {
+	t.Helper()
+	sim := wstest.NewSimulation(1, opts...)
+	sim.Server.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) {
+		c.SendTextMessage(string(msg))
+	})
+	c, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(c.Abort)
+	return sim, c
+}
+
+// What the server answered with.
+type reply struct {
+	echoed    bool
+	echo      string
+	pongs     []string
+	closeCode uint16 // 0 unless the server closed, 1005 for a close frame without status
+}
+
+// Reads frames until the server completed a message or sent a close frame.
+func nextReply(t *testing.T, c *wstest.SimClient) reply {
+	t.Helper()
+	// a server that never replies fails the test instead of hanging it
+	timer := time.AfterFunc(5*time.Second, c.Abort)
+	defer timer.Stop()
+	var r reply
+	for {
+		f, err := c.NextFrame()
+		if err != nil {
+			t.Fatalf("reading the server's reply: %v", err)
+		}
+		switch f.Opcode {
+		case 0x8:
+			r.closeCode = 1005
+			if len(f.Payload) >= 2 {
+				r.closeCode = binary.BigEndian.Uint16(f.Payload)
+			}
+			return r
+		case 0xA:
+			r.pongs = append(r.pongs, string(f.Payload))
+		case 0x0, 0x1, 0x2:
+			r.echo += string(f.Payload)
+			if f.FIN {
+				r.echoed = true
+				return r
+			}
+		}
+	}
+}
+
+type frame struct {
+	opcode  byte
+	payload string
+	fin     bool
+}
+
+func TestFragmentation(t *testing.T) {
+	tests := []struct {
+		name      string
+		frames    []frame
+		echo      string // the message the server must deliver, if it doesn't close
+		pongs     []string
+		closeCode uint16
+	}{
+		{
+			name:   "unfragmented",
+			frames: []frame{{0x1, "hello", true}},
+			echo:   "hello",
+		},
+		{
+			name:   "fragmented text",
+			frames: []frame{{0x1, "ab", false}, {0x0, "cd", false}, {0x0, "ef", true}},
+			echo:   "abcdef",
+		},
+		{
+			name:   "fragmented binary",
+			frames: []frame{{0x2, "ab", false}, {0x0, "cd", true}},
+			echo:   "abcd",
+		},
+		{
+			name:   "empty first fragment",
+			frames: []frame{{0x1, "", false}, {0x0, "abc", true}},
+			echo:   "abc",
+		},
+		{
+			name:   "only empty fragments",
+			frames: []frame{{0x1, "", false}, {0x0, "", false}, {0x0, "", true}},
+			echo:   "",
+		},
+		{
+			name:   "message exactly at the size limit",
+			frames: []frame{{0x1, "1234", false}, {0x0, "5678", true}},
+			echo:   "12345678",
+		},
+		{
+			name:      "size limit hit mid message",
+			frames:    []frame{{0x1, "12345", false}, {0x0, "67890", true}},
+			closeCode: 1009,
+		},
+		{
+			name:      "size limit hit by the first fragment",
+			frames:    []frame{{0x1, "123456789", false}},
+			closeCode: 1009,
+		},
+		{
+			name:   "ping between fragments",
+			frames: []frame{{0x1, "ab", false}, {0x9, "p", true}, {0x0, "cd", true}},
+			echo:   "abcd",
+			pongs:  []string{"p"},
+		},
+		{
+			name:   "pings between several fragments",
+			frames: []frame{{0x2, "a", false}, {0x9, "1", true}, {0x0, "b", false}, {0x9, "2", true}, {0x0, "c", true}},
+			echo:   "abc",
+			pongs:  []string{"1", "2"},
+		},
+		{
+			name:   "unsolicited pong between fragments",
+			frames: []frame{{0x1, "ab", false}, {0xA, "x", true}, {0x0, "cd", true}},
+			echo:   "abcd",
+		},
+		{
+			name:      "fragmented ping between fragments",
+			frames:    []frame{{0x1, "ab", false}, {0x9, "p", false}},
+			closeCode: 1002,
+		},
+		{
+			name:      "oversized ping between fragments",
+			frames:    []frame{{0x1, "ab", false}, {0x9, strings.Repeat("p", 126), true}},
+			closeCode: 1002,
+		},
+		{
+			name:      "continuation with no start",
+			frames:    []frame{{0x0, "x", true}},
+			closeCode: 1002,
+		},
+		{
+			name:      "continuation after a complete message",
+			frames:    []frame{{0x1, "a", true}, {0x0, "b", true}},
+			echo:      "a",
+			closeCode: 1002,
+		},
+		{
+			name:      "text frame inside a fragmented message",
+			frames:    []frame{{0x1, "a", false}, {0x1, "b", true}},
+			closeCode: 1002,
+		},
+		{
+			name:      "binary frame inside a fragmented message",
+			frames:    []frame{{0x2, "a", false}, {0x2, "b", true}},
+			closeCode: 1002,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, c := echoClient(t, simplewebsockets.WithMaxMessageSize(8))
+			for _, f := range tt.frames {
+				if err := c.SendFrame(f.opcode, []byte(f.payload), f.fin); err != nil {
+					t.Fatalf("send: %v", err)
+				}
+			}
+
+			r := nextReply(t, c)
+			if r.echoed {
+				if r.echo != tt.echo {
+					t.Errorf("server received %q, want %q", r.echo, tt.echo)
+				}
+				if !slices.Equal(r.pongs, tt.pongs) {
+					t.Errorf("pongs %q, want %q", r.pongs, tt.pongs)
+				}
+				if tt.closeCode == 0 {
+					return
+				}
+				r = nextReply(t, c)
+			}
+			if r.closeCode != tt.closeCode {
+				if tt.closeCode == 0 {
+					t.Fatalf("server closed with %d, want the message %q", r.closeCode, tt.echo)
+				}
+				t.Fatalf("server answered %+v, want close %d", r, tt.closeCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package simplewebsockets
+
+import (
+	"errors"
+	"runtime"
+	"time"
+)
+
+// Returned for work turned away while the server sheds load, see WithLoadShedding.
+var ErrLoadShed = errors.New("shedding load")
+
+// How much the server currently sheds. Each level includes the ones below it.
+type ShedLevel int
+
+const (
+	ShedNone        ShedLevel = iota
+	ShedBulk                  // messages and rooms with PriorityBulk are dropped
+	ShedLowPriority           // PriorityLow is dropped as well
+	ShedUpgrades              // new upgrades are rejected with a 503 as well
+)
+
+// Priority of a room's broadcasts (Room.SetPriority) or a queued message (MessagePriority), deciding
+// what is dropped first under load.
+type Priority int
+
+const (
+	PriorityNormal Priority = iota // never shed
+	PriorityLow                    // shed from ShedLowPriority on
+	PriorityBulk                   // shed from ShedBulk on
+)
+
+// A source of load information. The probe's pressure is Read() / Threshold, so a pressure of 1 means the
+// threshold is reached.
+type LoadProbe struct {
+	Name      string
+	Read      func() float64
+	Threshold float64
+}
+
+// Probe reporting heap memory in use against budget bytes.
+func MemoryProbe(budget uint64) LoadProbe {
+	return LoadProbe{
+		Name: "memory",
+		Read: func() float64 {
+			var m runtime.MemStats
+			runtime.ReadMemStats(&m)
+			return float64(m.HeapAlloc)
+		},
+		Threshold: float64(budget),
+	}
+}
+
+// Probe reporting the messages waiting in all send queues of s against threshold (see WithSendQueue).
+func SendQueueProbe(s *Server, threshold int) LoadProbe {
+	return LoadProbe{
+		Name: "send_queue",
+		Read: func() float64 {
+			s.connectionsMx.RLock()
+			defer s.connectionsMx.RUnlock()
+			total := 0
+			for c := range s.connections {
+				if c.queue != nil {
+					total += c.queue.len()
+				}
+			}
+			return float64(total)
+		},
+		Threshold: float64(threshold),
+	}
+}
+
+// Setter to be passed into the creation of a server. Reads the probes every interval and sheds load based on
+// the highest pressure among them: from 1 on bulk traffic is dropped, from 1.25 low priority traffic too, and
+// from 1.5 new upgrades are rejected. Changes of the level are published as ShedEvent.
+func WithLoadShedding(interval time.Duration, probes ...LoadProbe) ServerOption {
+	return func(s *Server) {
+		s.shedInterval = interval
+		s.shedProbes = probes
+	}
+}
+
+// Returns the current shed level.
+func (s *Server) ShedLevel() ShedLevel {
+	return ShedLevel(s.shedLevel.Load())
+}
+
+// Reports whether work of priority p is dropped at the current shed level.
+func (s *Server) shouldShed(p Priority) bool {
+	level := s.ShedLevel()
+	switch p {
+	case PriorityBulk:
+		return level >= ShedBulk
+	case PriorityLow:
+		return level >= ShedLowPriority
+	}
+	return false
+}
+
+// Sets the priority of the room's broadcasts, see WithLoadShedding.
+func (r *Room) SetPriority(p Priority) {
+	r.priority.Store(int32(p))
+}
+
+// Returns the priority of the room's broadcasts.
+func (r *Room) Priority() Priority {
+	return Priority(r.priority.Load())
+}
+
+// Drops the message instead of queueing it while the server sheds load of priority p, see WithLoadShedding.
+func MessagePriority(p Priority) SendOption {
+	return func(m *queuedMessage) {
+		m.priority = p
+	}
+}
+
+// Starts reading the load probes if load shedding is configured.
+func (s *Server) startShedding() {
+	if len(s.shedProbes) == 0 || s.shedInterval <= 0 {
+		return
+	}
+	spawn("load shedding", func() {
+		ticker := s.clock.NewTicker(s.shedInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				s.updateShedLevel()
+			case <-s.done:
+				return
+			}
+		}
+	})
+}
+
+// reads the probes and publishes a ShedEvent if the level changed
+func (s *Server) updateShedLevel() {
+	var (
+		pressure float64
+		probe    string
+	)
+	for _, p := range s.shedProbes {
+		if p.Threshold <= 0 {
+			continue
+		}
+		if v := p.Read() / p.Threshold; v > pressure {
+			pressure, probe = v, p.Name
+		}
+	}
+
+	level := ShedNone
+	switch {
+	case pressure >= 1.5:
+		level = ShedUpgrades
+	case pressure >= 1.25:
+		level = ShedLowPriority
+	case pressure >= 1:
+		level = ShedBulk
+	}
+
+	previous := ShedLevel(s.shedLevel.Swap(int32(level)))
+	if previous != level {
+		Publish(s.events, ShedEvent{Level: level, Previous: previous, Probe: probe, Pressure: pressure})
+	}
+}
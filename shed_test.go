@@ -0,0 +1,104 @@
+package simplewebsockets_test
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+func TestLoadShedding(t *testing.T) {
+	const interval = time.Second
+	var load atomic.Uint64 // float64 bits
+	probe := simplewebsockets.LoadProbe{
+		Name:      "test",
+		Read:      func() float64 { return math.Float64frombits(load.Load()) },
+		Threshold: 100,
+	}
+	sim := wstest.NewSimulation(2005, simplewebsockets.WithSendQueue(16), simplewebsockets.WithLoadShedding(interval, probe))
+	events := make(chan simplewebsockets.ShedEvent, 4)
+	simplewebsockets.Subscribe(sim.Server.Events(), func(e simplewebsockets.ShedEvent) { events <- e })
+	conns := make(chan *simplewebsockets.Connection, 2)
+	sim.Server.OnConnect(func(c *simplewebsockets.Connection) { conns <- c })
+	c, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Abort()
+	sc := <-conns
+
+	normal, bulk := sim.Server.Room("orders"), sim.Server.Room("telemetry")
+	bulk.SetPriority(simplewebsockets.PriorityBulk)
+	for _, r := range []*simplewebsockets.Room{normal, bulk} {
+		if err := r.Join(sc); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+	}
+
+	// sets the probe's reading and advances the clock until the level changed, the ticker may start late
+	shedAt := func(value float64, want simplewebsockets.ShedLevel) {
+		t.Helper()
+		load.Store(math.Float64bits(value))
+		for step := 0; ; step++ {
+			sim.Clock.Advance(interval)
+			select {
+			case e := <-events:
+				if e.Level != want || e.Probe != "test" || e.Pressure != value/100 {
+					t.Fatalf("shed event %+v, want level %v from the test probe at %v", e, want, value/100)
+				}
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+			if step == 100 {
+				t.Fatalf("shed level %v, want %v", sim.Server.ShedLevel(), want)
+			}
+		}
+	}
+	shed := func(res simplewebsockets.BroadcastResult, err error) bool {
+		return errors.Is(res.Err, simplewebsockets.ErrLoadShed) || errors.Is(err, simplewebsockets.ErrLoadShed)
+	}
+	broadcast := func(r *simplewebsockets.Room) bool {
+		return shed(r.Broadcast(simplewebsockets.TextMessage, []byte("x")), nil)
+	}
+	enqueue := func(p simplewebsockets.Priority) bool {
+		return shed(simplewebsockets.BroadcastResult{}, sc.Enqueue(simplewebsockets.TextMessage, []byte("x"), simplewebsockets.MessagePriority(p)))
+	}
+
+	shedAt(110, simplewebsockets.ShedBulk)
+	if !broadcast(bulk) || broadcast(normal) {
+		t.Error("at ShedBulk, want only bulk rooms shed")
+	}
+	if !enqueue(simplewebsockets.PriorityBulk) || enqueue(simplewebsockets.PriorityLow) {
+		t.Error("at ShedBulk, want only bulk messages shed")
+	}
+
+	shedAt(130, simplewebsockets.ShedLowPriority)
+	if !enqueue(simplewebsockets.PriorityLow) || enqueue(simplewebsockets.PriorityNormal) {
+		t.Error("at ShedLowPriority, want low priority messages shed but not normal ones")
+	}
+
+	shedAt(160, simplewebsockets.ShedUpgrades)
+	if _, err := sim.Connect(rand.New(rand.NewSource(sim.Seed + 1))); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("connecting at ShedUpgrades: %v, want a 503", err)
+	}
+
+	shedAt(50, simplewebsockets.ShedNone)
+	if broadcast(bulk) || enqueue(simplewebsockets.PriorityBulk) {
+		t.Error("work still shed once the load dropped")
+	}
+	if c, err := sim.Connect(rand.New(rand.NewSource(sim.Seed + 2))); err != nil {
+		t.Errorf("connecting once the load dropped: %v", err)
+	} else {
+		c.Abort()
+	}
+
+	if m := sim.Server.Metrics().Shed; m != (simplewebsockets.ShedMetrics{Upgrades: 1, Broadcasts: 1, Messages: 2}) {
+		t.Errorf("shed metrics %+v, want 1 upgrade, 1 broadcast and 2 messages", m)
+	}
+}
@@ -0,0 +1,82 @@
+package simplewebsockets
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Returned by Serve after Shutdown, and by Shutdown if called twice.
+var ErrServerClosed = errors.New("server closed")
+
+// How long Run gives connections to finish their closing handshakes once its context ended.
+const runShutdownTimeout = 10 * time.Second
+
+// Gracefully stops the server: stops accepting connections, sends a 1001 (going away) close frame to every open
+// connection and waits until all closing handshakes finished or timed out. If ctx ends first, the remaining
+// connections are dropped and ctx's error is returned. Upgrades arriving meanwhile are rejected with a 503.
+// The server's background goroutines stop too, handlers still queued on its worker pool are dropped.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !s.shuttingDown.CompareAndSwap(false, true) {
+		return ErrServerClosed
+	}
+	close(s.done)
+	if s.runtime != nil {
+		s.runtime.unregister(s)
+	} else if s.pool != nil {
+		defer s.pool.stop() // once the connections are gone
+	}
+
+	s.listenerMx.Lock()
+	ln := s.listener
+	s.listenerMx.Unlock()
+	if ln != nil {
+		ln.Close()
+	}
+
+	s.connectionsMx.RLock()
+	conns := make([]*Connection, 0, len(s.connections))
+	for c := range s.connections {
+		conns = append(conns, c)
+	}
+	s.connectionsMx.RUnlock()
+
+	for _, c := range conns {
+		c.Close(1001, "Server shutting down")
+	}
+
+	for i, c := range conns {
+		select {
+		case <-c.closed:
+		case <-ctx.Done():
+			for _, c := range conns[i:] {
+				c.conn.Close()
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Listens on address and serves until ctx ends, then shuts down gracefully and returns once that finished, so
+// the server fits into errgroup or run group based mains. Connections get 10 seconds to close before they are
+// dropped. Returns nil after a shutdown caused by ctx, the error otherwise.
+func (s *Server) Run(ctx context.Context, address string) error {
+	if err := s.Bind(address); err != nil {
+		return err
+	}
+	served := make(chan error, 1)
+	spawn("accept loop", func() { served <- s.Serve() })
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), runShutdownTimeout)
+	defer cancel()
+	err := s.Shutdown(shutdownCtx)
+	<-served
+	return err
+}
@@ -0,0 +1,85 @@
+package simplewebsockets_test
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+// Connects a simulated client and waits until the server registered it.
+func shutdownClient(t *testing.T) (*wstest.Simulation, *wstest.SimClient) {
+	t.Helper()
+	sim := wstest.NewSimulation(2005)
+	connected := make(chan struct{}, 1)
+	sim.Server.OnConnect(func(c *simplewebsockets.Connection) { connected <- struct{}{} })
+	c, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(c.Abort)
+	<-connected
+	return sim, c
+}
+
+func TestShutdown(t *testing.T) {
+	sim, c := shutdownClient(t)
+
+	done := make(chan error, 1)
+	go func() { done <- sim.Server.Shutdown(context.Background()) }()
+	if r := nextReply(t, c); r.closeCode != 1001 {
+		t.Fatalf("server replied %+v, want a close with 1001", r)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("shutdown returned %v before the closing handshake finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.Close(1001, "")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown didn't return after the closing handshake")
+	}
+
+	if err := sim.Server.Shutdown(context.Background()); !errors.Is(err, simplewebsockets.ErrServerClosed) {
+		t.Errorf("second shutdown: %v, want ErrServerClosed", err)
+	}
+	if _, err := sim.Connect(rand.New(rand.NewSource(sim.Seed + 1))); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("connecting after shutdown: %v, want a 503", err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	sim, c := shutdownClient(t)
+
+	// the client never answers the close frame
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := sim.Server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("shutdown: %v, want the context's error", err)
+	}
+	dropped := make(chan struct{})
+	go func() {
+		for {
+			if _, err := c.NextFrame(); err != nil {
+				close(dropped)
+				return
+			}
+		}
+	}()
+	select {
+	case <-dropped:
+	case <-time.After(5 * time.Second):
+		t.Error("connection wasn't dropped when the shutdown timed out")
+	}
+}
@@ -0,0 +1,157 @@
+package simplewebsockets
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// Returned by StateRoom.UpdateAt when the document changed since the version the update was based on.
+var ErrStateConflict = errors.New("state was updated concurrently")
+
+// A room whose members share an authoritative JSON document held by the server. Joining members receive a
+// "state.snapshot" event with the document and its version, every change is sent to all members as a
+// "state.patch" event carrying a JSON merge patch (RFC 7386) and the new version. Merge patches can't set
+// a value to null, so null object members are sent as deletions.
+type StateRoom struct {
+	room *Room
+
+	mx      sync.Mutex // orders joins and updates
+	doc     []byte     // marshalled document, decoded afresh for every update
+	version uint64
+}
+
+type stateSnapshot struct {
+	Version uint64          `json:"version"`
+	Doc     json.RawMessage `json:"doc"`
+}
+
+type statePatch struct {
+	Version uint64 `json:"version"`
+	Patch   any    `json:"patch"`
+}
+
+// Creates a state room on top of room with initial as version 1 of the document.
+func NewStateRoom(room *Room, initial any) (*StateRoom, error) {
+	doc, err := json.Marshal(initial)
+	if err != nil {
+		return nil, err
+	}
+	return &StateRoom{room: room, doc: doc, version: 1}, nil
+}
+
+// Returns the room the state is shared in.
+func (s *StateRoom) Room() *Room {
+	return s.room
+}
+
+// Returns the current document and its version.
+func (s *StateRoom) Snapshot() (json.RawMessage, uint64) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return append(json.RawMessage(nil), s.doc...), s.version
+}
+
+// Joins the room and sends the connection the current snapshot. No update is applied in between, so the
+// first patch the connection receives follows the snapshot.
+func (s *StateRoom) Join(c *Connection) error {
+	if err := s.room.authorize(c, ActionJoin); err != nil {
+		return err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	data, err := marshalEvent("state.snapshot", stateSnapshot{Version: s.version, Doc: s.doc})
+	if err != nil {
+		return err
+	}
+	if err := c.writeMessage(byte(TextMessage), data); err != nil {
+		return err
+	}
+	return s.room.join(c)
+}
+
+// Replaces the document with the result of fn and broadcasts the difference. fn gets a private copy of the
+// document decoded into maps, slices and scalars, so it may modify it in place. Returns the new version,
+// which stays the same if fn changed nothing.
+func (s *StateRoom) Update(fn func(doc any) any) (uint64, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.update(fn)
+}
+
+// Like Update, but only if the document is still at version, e.g. the version a client based its edit on.
+// Fails with ErrStateConflict otherwise.
+func (s *StateRoom) UpdateAt(version uint64, fn func(doc any) any) (uint64, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if version != s.version {
+		return s.version, ErrStateConflict
+	}
+	return s.update(fn)
+}
+
+// applies fn to the document, callers must hold mx
+func (s *StateRoom) update(fn func(doc any) any) (uint64, error) {
+	var old, cur any
+	if err := json.Unmarshal(s.doc, &old); err != nil {
+		return s.version, err
+	}
+	if err := json.Unmarshal(s.doc, &cur); err != nil {
+		return s.version, err
+	}
+
+	doc, err := json.Marshal(fn(cur))
+	if err != nil {
+		return s.version, err
+	}
+
+	// compare in decoded form so both sides have the same types
+	var next any
+	if err := json.Unmarshal(doc, &next); err != nil {
+		return s.version, err
+	}
+	patch, changed := mergePatch(old, next)
+	if !changed {
+		return s.version, nil
+	}
+
+	s.doc = doc
+	s.version++
+
+	data, err := marshalEvent("state.patch", statePatch{Version: s.version, Patch: patch})
+	if err != nil {
+		return s.version, err
+	}
+	return s.version, s.room.Broadcast(TextMessage, data).Err
+}
+
+// Builds the JSON merge patch turning from into to, and reports whether they differ.
+func mergePatch(from any, to any) (any, bool) {
+	fromObj, ok := from.(map[string]any)
+	toObj, ok2 := to.(map[string]any)
+	if !ok || !ok2 {
+		// anything but two objects is replaced as a whole
+		return to, !reflect.DeepEqual(from, to)
+	}
+
+	patch := make(map[string]any)
+	for k, fv := range fromObj {
+		tv, ok := toObj[k]
+		if !ok {
+			patch[k] = nil // removed
+			continue
+		}
+		if p, changed := mergePatch(fv, tv); changed {
+			patch[k] = p
+		}
+	}
+	for k, tv := range toObj {
+		if _, ok := fromObj[k]; !ok {
+			patch[k] = tv
+		}
+	}
+	return patch, len(patch) > 0
+}
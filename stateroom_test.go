@@ -0,0 +1,101 @@
+package simplewebsockets_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+// Reads the next event from c and decodes its data into v.
+func nextEvent(t *testing.T, c *wstest.SimClient, name string, v any) {
+	t.Helper()
+	timer := time.AfterFunc(5*time.Second, c.Abort)
+	defer timer.Stop()
+	_, msg, err := c.NextMessage()
+	if err != nil {
+		t.Fatalf("reading %q: %v", name, err)
+	}
+	var ev simplewebsockets.Event
+	if err := json.Unmarshal(msg, &ev); err != nil || ev.Name != name {
+		t.Fatalf("got %s (%v), want a %q event", msg, err, name)
+	}
+	if err := json.Unmarshal(ev.Data, v); err != nil {
+		t.Fatalf("decoding %s: %v", ev.Data, err)
+	}
+}
+
+func TestStateRoom(t *testing.T) {
+	sim := wstest.NewSimulation(1996)
+	conns := make(chan *simplewebsockets.Connection, 1)
+	sim.Server.OnConnect(func(c *simplewebsockets.Connection) { conns <- c })
+	c, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Abort()
+
+	state, err := simplewebsockets.NewStateRoom(sim.Server.Room("board"), map[string]any{"title": "todo", "done": []string{}})
+	if err != nil {
+		t.Fatalf("new state room: %v", err)
+	}
+	if err := state.Join(<-conns); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	var snapshot struct {
+		Version uint64
+		Doc     map[string]any
+	}
+	nextEvent(t, c, "state.snapshot", &snapshot)
+	if snapshot.Version != 1 || snapshot.Doc["title"] != "todo" {
+		t.Fatalf("snapshot %+v, want version 1 of the initial document", snapshot)
+	}
+
+	version, err := state.Update(func(doc any) any {
+		m := doc.(map[string]any)
+		m["title"] = "today"
+		m["owner"] = "ana"
+		delete(m, "done")
+		return m
+	})
+	if err != nil || version != 2 {
+		t.Fatalf("update: version %d (%v), want 2", version, err)
+	}
+	type statePatch struct {
+		Version uint64
+		Patch   map[string]any
+	}
+	var patch statePatch
+	nextEvent(t, c, "state.patch", &patch)
+	want := map[string]any{"title": "today", "owner": "ana", "done": nil}
+	if patch.Version != 2 || !reflect.DeepEqual(patch.Patch, want) {
+		t.Fatalf("patch %+v, want version 2 with %v", patch, want)
+	}
+
+	// an update that changes nothing keeps the version and sends nothing
+	if version, err := state.Update(func(doc any) any { return doc }); err != nil || version != 2 {
+		t.Fatalf("no-op update: version %d (%v), want 2", version, err)
+	}
+
+	// an edit based on an old version is refused
+	if _, err := state.UpdateAt(1, func(doc any) any { return "stale" }); !errors.Is(err, simplewebsockets.ErrStateConflict) {
+		t.Fatalf("update at an old version: %v, want ErrStateConflict", err)
+	}
+	if version, err := state.UpdateAt(2, func(doc any) any { return map[string]any{"title": "today"} }); err != nil || version != 3 {
+		t.Fatalf("update at the current version: version %d (%v), want 3", version, err)
+	}
+	patch = statePatch{}
+	nextEvent(t, c, "state.patch", &patch)
+	if want := map[string]any{"owner": nil}; patch.Version != 3 || !reflect.DeepEqual(patch.Patch, want) {
+		t.Fatalf("patch %+v, want version 3 with %v", patch, want)
+	}
+	if doc, version := state.Snapshot(); version != 3 || string(doc) != `{"title":"today"}` {
+		t.Errorf("snapshot %s at version %d, want the last update at 3", doc, version)
+	}
+}
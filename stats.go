@@ -0,0 +1,324 @@
+package simplewebsockets
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Traffic counters for a connection or tenant. Message counts only include text and binary messages.
+type Usage struct {
+	BytesIn     int64
+	BytesOut    int64
+	MessagesIn  int64
+	MessagesOut int64
+}
+
+// Snapshot of the counters of a single connection. ParseTime and HandlerTime are only tracked WithDiagnostics,
+// the queue counters only WithSendQueue.
+type ConnectionStats struct {
+	Usage
+	ParseTime   time.Duration
+	HandlerTime time.Duration
+
+	Queued    int   // messages currently waiting in the send queue
+	Expired   int64 // queued messages dropped because their TTL passed
+	Conflated int64 // queued messages replaced by a newer one with the same conflation key
+
+	MissedPings  int           // keepalive pings missed in a row, see WithKeepalive
+	RTT          time.Duration // smoothed round trip time of keepalive pings, 0 before the first pong
+	PingInterval time.Duration // current keepalive interval, see WithAdaptiveKeepalive
+
+	Compression CompressionStats
+}
+
+// Payload bytes of the messages sent and received compressed by permessage-deflate or the run-length
+// extension, before (Raw) and after (Wire) compression. Messages sent as is, e.g. below the minimum size
+// for deflate, aren't counted.
+type CompressionStats struct {
+	InRaw   int64
+	InWire  int64
+	OutRaw  int64
+	OutWire int64
+}
+
+// Returns wire bytes per raw byte of inbound compressed messages, 0 if there were none.
+func (s CompressionStats) InRatio() float64 {
+	if s.InRaw == 0 {
+		return 0
+	}
+	return float64(s.InWire) / float64(s.InRaw)
+}
+
+// Returns wire bytes per raw byte of outbound compressed messages, 0 if there were none.
+func (s CompressionStats) OutRatio() float64 {
+	if s.OutRaw == 0 {
+		return 0
+	}
+	return float64(s.OutWire) / float64(s.OutRaw)
+}
+
+type compressionCounters struct {
+	inRaw, inWire, outRaw, outWire atomic.Int64
+}
+
+// Usage of a single connection in a UsageReport.
+type ConnectionUsage struct {
+	ID     uint64
+	Tenant string
+	Usage
+}
+
+// Usage of a single tenant in a UsageReport, including traffic of connections that have already closed.
+// Tenants without connections, rooms or own limits are forgotten after the report that follows their last
+// connection, and count from zero if they connect again. Since tells these counts apart: usage with a
+// different Since than the previous report started over.
+type TenantUsage struct {
+	Tenant      string
+	Connections int
+	Since       time.Time // when the counters started
+	Usage
+}
+
+// Periodic snapshot of cumulative usage, delivered to the callback set with WithUsageReporting.
+type UsageReport struct {
+	Time        time.Time
+	Tenants     []TenantUsage
+	Connections []ConnectionUsage
+}
+
+// counters updated from the read and write paths
+type usageCounters struct {
+	bytesIn     atomic.Int64
+	bytesOut    atomic.Int64
+	messagesIn  atomic.Int64
+	messagesOut atomic.Int64
+}
+
+func (u *usageCounters) snapshot() Usage {
+	return Usage{
+		BytesIn:     u.bytesIn.Load(),
+		BytesOut:    u.bytesOut.Load(),
+		MessagesIn:  u.messagesIn.Load(),
+		MessagesOut: u.messagesOut.Load(),
+	}
+}
+
+// Setter to be passed into the creation of a server. Every interval a UsageReport with the cumulative
+// usage of all tenants and open connections is passed to fn, e.g. for usage based billing.
+func WithUsageReporting(interval time.Duration, fn func(UsageReport)) ServerOption {
+	return func(s *Server) {
+		s.usageInterval = interval
+		s.onUsage = fn
+	}
+}
+
+// Returns a unique (per server) id of the connection.
+func (c *Connection) ID() uint64 {
+	return c.id
+}
+
+// Returns a snapshot of the connection's counters.
+func (c *Connection) Stats() ConnectionStats {
+	stats := ConnectionStats{
+		Usage:        c.usage.snapshot(),
+		ParseTime:    time.Duration(c.timings.parseTime.Load()),
+		HandlerTime:  time.Duration(c.timings.handlerTime.Load()),
+		MissedPings:  int(c.missedPings.Load()),
+		RTT:          c.RTT(),
+		PingInterval: time.Duration(c.pingInterval.Load()),
+		Compression: CompressionStats{
+			InRaw:   c.compression.inRaw.Load(),
+			InWire:  c.compression.inWire.Load(),
+			OutRaw:  c.compression.outRaw.Load(),
+			OutWire: c.compression.outWire.Load(),
+		},
+	}
+	if c.queue != nil {
+		stats.Queued = c.queue.len()
+		stats.Expired = c.queue.expired.Load()
+		stats.Conflated = c.queue.conflated.Load()
+	}
+	return stats
+}
+
+// Aggregate send queue depth among the members of a room, see Room.Lag. A growing MaxQueued with a low
+// MedianQueued points at a few slow consumers, a growing median at a room that produces more than its
+// members can take.
+type RoomLag struct {
+	Members      int
+	TotalQueued  int
+	MaxQueued    int
+	MedianQueued int
+}
+
+// Returns the current queue depths of the room's members. Without WithSendQueue all depths are 0.
+func (r *Room) Lag() RoomLag {
+	members := r.Members()
+	depths := make([]int, 0, len(members))
+	lag := RoomLag{Members: len(members)}
+	for _, c := range members {
+		n := 0
+		if c.queue != nil {
+			n = c.queue.len()
+		}
+		depths = append(depths, n)
+		lag.TotalQueued += n
+		lag.MaxQueued = max(lag.MaxQueued, n)
+	}
+	if len(depths) > 0 {
+		sort.Ints(depths)
+		lag.MedianQueued = depths[len(depths)/2]
+	}
+	return lag
+}
+
+// Returns the lag of every room of the tenant by room name.
+func (t *Tenant) RoomLags() map[string]RoomLag {
+	t.mx.RLock()
+	rooms := make([]*Room, 0, len(t.rooms))
+	for _, r := range t.rooms {
+		rooms = append(rooms, r)
+	}
+	t.mx.RUnlock()
+
+	lags := make(map[string]RoomLag, len(rooms))
+	for _, r := range rooms {
+		lags[r.name] = r.Lag()
+	}
+	return lags
+}
+
+// Returns the cumulative usage of the tenant across all its past and current connections, since the tenant
+// was created (see TenantUsage).
+func (t *Tenant) Usage() Usage {
+	return t.usage.snapshot()
+}
+
+// Builds a usage report of all tenants and open connections.
+func (s *Server) UsageReport() UsageReport {
+	report := UsageReport{Time: s.clock.Now()}
+
+	s.tenantsMx.Lock()
+	tenants := make([]*Tenant, 0, len(s.tenants))
+	for _, t := range s.tenants {
+		tenants = append(tenants, t)
+	}
+	s.tenantsMx.Unlock()
+
+	for _, t := range tenants {
+		report.Tenants = append(report.Tenants, TenantUsage{
+			Tenant:      t.id,
+			Connections: t.ConnectionCount(),
+			Since:       t.since,
+			Usage:       t.Usage(),
+		})
+	}
+	sort.Slice(report.Tenants, func(i, j int) bool {
+		return report.Tenants[i].Tenant < report.Tenants[j].Tenant
+	})
+
+	s.connectionsMx.RLock()
+	for c := range s.connections {
+		report.Connections = append(report.Connections, ConnectionUsage{
+			ID:     c.id,
+			Tenant: c.tenant.id,
+			Usage:  c.usage.snapshot(),
+		})
+	}
+	s.connectionsMx.RUnlock()
+	sort.Slice(report.Connections, func(i, j int) bool {
+		return report.Connections[i].ID < report.Connections[j].ID
+	})
+
+	return report
+}
+
+// delivers usage reports until the server stops
+func (s *Server) reportUsage() {
+	ticker := s.clock.NewTicker(s.usageInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			s.onUsage(s.UsageReport())
+			s.forgetIdleTenants()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// counts inbound bytes on the connection and its tenant
+func (c *Connection) countBytesIn(n int) {
+	c.usage.bytesIn.Add(int64(n))
+	c.tenant.usage.bytesIn.Add(int64(n))
+}
+
+// counts outbound bytes on the connection and its tenant
+func (c *Connection) countBytesOut(n int) {
+	c.usage.bytesOut.Add(int64(n))
+	c.tenant.usage.bytesOut.Add(int64(n))
+}
+
+// counts the payload sizes of a compressed message
+func (c *Connection) countCompressed(dir Direction, raw int, wire int) {
+	if dir == Inbound {
+		c.compression.inRaw.Add(int64(raw))
+		c.compression.inWire.Add(int64(wire))
+	} else {
+		c.compression.outRaw.Add(int64(raw))
+		c.compression.outWire.Add(int64(wire))
+	}
+}
+
+// counts an inbound message of size payload bytes
+func (c *Connection) countMessageIn(size int64) {
+	c.usage.messagesIn.Add(1)
+	c.markActive()
+	c.tenant.usage.messagesIn.Add(1)
+	c.server.metrics.inboundSize.observe(float64(size))
+	c.checkLargeMessage(Inbound, size)
+}
+
+// counts an outbound message of size payload bytes
+func (c *Connection) countMessageOut(size int64) {
+	c.usage.messagesOut.Add(1)
+	c.markActive()
+	c.tenant.usage.messagesOut.Add(1)
+	c.server.metrics.outboundSize.observe(float64(size))
+	c.checkLargeMessage(Outbound, size)
+}
+
+// Direction of a message relative to the server.
+type Direction int
+
+const (
+	Inbound  Direction = iota // received from the peer
+	Outbound                  // sent to the peer
+)
+
+func (d Direction) String() string {
+	if d == Inbound {
+		return "inbound"
+	}
+	return "outbound"
+}
+
+// Setter to be passed into the creation of a server. fn is called for every data message larger than threshold
+// bytes in either direction, to find clients that lean on a generous max message size. fn runs on the reading or
+// sending goroutine, possibly holding the connection's write lock, so it must not send on the connection.
+func WithLargeMessageAlert(threshold int64, fn func(c *Connection, dir Direction, size int64)) ServerOption {
+	return func(s *Server) {
+		s.largeMessageThreshold = threshold
+		s.onLargeMessage = fn
+	}
+}
+
+func (c *Connection) checkLargeMessage(dir Direction, size int64) {
+	s := c.server
+	if s.onLargeMessage != nil && size > s.largeMessageThreshold {
+		s.onLargeMessage(c, dir, size)
+	}
+}
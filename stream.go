@@ -0,0 +1,189 @@
+package simplewebsockets
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Sends everything read from r as a single binary message, emitting a frame of at most frameSize bytes
+// whenever data becomes available, so the message never has to be buffered in memory. progress (may be nil)
+// is called with the total payload bytes sent after each frame. A message can't be abandoned halfway, so if
+// ctx is cancelled or r fails mid-stream the connection is closed with 1011.
+func (c *Connection) StreamFrom(ctx context.Context, r io.Reader, frameSize int, progress func(sent int64)) error {
+	if frameSize <= 0 {
+		return fmt.Errorf("frame size must be positive")
+	}
+	if err := c.checkWritable(); err != nil {
+		return err
+	}
+
+	c.sendMx.Lock()
+	defer c.sendMx.Unlock()
+
+	sum := c.checksum.newHash()
+	buf := make([]byte, frameSize)
+	opcode := byte(0x2)
+	var sent int64
+
+	abort := func(err error) error {
+		return c.closeWithError(err, 1011, "Stream aborted")
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return abort(err)
+		}
+
+		n, err := r.Read(buf)
+		if n > 0 {
+			if sum != nil {
+				sum.Write(buf[:n])
+			}
+			if werr := c.writeFrame(NewFrame(opcode, buf[:n], false, false, [4]byte{})); werr != nil {
+				return werr
+			}
+			opcode = 0x0
+			sent += int64(n)
+			if progress != nil {
+				progress(sent)
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return abort(err)
+		}
+	}
+
+	// final frame carries the checksum if the envelope is enabled
+	var tail []byte
+	if sum != nil {
+		tail = binary.BigEndian.AppendUint32(nil, sum.Sum32())
+	}
+	if err := c.writeFrame(NewFrame(opcode, tail, true, false, [4]byte{})); err != nil {
+		return err
+	}
+	c.countMessageOut(sent + int64(len(tail)))
+	return nil
+}
+
+// Writes a single frame, holding writeMx only for that frame so control frames can be sent in between.
+func (c *Connection) writeFrame(f Frame) error {
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+	_, err := c.writeEncoded(f)
+	return err
+}
+
+// Returned by the writer of NextWriter after it was closed.
+var ErrWriterClosed = errors.New("message writer closed")
+
+// Waits for the next data message and returns a reader that yields its payload as the frames arrive, so the
+// message never has to be held in memory whole. From the first call on, every message goes to NextReader
+// instead of OnMessage. The connection buffers up to 128 KiB of messages that weren't read yet and stops
+// reading past that, so read them promptly. Calling NextReader again drops what is left of the previous
+// message. Messages compressed by an extension are reassembled first, and so is every message if the server
+// has a validator, replay protection, trace IDs or MessageEvent subscribers, which need it in one piece.
+// Binary messages carrying the checksum envelope are verified at their end and the reader fails with a
+// *ChecksumError if they don't match. Messages over the connection's size limit close it with 1009.
+func (c *Connection) NextReader() (MessageType, io.Reader, error) {
+	if c.Mode() == ModeWriteOnly {
+		return 0, nil, fmt.Errorf("can't read messages on a write-only connection")
+	}
+	m := c.streams.next()
+	if m == nil {
+		return 0, nil, context.Cause(c.ctx)
+	}
+	return m.mt, messageReader{m}, nil
+}
+
+// Starts a message of type mt whose payload is written in pieces. Every streamFrameSize bytes are sent as a
+// frame, Close sends the rest and completes the message. Until then other messages wait, so close the
+// writer even after a failed Write. Messages aren't compressed this way.
+func (c *Connection) NextWriter(mt MessageType) (io.WriteCloser, error) {
+	if mt != TextMessage && mt != BinaryMessage {
+		return nil, fmt.Errorf("unsupported message type %v", mt)
+	}
+	if err := c.checkWritable(); err != nil {
+		return nil, err
+	}
+	c.sendMx.Lock()
+	w := &messageWriter{c: c, opcode: byte(mt), buf: make([]byte, 0, streamFrameSize)}
+	if mt == BinaryMessage {
+		w.sum = c.checksum.newHash()
+	}
+	return w, nil
+}
+
+// The writer returned by NextWriter, holding sendMx until it is closed.
+type messageWriter struct {
+	c      *Connection
+	opcode byte // 0 once the first frame went out
+	buf    []byte
+	sum    hash.Hash32 // nil unless the checksum envelope applies
+	sent   int64
+	closed bool
+	err    error
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrWriterClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := len(p)
+	for {
+		k := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+k]
+		p = p[k:]
+		if len(p) == 0 {
+			return n, nil
+		}
+		// only send a full buffer once more data follows, the last frame is sent by Close
+		if w.err = w.flush(false); w.err != nil {
+			return n - len(p), w.err
+		}
+	}
+}
+
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return ErrWriterClosed
+	}
+	w.closed = true
+	defer w.c.sendMx.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.flush(true); err != nil {
+		return err
+	}
+	w.c.countMessageOut(w.sent)
+	return nil
+}
+
+// sends the buffered payload as the next frame of the message
+func (w *messageWriter) flush(fin bool) error {
+	payload := w.buf
+	if w.sum != nil {
+		w.sum.Write(payload)
+		if fin {
+			payload = binary.BigEndian.AppendUint32(payload, w.sum.Sum32())
+		}
+	}
+	if err := w.c.writeFrame(NewFrame(w.opcode, payload, fin, false, [4]byte{})); err != nil {
+		return err
+	}
+	w.opcode = 0x0
+	w.sent += int64(len(payload))
+	w.buf = w.buf[:0]
+	return nil
+}
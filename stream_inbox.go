@@ -0,0 +1,200 @@
+package simplewebsockets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Payload bytes the read loop buffers for NextReader before it waits for the application to read some.
+const streamInboxSize = 8 * streamFrameSize
+
+var (
+	// reported by the reader of a message a later NextReader call skipped
+	errReaderDiscarded = errors.New("message discarded by a later NextReader call")
+	// reported by the reader of a message dropped because the connection is going away
+	errMessageDropped = fmt.Errorf("%w: message dropped", ErrConnectionClosed)
+)
+
+// Messages received for NextReader, filled by the read loop and drained by the readers NextReader hands out.
+// The read loop only waits once streamInboxSize bytes are buffered, so pings, pongs and close frames are still
+// handled while no reader is pending. Once the connection is closing it stops waiting and drops what doesn't
+// fit, so an application that stopped reading can't keep the read loop from seeing the peer's close.
+type streamInbox struct {
+	mx       sync.Mutex
+	cond     *sync.Cond
+	used     bool             // NextReader was called, data messages go here instead of to OnMessage
+	messages []*streamMessage // oldest first
+	reading  bool             // messages[0] was handed to a reader
+	held     int              // payload bytes buffered across messages
+	draining bool             // the connection is going away, nothing is waited for anymore
+	ended    bool             // the read loop stopped, no more messages arrive
+}
+
+func newStreamInbox() *streamInbox {
+	in := &streamInbox{}
+	in.cond = sync.NewCond(&in.mx)
+	return in
+}
+
+// A message in the inbox. The read loop writes its payload as the frames arrive.
+type streamMessage struct {
+	in      *streamInbox
+	mt      MessageType
+	chunks  [][]byte
+	fin     bool  // the last frame arrived
+	err     error // reported by the reader instead of io.EOF
+	dropped bool  // the rest of the payload is thrown away
+}
+
+// Switches the connection to NextReader.
+func (in *streamInbox) use() {
+	in.mx.Lock()
+	defer in.mx.Unlock()
+	in.used = true
+}
+
+// Queues a new message of type mt, called from the read loop. Returns nil unless NextReader was called.
+func (in *streamInbox) begin(mt MessageType) *streamMessage {
+	in.mx.Lock()
+	defer in.mx.Unlock()
+	if !in.used {
+		return nil
+	}
+	m := &streamMessage{in: in, mt: mt}
+	in.messages = append(in.messages, m)
+	in.cond.Broadcast()
+	return m
+}
+
+// Waits for the next message, dropping what is left of the previous one. Returns nil once the read loop
+// stopped and every message was handed out.
+func (in *streamInbox) next() *streamMessage {
+	in.mx.Lock()
+	defer in.mx.Unlock()
+	in.used = true
+	if in.reading {
+		in.messages[0].drop(errReaderDiscarded)
+		in.messages[0] = nil
+		in.messages = in.messages[1:]
+		in.reading = false
+	}
+	for len(in.messages) == 0 && !in.ended {
+		in.cond.Wait()
+	}
+	if len(in.messages) == 0 {
+		return nil
+	}
+	in.reading = true
+	return in.messages[0]
+}
+
+// Stops the read loop from waiting for readers, once the connection is going away.
+func (in *streamInbox) drain() {
+	in.mx.Lock()
+	defer in.mx.Unlock()
+	in.draining = true
+	in.cond.Broadcast()
+}
+
+// Wakes NextReader calls once the read loop stopped.
+func (in *streamInbox) end() {
+	in.mx.Lock()
+	defer in.mx.Unlock()
+	in.ended = true
+	in.cond.Broadcast()
+}
+
+// Buffers the next piece of the payload, waiting while the inbox is full. A connection that is going away
+// drops the message instead.
+func (m *streamMessage) Write(p []byte) (int, error) {
+	in := m.in
+	in.mx.Lock()
+	defer in.mx.Unlock()
+
+	// a single piece may be larger than the inbox, it just has to wait until the rest was read
+	for !m.dropped && in.held > 0 && in.held+len(p) > streamInboxSize {
+		if in.draining {
+			m.drop(errMessageDropped)
+			break
+		}
+		in.cond.Wait()
+	}
+	if m.dropped || len(p) == 0 {
+		return len(p), nil
+	}
+	m.chunks = append(m.chunks, bytes.Clone(p))
+	in.held += len(p)
+	in.cond.Broadcast()
+	return len(p), nil
+}
+
+// Completes the message, err (if not nil) is what its reader gets instead of io.EOF.
+func (m *streamMessage) finish(err error) {
+	in := m.in
+	in.mx.Lock()
+	defer in.mx.Unlock()
+	m.fin = true
+	if m.err == nil {
+		m.err = err
+	}
+	in.cond.Broadcast()
+}
+
+// throws away the buffered payload and what is still to come, called with in.mx held
+func (m *streamMessage) drop(err error) {
+	for _, chunk := range m.chunks {
+		m.in.held -= len(chunk)
+	}
+	m.chunks = nil
+	m.dropped = true
+	if m.err == nil {
+		m.err = err
+	}
+	m.in.cond.Broadcast()
+}
+
+// The reader NextReader returns.
+type messageReader struct {
+	m *streamMessage
+}
+
+func (r messageReader) Read(p []byte) (int, error) {
+	m := r.m
+	in := m.in
+	in.mx.Lock()
+	defer in.mx.Unlock()
+
+	for len(m.chunks) == 0 && !m.fin && !m.dropped {
+		in.cond.Wait()
+	}
+	if len(m.chunks) == 0 {
+		if m.err != nil {
+			return 0, m.err
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, m.chunks[0])
+	if m.chunks[0] = m.chunks[0][n:]; len(m.chunks[0]) == 0 {
+		m.chunks[0] = nil
+		m.chunks = m.chunks[1:]
+	}
+	in.held -= n
+	in.cond.Broadcast()
+	return n, nil
+}
+
+// Stops the read loop from waiting for the application to read, once the connection is going away. Messages
+// that no longer fit are dropped, so the read loop gets to the peer's close frame or the read error.
+func (c *Connection) drainReads() {
+	c.drainOnce.Do(func() { close(c.drained) })
+	c.streams.drain()
+}
+
+// Drops the network connection without a closing handshake, see drainReads.
+func (c *Connection) dropConn() error {
+	c.drainReads()
+	return c.conn.Close()
+}
@@ -0,0 +1,89 @@
+package simplewebsockets_test
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+// Connects a simulated client to a server whose connections are read with NextReader. Every message read
+// is sent on the returned channel.
+func streamClient(t *testing.T, opts ...simplewebsockets.ServerOption) (*wstest.SimClient, chan string) {
+	t.Helper()
+	sim := wstest.NewSimulation(2018, opts...)
+	read := make(chan string, 16)
+	sim.Server.OnConnect(func(c *simplewebsockets.Connection) {
+		simplewebsockets.WithStreamReads()(c)
+		go func() {
+			for {
+				_, r, err := c.NextReader()
+				if err != nil {
+					return
+				}
+				msg, err := io.ReadAll(r)
+				if err != nil {
+					return
+				}
+				read <- string(msg)
+			}
+		}()
+	})
+	c, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(c.Abort)
+	return c, read
+}
+
+func nextRead(t *testing.T, read chan string) string {
+	t.Helper()
+	select {
+	case msg := <-read:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("NextReader got no message")
+		return ""
+	}
+}
+
+func TestNextReaderValidated(t *testing.T) {
+	c, read := streamClient(t, simplewebsockets.WithMessageValidator(func(mt simplewebsockets.MessageType, data []byte) error {
+		if string(data) == "bad" {
+			return errors.New("rejected")
+		}
+		return nil
+	}, 0))
+
+	c.SendFragments(0x1, []byte("go"), []byte("od"))
+	c.SendFragments(0x1, []byte("b"), []byte("ad"))
+	c.SendText("fine")
+	for _, want := range []string{"good", "fine"} {
+		if msg := nextRead(t, read); msg != want {
+			t.Fatalf("NextReader got %q, want %q", msg, want)
+		}
+	}
+}
+
+func TestNextReaderRateLimited(t *testing.T) {
+	c, read := streamClient(t, simplewebsockets.WithConnectionRateLimit(1))
+
+	c.SendFragments(0x2, []byte("first"), []byte(" message"))
+	if msg := nextRead(t, read); msg != "first message" {
+		t.Fatalf("NextReader got %q, want the first message", msg)
+	}
+	c.SendFragments(0x2, []byte("second"), []byte(" message"))
+	if r := nextReply(t, c); r.closeCode != 1008 {
+		t.Fatalf("server replied %+v, want a close with 1008", r)
+	}
+	select {
+	case msg := <-read:
+		t.Errorf("NextReader got %q past the rate limit", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,73 @@
+package simplewebsockets
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Setter to be passed into the creation of a server. The subprotocols the server speaks, in order of
+// preference. The first one the client also offers in Sec-WebSocket-Protocol is echoed in the 101 response
+// and returned by Connection.Subprotocol. Clients offering none of them are accepted without a subprotocol.
+func WithSubprotocols(protocols ...string) ServerOption {
+	return func(s *Server) {
+		s.subprotocols = protocols
+	}
+}
+
+// Setter to be passed into the creation of a server. fn picks the subprotocol from the ones the client offers,
+// instead of the preference order of WithSubprotocols. Returning "" or a protocol the client didn't offer
+// accepts the connection without a subprotocol.
+func WithSubprotocolSelector(fn func(r *http.Request, offered []string) string) ServerOption {
+	return func(s *Server) {
+		s.subprotocolSelector = fn
+	}
+}
+
+// Returns the subprotocol agreed on during the handshake, "" if there is none.
+func (c *Connection) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Picks the subprotocol of c for its upgrade request, and its envelope version with WithEnvelopeVersions.
+// Fails if they speak no envelope version in common.
+func (s *Server) negotiateSubprotocol(c *Connection, r *http.Request) error {
+	offered := offeredSubprotocols(r.Header)
+	c.subprotocol = s.pickSubprotocol(r, offered)
+	var err error
+	c.envelopeVersion, err = s.envelopeVersion(offered, c.subprotocol)
+	return err
+}
+
+// picks one of the offered subprotocols, "" if there is none
+func (s *Server) pickSubprotocol(r *http.Request, offered []string) string {
+	if len(offered) == 0 {
+		return ""
+	}
+
+	if s.subprotocolSelector != nil {
+		if p := s.subprotocolSelector(r, offered); slices.Contains(offered, p) {
+			return p
+		}
+		return ""
+	}
+	for _, p := range s.subprotocolPreference() {
+		if slices.Contains(offered, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// Returns the subprotocols offered in the Sec-WebSocket-Protocol headers of h.
+func offeredSubprotocols(h http.Header) []string {
+	var offered []string
+	for _, v := range h.Values("Sec-WebSocket-Protocol") {
+		for p := range strings.SplitSeq(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				offered = append(offered, p)
+			}
+		}
+	}
+	return offered
+}
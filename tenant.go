@@ -0,0 +1,241 @@
+package simplewebsockets
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limits applied across all connections belonging to a single tenant. A zero value means no limit.
+type TenantLimits struct {
+	MaxConnections       int   // concurrent connections, extra upgrades are rejected with a 503
+	MaxBytesPerSecond    int64 // inbound bytes per second, exceeding it closes the connection with 1008
+	MaxMessagesPerSecond int   // inbound messages per second, exceeding it closes the connection with 1008
+}
+
+// A Tenant scopes connections, limits, rooms and broadcasts so that one customer on shared infrastructure
+// can't affect the others. Connections that aren't attributed to a tenant belong to the default tenant ("").
+type Tenant struct {
+	id     string
+	server *Server
+
+	mx          sync.RWMutex
+	limits      TenantLimits
+	connections map[*Connection]bool
+	rooms       map[string]*Room
+
+	bytesLimiter *rateLimiter
+	msgLimiter   *rateLimiter
+	ownLimits    bool // set with SetLimits, keeps the tenant from being forgotten
+
+	usage usageCounters
+	since time.Time // when usage started counting
+}
+
+// Setter to be passed into the creation of a server. The resolver is called with the upgrade request and
+// returns the id of the tenant the new connection belongs to.
+func WithTenantResolver(fn func(r *http.Request) string) ServerOption {
+	return func(s *Server) {
+		s.tenantResolver = fn
+	}
+}
+
+// Setter to be passed into the creation of a server. Sets the limits every tenant starts with,
+// use Tenant.SetLimits to override them for a single tenant.
+func WithTenantLimits(limits TenantLimits) ServerOption {
+	return func(s *Server) {
+		s.tenantLimits = limits
+	}
+}
+
+// Returns the tenant with the given id, creating it with the default limits if it doesn't exist yet. A tenant
+// without rooms or limits of its own is forgotten when its last connection closes, so every id the resolver
+// ever returned doesn't stay in memory. With WithUsageReporting that waits until a report included its usage.
+func (s *Server) Tenant(id string) *Tenant {
+	s.tenantsMx.Lock()
+	defer s.tenantsMx.Unlock()
+	return s.tenant(id)
+}
+
+// Tenant with tenantsMx held.
+func (s *Server) tenant(id string) *Tenant {
+	t, ok := s.tenants[id]
+	if !ok {
+		t = &Tenant{
+			id:          id,
+			server:      s,
+			connections: make(map[*Connection]bool),
+			rooms:       make(map[string]*Room),
+			since:       s.clock.Now(),
+		}
+		t.setLimits(s.tenantLimits)
+		s.tenants[id] = t
+	}
+	return t
+}
+
+// Returns a room of the default tenant. Use Tenant.Room for connections attributed to a tenant.
+func (s *Server) Room(name string) *Room {
+	return s.Tenant("").Room(name)
+}
+
+// Returns the id of the tenant.
+func (t *Tenant) ID() string {
+	return t.id
+}
+
+// Returns the limits currently applied to the tenant.
+func (t *Tenant) Limits() TenantLimits {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	return t.limits
+}
+
+// Replaces the limits of the tenant. Rate limits start again from a full bucket.
+func (t *Tenant) SetLimits(limits TenantLimits) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.ownLimits = true
+	t.setLimits(limits)
+}
+
+// SetLimits with t.mx held, or before t is shared.
+func (t *Tenant) setLimits(limits TenantLimits) {
+	t.limits = limits
+	clock := t.server.clock
+	// a single read may bring a whole read buffer, which a lower rate could never allow
+	byteBurst := max(limits.MaxBytesPerSecond, readBufferSize)
+	t.bytesLimiter = newRateLimiter(float64(limits.MaxBytesPerSecond), float64(byteBurst), clock)
+	t.msgLimiter = newRateLimiter(float64(limits.MaxMessagesPerSecond), float64(limits.MaxMessagesPerSecond), clock)
+}
+
+// Returns the open connections of the tenant.
+func (t *Tenant) Connections() []*Connection {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	conns := make([]*Connection, 0, len(t.connections))
+	for c := range t.connections {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Returns current number of connections of the tenant.
+func (t *Tenant) ConnectionCount() int {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	return len(t.connections)
+}
+
+// Returns the room with the given name in this tenant's namespace, creating it if needed.
+func (t *Tenant) Room(name string) *Room {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	r, ok := t.rooms[name]
+	if !ok {
+		r = &Room{
+			name:    name,
+			tenant:  t,
+			members: make(map[*Connection]bool),
+		}
+		t.rooms[name] = r
+	}
+	return r
+}
+
+// Removes all members from a room and forgets it.
+func (t *Tenant) RemoveRoom(name string) {
+	t.mx.Lock()
+	r, ok := t.rooms[name]
+	delete(t.rooms, name)
+	t.mx.Unlock()
+
+	if ok {
+		for _, c := range r.Members() {
+			r.leave(c)
+		}
+	}
+}
+
+// Sends a text message to every open connection of the tenant.
+func (t *Tenant) BroadcastText(msg string) error {
+	return t.Broadcast(TextMessage, []byte(msg)).Err
+}
+
+// Sends a binary message to every open connection of the tenant.
+func (t *Tenant) BroadcastBinary(msg []byte) error {
+	return t.Broadcast(BinaryMessage, msg).Err
+}
+
+// adds a connection to the tenant, fails if the tenant is at its connection limit
+func (t *Tenant) addConnection(c *Connection) bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.limits.MaxConnections > 0 && len(t.connections) >= t.limits.MaxConnections {
+		return false
+	}
+	t.connections[c] = true
+	return true
+}
+
+func (t *Tenant) removeConnection(c *Connection) {
+	t.mx.Lock()
+	delete(t.connections, c)
+	t.mx.Unlock()
+}
+
+// reports whether nothing but the tenants map refers to the tenant anymore
+func (t *Tenant) idle() bool {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	return t.id != "" && len(t.connections) == 0 && len(t.rooms) == 0 && !t.ownLimits
+}
+
+// Adds c to the tenant with the given id, creating it if needed. Only called once c was admitted, so
+// upgrades that are turned away don't leave tenants for ids of the client's choosing behind. Fails if the
+// tenant is at its connection limit.
+func (s *Server) attachTenant(c *Connection, id string) bool {
+	s.tenantsMx.Lock()
+	defer s.tenantsMx.Unlock()
+	c.tenant = s.tenant(id)
+	return c.tenant.addConnection(c)
+}
+
+// Removes c from its tenant, forgetting the tenant if it is idle now and its usage doesn't wait for a report.
+func (s *Server) detachTenant(c *Connection) {
+	s.tenantsMx.Lock()
+	defer s.tenantsMx.Unlock()
+	t := c.tenant
+	t.removeConnection(c)
+	if s.onUsage == nil && s.tenants[t.id] == t && t.idle() {
+		delete(s.tenants, t.id)
+	}
+}
+
+// forgets idle tenants once their usage was reported
+func (s *Server) forgetIdleTenants() {
+	s.tenantsMx.Lock()
+	defer s.tenantsMx.Unlock()
+	for id, t := range s.tenants {
+		if t.idle() {
+			delete(s.tenants, id)
+		}
+	}
+}
+
+// checks inbound bytes against the tenant bandwidth limit
+func (t *Tenant) allowBytes(n int) bool {
+	t.mx.RLock()
+	l := t.bytesLimiter
+	t.mx.RUnlock()
+	return l.allow(float64(n))
+}
+
+// checks an inbound message against the tenant message rate limit
+func (t *Tenant) allowMessage() bool {
+	t.mx.RLock()
+	l := t.msgLimiter
+	t.mx.RUnlock()
+	return l.allow(1)
+}
@@ -0,0 +1,187 @@
+package simplewebsockets_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+// attributes connections to the tenant named in the URL's tenant parameter
+func tenantFromQuery(r *http.Request) string {
+	return r.URL.Query().Get("tenant")
+}
+
+func dialTenant(t *testing.T, url, tenant string) (*simplewebsockets.Connection, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return simplewebsockets.NewServer().Dial(ctx, url+"/?tenant="+tenant)
+}
+
+func tenantIDs(s *simplewebsockets.Server) []string {
+	var ids []string
+	for _, tu := range s.UsageReport().Tenants {
+		ids = append(ids, tu.Tenant)
+	}
+	return ids
+}
+
+// Upgrades turned away by a connection limit must not leave a tenant behind for the id they asked for.
+func TestRejectedUpgradeCreatesNoTenant(t *testing.T) {
+	s, url, _ := startServer(t,
+		simplewebsockets.WithTenantResolver(tenantFromQuery),
+		simplewebsockets.WithMaxConnections(1),
+		simplewebsockets.WithUsageReporting(time.Hour, func(simplewebsockets.UsageReport) {}))
+
+	c, err := dialTenant(t, url, "a")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close(1000, "")
+
+	for i := 0; i < 20; i++ {
+		if _, err := dialTenant(t, url, fmt.Sprintf("chosen-%d", i)); err == nil {
+			t.Fatal("upgrade over the connection limit succeeded")
+		}
+	}
+	for _, id := range tenantIDs(s) {
+		if strings.HasPrefix(id, "chosen-") {
+			t.Fatalf("rejected upgrades left tenants behind: %q", tenantIDs(s))
+		}
+	}
+}
+
+// waits for a report in which fn finds what it is looking for
+func awaitReport(t *testing.T, reports chan simplewebsockets.UsageReport, fn func(simplewebsockets.UsageReport) bool) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case r := <-reports:
+			if fn(r) {
+				return
+			}
+		case <-deadline:
+			t.Fatal("no matching usage report")
+		}
+	}
+}
+
+func tenantUsage(r simplewebsockets.UsageReport, id string) (simplewebsockets.TenantUsage, bool) {
+	for _, tu := range r.Tenants {
+		if tu.Tenant == id {
+			return tu, true
+		}
+	}
+	return simplewebsockets.TenantUsage{}, false
+}
+
+// A tenant forgotten while idle counts from zero when it comes back, with a new Since.
+func TestTenantUsageSinceEviction(t *testing.T) {
+	reports := make(chan simplewebsockets.UsageReport, 16)
+	s, url, _ := startServer(t,
+		simplewebsockets.WithTenantResolver(tenantFromQuery),
+		simplewebsockets.WithUsageReporting(10*time.Millisecond, func(r simplewebsockets.UsageReport) {
+			select {
+			case reports <- r:
+			default:
+			}
+		}))
+	received := make(chan bool, 1)
+	s.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) { received <- true })
+
+	session := func() {
+		c, err := dialTenant(t, url, "a")
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		c.SendTextMessage("hi")
+		<-received
+		c.Close(1000, "")
+	}
+
+	session()
+	var first simplewebsockets.TenantUsage
+	awaitReport(t, reports, func(r simplewebsockets.UsageReport) bool {
+		tu, ok := tenantUsage(r, "a")
+		first = tu
+		return ok && tu.Connections == 0
+	})
+	if first.MessagesIn != 1 || first.Since.IsZero() {
+		t.Fatalf("first session's usage %+v, want 1 message in and a start time", first)
+	}
+	awaitReport(t, reports, func(r simplewebsockets.UsageReport) bool {
+		_, ok := tenantUsage(r, "a")
+		return !ok
+	})
+
+	session()
+	awaitReport(t, reports, func(r simplewebsockets.UsageReport) bool {
+		tu, ok := tenantUsage(r, "a")
+		if ok && (tu.MessagesIn != 1 || !tu.Since.After(first.Since)) {
+			t.Fatalf("usage after eviction %+v, want 1 message in since after %v", tu, first.Since)
+		}
+		return ok
+	})
+}
+
+func TestTenantLimits(t *testing.T) {
+	s, url, conns := startServer(t,
+		simplewebsockets.WithTenantResolver(tenantFromQuery),
+		simplewebsockets.WithTenantLimits(simplewebsockets.TenantLimits{MaxConnections: 2, MaxMessagesPerSecond: 5}))
+	handled := make(chan string, 16)
+	s.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) {
+		handled <- c.Tenant().ID() + ": " + string(msg)
+	})
+
+	dial := func(tenant string) (*simplewebsockets.Connection, *simplewebsockets.Connection) {
+		t.Helper()
+		c, err := dialTenant(t, url, tenant)
+		if err != nil {
+			t.Fatalf("dial tenant %s: %v", tenant, err)
+		}
+		t.Cleanup(func() { c.Close(1000, "") })
+		return c, <-conns
+	}
+	a1, a1Server := dial("a")
+	dial("a")
+	if _, err := dialTenant(t, url, "a"); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("third connection of tenant a: %v, want a 503", err)
+	}
+	b, _ := dial("b")
+
+	// tenants only reach their own connections
+	if res := s.Tenant("a").Broadcast(simplewebsockets.TextMessage, []byte("to a")); res.Sent != 2 {
+		t.Errorf("tenant a's broadcast %+v, want it sent to its 2 connections", res)
+	}
+
+	// flooding uses up tenant a's message budget, b keeps its own
+	for i := 0; i < 20; i++ {
+		if a1.SendTextMessage("flood") != nil {
+			break
+		}
+	}
+	if !waitDone(a1Server.Context(), 5*time.Second) {
+		t.Fatal("flooding connection wasn't closed")
+	}
+	if d, _ := a1Server.Disposition(); !errors.Is(d.Err, simplewebsockets.ErrMessageRateLimited) {
+		t.Errorf("disposition %+v, want ErrMessageRateLimited", d)
+	}
+	b.SendTextMessage("still fine")
+	for {
+		select {
+		case msg := <-handled:
+			if msg == "b: still fine" {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("tenant b's message wasn't handled after a was rate limited")
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package simplewebsockets
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Sends the state of registered rooms to their members at a fixed tick rate, for game servers that run a
+// simulation loop. Members first get a "tick.snapshot" event with the full state, then a "tick.patch" event
+// with a JSON merge patch (see StateRoom) for every tick that changed it. Ticks go through the send queue,
+// so WithSendQueue is required: a client that hasn't received the previous tick yet has it replaced by a
+// fresh snapshot instead of falling further behind.
+type TickBroadcaster struct {
+	server   *Server
+	interval time.Duration
+
+	mx    sync.Mutex // serializes ticks and registrations
+	rooms map[*Room]*tickRoom
+	tick  uint64
+	stop  chan struct{}
+}
+
+type tickRoom struct {
+	snapshot func() any
+	state    any                  // state sent in the previous tick, nil before the first tick
+	synced   map[*Connection]bool // members that were sent a snapshot and every patch since
+}
+
+type tickSnapshot struct {
+	Tick  uint64          `json:"tick"`
+	State json.RawMessage `json:"state"`
+}
+
+type tickPatch struct {
+	Tick  uint64 `json:"tick"`
+	Patch any    `json:"patch"`
+}
+
+// Creates a tick broadcaster running every interval once started.
+func NewTickBroadcaster(s *Server, interval time.Duration) *TickBroadcaster {
+	return &TickBroadcaster{server: s, interval: interval, rooms: make(map[*Room]*tickRoom)}
+}
+
+// Broadcasts the value returned by snapshot to the room on every tick. snapshot is called from the tick
+// goroutine and must not block; its result is marshalled as JSON.
+func (t *TickBroadcaster) Register(room *Room, snapshot func() any) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.rooms[room] = &tickRoom{snapshot: snapshot, synced: make(map[*Connection]bool)}
+}
+
+// Stops broadcasting to the room.
+func (t *TickBroadcaster) Unregister(room *Room) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	delete(t.rooms, room)
+}
+
+// Starts ticking on the server's clock. Does nothing if already started.
+func (t *TickBroadcaster) Start() {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	t.stop = stop
+
+	ticker := t.server.clock.NewTicker(t.interval)
+	spawn("tick broadcaster", func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				t.Tick()
+			case <-stop:
+				return
+			}
+		}
+	})
+}
+
+// Stops ticking.
+func (t *TickBroadcaster) Stop() {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.stop != nil {
+		close(t.stop)
+		t.stop = nil
+	}
+}
+
+// Runs a single tick right away, for game loops that step the simulation themselves instead of calling Start.
+func (t *TickBroadcaster) Tick() {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.tick++
+	for room, tr := range t.rooms {
+		if err := t.broadcastRoom(room, tr); err != nil {
+			t.server.emitError(nil, err)
+		}
+	}
+}
+
+// sends the room's state for the current tick, callers must hold mx
+func (t *TickBroadcaster) broadcastRoom(room *Room, tr *tickRoom) error {
+	if t.server.sendQueueSize <= 0 {
+		return ErrNoSendQueue
+	}
+
+	raw, err := json.Marshal(tr.snapshot())
+	if err != nil {
+		return err
+	}
+	var state any
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return err
+	}
+
+	full, err := marshalEvent("tick.snapshot", tickSnapshot{Tick: t.tick, State: raw})
+	if err != nil {
+		return err
+	}
+	var delta []byte
+	if patch, changed := mergePatch(tr.state, state); changed && tr.state != nil {
+		if delta, err = marshalEvent("tick.patch", tickPatch{Tick: t.tick, Patch: patch}); err != nil {
+			return err
+		}
+	}
+	tr.state = state
+
+	// every room has its own slot in a member's queue
+	key := "tick:" + room.name
+	members := make(map[*Connection]bool)
+	for _, c := range room.Members() {
+		members[c] = true
+
+		data := delta
+		if !tr.synced[c] || (c.queue != nil && c.queue.pending(key)) {
+			// the member is new or missed the previous tick, a patch would apply to the wrong state
+			data = full
+		}
+		if data == nil {
+			continue // nothing changed
+		}
+
+		err := c.Enqueue(TextMessage, data, ConflationKey(key))
+		tr.synced[c] = err == nil
+		if err != nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, ErrSendQueueFull) && !errors.Is(err, ErrReadOnly) {
+			t.server.emitError(c, err)
+		}
+	}
+
+	for c := range tr.synced {
+		if !members[c] {
+			delete(tr.synced, c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package simplewebsockets_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+type tickEvent struct {
+	Tick  uint64
+	State map[string]any
+	Patch map[string]any
+}
+
+func expectTick(t *testing.T, c *wstest.SimClient, name string, want tickEvent) {
+	t.Helper()
+	var got tickEvent
+	nextEvent(t, c, name, &got)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s %+v, want %+v", name, got, want)
+	}
+}
+
+func TestTickBroadcaster(t *testing.T) {
+	sim, c, sc := queueClient(t)
+	room := sim.Server.Room("match")
+	if err := room.Join(sc); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	state := map[string]any{"x": 0, "y": 0}
+	ticks := simplewebsockets.NewTickBroadcaster(sim.Server, 50*time.Millisecond)
+	ticks.Register(room, func() any { return state })
+
+	ticks.Tick()
+	expectTick(t, c, "tick.snapshot", tickEvent{Tick: 1, State: map[string]any{"x": 0.0, "y": 0.0}})
+	state["x"] = 1
+	ticks.Tick()
+	expectTick(t, c, "tick.patch", tickEvent{Tick: 2, Patch: map[string]any{"x": 1.0}})
+
+	// unchanged ticks send nothing, a slow client gets the latest snapshot instead of every patch
+	ticks.Tick()
+	release := holdWriter(t, sc)
+	enqueue(t, sc, "blocking")
+	state["x"] = 2
+	ticks.Tick()
+	state["y"] = 1
+	ticks.Tick()
+	release()
+	expectMessages(t, c, "held", "blocking")
+	expectTick(t, c, "tick.snapshot", tickEvent{Tick: 5, State: map[string]any{"x": 2.0, "y": 1.0}})
+
+	state["y"] = 2
+	ticks.Tick()
+	expectTick(t, c, "tick.patch", tickEvent{Tick: 6, Patch: map[string]any{"y": 2.0}})
+}
+
+func TestTickBroadcasterNeedsSendQueue(t *testing.T) {
+	sim := wstest.NewSimulation(1998)
+	errs := make(chan error, 1)
+	sim.Server.OnError(func(c *simplewebsockets.Connection, err error) { errs <- err })
+	ticks := simplewebsockets.NewTickBroadcaster(sim.Server, 50*time.Millisecond)
+	ticks.Register(sim.Server.Room("match"), func() any { return 1 })
+	ticks.Tick()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, simplewebsockets.ErrNoSendQueue) {
+			t.Errorf("tick failed with %v, want ErrNoSendQueue", err)
+		}
+	default:
+		t.Error("tick without a send queue reported no error")
+	}
+}
@@ -0,0 +1,147 @@
+package simplewebsockets
+
+import (
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Setter to be passed into the creation of a server. The server serves wss:// with config, both through
+// Listen and ListenTLS. config needs certificates unless ListenTLS is given certificate files.
+func WithTLSConfig(config *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.tlsConfig = config
+	}
+}
+
+// Like Listen, but serves wss:// without a separate TLS terminating proxy. The certificate and key files are
+// added to the TLS config from WithTLSConfig, they can be empty if that config already has certificates.
+func (s *Server) ListenTLS(address string, certFile string, keyFile string) error {
+	if err := s.BindTLS(address, certFile, keyFile); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Like Bind for ListenTLS. Follow with Serve.
+func (s *Server) BindTLS(address string, certFile string, keyFile string) error {
+	config := s.tlsConfig.Clone()
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return errors.New("no TLS certificate configured")
+	}
+	return s.bind(address, config)
+}
+
+// Wraps accepted connections in TLS and records the client hello of each for its JA3 fingerprint.
+type tlsListener struct {
+	net.Listener
+	config *tls.Config
+	conns  sync.Map // raw net.Conn -> *tlsConn, until the client hello arrived
+}
+
+func newTLSListener(ln net.Listener, config *tls.Config) *tlsListener {
+	l := &tlsListener{Listener: ln}
+	l.config = config.Clone()
+	next := config.GetConfigForClient
+	l.config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if c, ok := l.conns.LoadAndDelete(hello.Conn); ok {
+			c.(*tlsConn).ja3 = ja3(hello)
+		}
+		if next != nil {
+			return next(hello)
+		}
+		return nil, nil
+	}
+	return l
+}
+
+func (l *tlsListener) Accept() (net.Conn, error) {
+	raw, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	c := &tlsConn{raw: raw, listener: l}
+	l.conns.Store(raw, c)
+	c.Conn = tls.Server(raw, l.config)
+	return c, nil
+}
+
+// A server side TLS connection that knows its client's JA3 fingerprint.
+type tlsConn struct {
+	*tls.Conn
+	raw      net.Conn
+	listener *tlsListener
+	ja3      string // set during the handshake, which runs on the goroutine reading the upgrade request
+}
+
+// Returns the JA3 hash of the client hello, "" before the TLS handshake. See ClientFingerprint.
+func (c *tlsConn) JA3() string {
+	return c.ja3
+}
+
+func (c *tlsConn) Close() error {
+	c.listener.conns.Delete(c.raw) // in case the handshake never got to the client hello
+	return c.Conn.Close()
+}
+
+// Computes the JA3 hash (MD5 of version, ciphers, extensions, curves and point formats) of a client hello.
+// GREASE values are skipped, and the version is the legacy one TLS 1.3 clients send as well.
+func ja3(hello *tls.ClientHelloInfo) string {
+	version := uint16(0)
+	for _, v := range hello.SupportedVersions {
+		if !isGrease(v) {
+			version = max(version, v)
+		}
+	}
+	version = min(version, tls.VersionTLS12)
+
+	curves := make([]uint16, len(hello.SupportedCurves))
+	for i, c := range hello.SupportedCurves {
+		curves[i] = uint16(c)
+	}
+	points := make([]uint16, len(hello.SupportedPoints))
+	for i, p := range hello.SupportedPoints {
+		points[i] = uint16(p)
+	}
+
+	s := strings.Join([]string{
+		strconv.Itoa(int(version)),
+		ja3List(hello.CipherSuites),
+		ja3List(hello.Extensions),
+		ja3List(curves),
+		ja3List(points),
+	}, ",")
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// joins values with dashes, skipping GREASE values
+func ja3List(values []uint16) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		if !isGrease(v) {
+			parts = append(parts, strconv.Itoa(int(v)))
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// reports whether v is a GREASE value (RFC 8701), 0x?a?a with equal bytes
+func isGrease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
@@ -0,0 +1,84 @@
+package simplewebsockets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+)
+
+// Setter to be passed into the creation of a server. Gives every inbound data message a trace ID: the one
+// extract finds in the message (see EnvelopeTraceID), or a random one if extract is nil or returns "".
+// The ID is in the context handed to OnMessageCtx handlers (see TraceID), in MessageEvent.TraceID, and
+// attached as a TraceError to the errors reported for the message.
+func WithTraceIDs(extract func(mt MessageType, data []byte) string) ServerOption {
+	return func(s *Server) {
+		s.tracing = true
+		s.traceExtractor = extract
+	}
+}
+
+// Extracts the "trace_id" field of a JSON event envelope (see Event), for WithTraceIDs.
+func EnvelopeTraceID(mt MessageType, data []byte) string {
+	if mt != TextMessage {
+		return ""
+	}
+	var e struct {
+		TraceID string `json:"trace_id"`
+	}
+	if json.Unmarshal(data, &e) != nil {
+		return ""
+	}
+	return e.TraceID
+}
+
+// Returns the trace ID of the message a handler context belongs to, "" without WithTraceIDs.
+func TraceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// An error reported for a traced message, carrying the message's trace ID.
+type TraceError struct {
+	TraceID string
+	Err     error
+}
+
+func (e *TraceError) Error() string {
+	return fmt.Sprintf("%v (trace %s)", e.Err, e.TraceID)
+}
+
+func (e *TraceError) Unwrap() error {
+	return e.Err
+}
+
+type traceIDKey struct{}
+
+// Returns the trace ID of an inbound message, "" if tracing is off.
+func (s *Server) traceID(mt MessageType, data []byte) string {
+	if !s.tracing {
+		return ""
+	}
+	if s.traceExtractor != nil {
+		if id := s.traceExtractor(mt, data); id != "" {
+			return id
+		}
+	}
+	return fmt.Sprintf("%016x%016x", rand.Uint64(), rand.Uint64())
+}
+
+// attaches a trace ID to err, if there is one
+func withTrace(err error, trace string) error {
+	if trace == "" {
+		return err
+	}
+	return &TraceError{TraceID: trace, Err: err}
+}
+
+// Returns the context for handling a message with the given trace ID.
+func (c *Connection) messageContext(trace string) context.Context {
+	if trace == "" {
+		return c.ctx
+	}
+	return context.WithValue(c.ctx, traceIDKey{}, trace)
+}
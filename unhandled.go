@@ -0,0 +1,135 @@
+package simplewebsockets
+
+import (
+	"context"
+	"errors"
+)
+
+// Most messages held for a connection without an OnMessage handler under UnhandledBuffer. The
+// connection is closed when more arrive before a handler is set.
+const maxUnhandledMessages = 64
+
+// What happens to data messages that arrive while a connection has no OnMessage handler.
+type UnhandledPolicy int
+
+const (
+	UnhandledDrop   UnhandledPolicy = iota // discard the message (default)
+	UnhandledBuffer                        // hold messages until a handler is set with SetOnMessage
+	UnhandledClose                         // close the connection with 1011
+)
+
+// Returned when a connection is closed because a message arrived without a handler to receive it.
+var ErrNoMessageHandler = errors.New("no message handler")
+
+// Setter to be passed into the creation of a server. Every message arriving without a handler is counted
+// in Metrics().Messages.Unhandled whatever the policy, so misconfigured endpoints show up.
+func WithUnhandledPolicy(policy UnhandledPolicy) ServerOption {
+	return func(s *Server) {
+		s.unhandledPolicy = policy
+	}
+}
+
+// Sets the message handler of the connection. Messages buffered under UnhandledBuffer are handed to fn,
+// in the order they arrived, before any newer message. Unlike assigning OnMessage directly this is safe
+// to call while the connection is being served.
+func (c *Connection) SetOnMessage(fn func([]byte)) {
+	c.handlerMx.Lock()
+	c.OnMessage = fn
+	c.flushUnhandled(fn != nil)
+}
+
+// Sets a message handler that is also told whether each message is text or binary. It takes precedence
+// over OnMessage and is set like SetOnMessage sets that, nil removes it again.
+func (c *Connection) OnMessageTyped(fn func(mt MessageType, data []byte)) {
+	c.handlerMx.Lock()
+	c.onTyped = fn
+	c.flushUnhandled(fn != nil)
+}
+
+// Hands the messages buffered under UnhandledBuffer to a handler that was just set. Called with handlerMx
+// held, which it releases.
+func (c *Connection) flushUnhandled(set bool) {
+	if c.flushing || !set {
+		c.handlerMx.Unlock()
+		return
+	}
+
+	// the read loop queues behind us while flushing so ordering is kept
+	c.flushing = true
+	for len(c.unhandled) > 0 {
+		msg := c.unhandled[0]
+		c.unhandled = c.unhandled[1:]
+		handler := c.messageHandler()
+		c.handlerMx.Unlock()
+		if handler.set() {
+			c.server.dispatch(c, handler, msg.mt, msg.data, msg.trace)
+		}
+		c.handlerMx.Lock()
+	}
+	c.unhandled = nil
+	c.flushing = false
+	c.handlerMx.Unlock()
+}
+
+// A message held under UnhandledBuffer.
+type unhandledMessage struct {
+	mt    MessageType
+	data  []byte
+	trace string
+}
+
+// The handler messages of a connection go to: its OnMessageTyped or OnMessage handler, or else the
+// server's OnMessageCtx.
+type messageHandler struct {
+	typedFn func(MessageType, []byte)
+	fn      func([]byte)
+	ctxFn   func(context.Context, *Connection, []byte)
+}
+
+func (h messageHandler) set() bool {
+	return h.typedFn != nil || h.fn != nil || h.ctxFn != nil
+}
+
+// Returns the connection's current handler. Callers must hold handlerMx.
+func (c *Connection) messageHandler() messageHandler {
+	switch {
+	case c.onTyped != nil:
+		return messageHandler{typedFn: c.onTyped}
+	case c.OnMessage != nil:
+		return messageHandler{fn: c.OnMessage}
+	}
+	return messageHandler{ctxFn: c.server.onMessageCtx}
+}
+
+// Hands a message to the connection's handler, applying the server's unhandled policy if there is none.
+func (s *Server) handleMessage(c *Connection, mt MessageType, data []byte, trace string) error {
+	c.handlerMx.Lock()
+	if inbox := c.inbox; inbox != nil {
+		c.handlerMx.Unlock()
+		c.pushMessage(inbox, mt, data)
+		return nil
+	}
+	handler := c.messageHandler()
+	if handler.set() && !c.flushing {
+		c.handlerMx.Unlock()
+		s.dispatch(c, handler, mt, data, trace)
+		return nil
+	}
+	defer c.handlerMx.Unlock()
+
+	if !handler.set() {
+		s.metrics.unhandledMessages.Add(1)
+	}
+
+	switch {
+	case c.flushing || s.unhandledPolicy == UnhandledBuffer:
+		if !handler.set() && len(c.unhandled) >= maxUnhandledMessages {
+			return c.closeWithError(ErrNoMessageHandler, 1011, "No message handler")
+		}
+		// the read loop reuses its message buffer
+		c.unhandled = append(c.unhandled, unhandledMessage{mt, append([]byte(nil), data...), trace})
+	case s.unhandledPolicy == UnhandledClose:
+		return c.closeWithError(ErrNoMessageHandler, 1011, "No message handler")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package simplewebsockets_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+	"github.com/CarsonKiibi/simplewebsockets/wstest"
+)
+
+// Connects a simulated client to a server without message handlers, returning the server's end.
+func unhandledClient(t *testing.T, policy simplewebsockets.UnhandledPolicy) (*wstest.Simulation, *wstest.SimClient, *simplewebsockets.Connection) {
+	t.Helper()
+	sim := wstest.NewSimulation(1972, simplewebsockets.WithUnhandledPolicy(policy))
+	conns := make(chan *simplewebsockets.Connection, 1)
+	sim.Server.OnConnect(func(c *simplewebsockets.Connection) { conns <- c })
+	c, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(c.Abort)
+	return sim, c, <-conns
+}
+
+// Sends the messages, then waits until the server read them by pinging it.
+func sendAndSync(t *testing.T, c *wstest.SimClient, msgs ...string) {
+	t.Helper()
+	for _, msg := range msgs {
+		c.SendText(msg)
+	}
+	c.Ping(nil)
+	if !frameWithin(c, 0xA, 5*time.Second) {
+		t.Fatal("no pong")
+	}
+}
+
+func handlerReceives(t *testing.T, sc *simplewebsockets.Connection, c *wstest.SimClient, before []string, after string, want []string) {
+	t.Helper()
+	got := make(chan string, 16)
+	sendAndSync(t, c, before...)
+	sc.SetOnMessage(func(msg []byte) { got <- string(msg) })
+	c.SendText(after)
+	for _, w := range want {
+		select {
+		case msg := <-got:
+			if msg != w {
+				t.Fatalf("handler got %q, want %q", msg, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("handler didn't get %q", w)
+		}
+	}
+}
+
+func TestUnhandledDrop(t *testing.T) {
+	sim, c, sc := unhandledClient(t, simplewebsockets.UnhandledDrop)
+	handlerReceives(t, sc, c, []string{"early"}, "late", []string{"late"})
+	if n := sim.Server.Metrics().Messages.Unhandled; n != 1 {
+		t.Errorf("%d unhandled messages counted, want 1", n)
+	}
+}
+
+func TestUnhandledBuffer(t *testing.T) {
+	sim, c, sc := unhandledClient(t, simplewebsockets.UnhandledBuffer)
+	handlerReceives(t, sc, c, []string{"first", "second"}, "third", []string{"first", "second", "third"})
+	if n := sim.Server.Metrics().Messages.Unhandled; n != 2 {
+		t.Errorf("%d unhandled messages counted, want 2", n)
+	}
+}
+
+func TestUnhandledBufferLimit(t *testing.T) {
+	_, c, _ := unhandledClient(t, simplewebsockets.UnhandledBuffer)
+	for i := range 64 {
+		c.SendText(fmt.Sprint(i))
+	}
+	c.SendText("one too many")
+	if r := nextReply(t, c); r.closeCode != 1011 {
+		t.Errorf("server replied %+v, want a close with 1011", r)
+	}
+}
+
+func TestUnhandledClose(t *testing.T) {
+	sim, c, _ := unhandledClient(t, simplewebsockets.UnhandledClose)
+	c.SendText("nobody listens")
+	if r := nextReply(t, c); r.closeCode != 1011 {
+		t.Errorf("server replied %+v, want a close with 1011", r)
+	}
+	if n := sim.Server.Metrics().Messages.Unhandled; n != 1 {
+		t.Errorf("%d unhandled messages counted, want 1", n)
+	}
+}
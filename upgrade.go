@@ -0,0 +1,118 @@
+package simplewebsockets
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Upgrades a request received by a net/http server, so websocket endpoints can be registered on an existing
+// mux next to other routes and run behind its middleware. If the upgrade fails an error response has been
+// sent and the error is returned. Otherwise the connection is served in its own goroutine exactly like one
+// accepted by Listen, and OnConnect runs for it. Pass its handler as an option (e.g. WithMessageHandler or
+// WithPullReads): messages may arrive before a handler set on the returned connection, and go to the server's
+// unhandled policy then.
+func (s *Server) Upgrade(w http.ResponseWriter, r *http.Request, opts ...ConnOption) (*Connection, error) {
+	if err := s.checkAccepting(); err != nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return nil, err
+	}
+
+	// look for proxies that mangled the upgrade
+	if s.handshakeDiagnostics {
+		if err := detectInterference(r); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return nil, err
+		}
+	}
+
+	key, err := validateUpgradeRequest(r, nil)
+	if err != nil {
+		status, header := upgradeRejection(err)
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		http.Error(w, http.StatusText(status), status)
+		return nil, err
+	}
+
+	if err := s.checkRequestOrigin(r); err != nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return nil, err
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil, errors.New("response writer does not support hijacking")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	if brw.Reader.Buffered() > 0 {
+		// clients must wait for the 101, see readHandshakeRequest
+		rejectHandshake(conn, http.StatusBadRequest)
+		return nil, fmt.Errorf("unexpected bytes after the upgrade request (body or pipelined request)")
+	}
+
+	// drop the http server's deadlines, the handshake timeout covers the rest of the handshake
+	conn.SetDeadline(time.Time{})
+	handshakeTimer := expireAfter(s.clock, conn, s.handeshakeTimeout)
+
+	var fp *ClientFingerprint
+	if s.fingerprinter != nil {
+		if fp, err = s.checkFingerprint(conn, r, nil); err != nil {
+			rejectHandshake(conn, http.StatusForbidden)
+			return nil, err
+		}
+	}
+
+	upgradeHeader, status, err := s.checkUpgrading(r)
+	if err != nil {
+		rejectHandshakeWithHeader(conn, status, upgradeHeader)
+		return nil, err
+	}
+
+	tenantID := ""
+	if s.tenantResolver != nil {
+		tenantID = s.tenantResolver(r)
+	}
+
+	c := s.newConnection(conn)
+	c.request = r
+	c.inheritContext(r.Context())
+	c.fingerprint = fp
+	extensions := s.negotiateExtensions(c, r)
+	if err := s.negotiateSubprotocol(c, r); err != nil {
+		rejectHandshake(conn, http.StatusBadRequest)
+		return nil, err
+	}
+
+	if err := s.admit(c); err != nil {
+		rejectUnadmitted(conn, err)
+		return nil, err
+	}
+	if !s.attachTenant(c, tenantID) {
+		s.release(c)
+		rejectHandshake(conn, http.StatusServiceUnavailable)
+		return nil, fmt.Errorf("tenant %q is at its connection limit", tenantID)
+	}
+
+	if err := s.performServerHandshake(conn, []byte(key), extensions, c.subprotocol, upgradeHeader); err != nil {
+		s.detachTenant(c)
+		s.release(c)
+		conn.Close()
+		return nil, err
+	}
+
+	handshakeTimer.Stop()
+	conn.SetDeadline(time.Time{})
+
+	for _, opt := range opts {
+		opt(c)
+	}
+	spawn("connection", func() { s.serve(c) })
+	return c, nil
+}
@@ -0,0 +1,56 @@
+package simplewebsockets
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Matched (with errors.Is) by the error returned for upgrades rejected by OnUpgrading.
+var ErrUpgradeRejected = errors.New("upgrade rejected")
+
+// OnUpgrading is called with every upgrade request that passed the server's own checks, before the 101 is sent.
+// Returning false rejects the upgrade with status (403 if 0) and headers, e.g. a 401 with WWW-Authenticate or a
+// 429 with Retry-After, instead of accepting the connection only to close it. Headers returned along with true
+// are added to the 101 response. Call it before the server starts accepting connections.
+func (s *Server) OnUpgrading(fn func(r *http.Request) (allow bool, status int, headers http.Header)) {
+	s.onUpgrading = fn
+}
+
+// Runs the OnUpgrading hook. Returns the headers to answer with, and the status and error for rejected upgrades.
+func (s *Server) checkUpgrading(r *http.Request) (http.Header, int, error) {
+	if s.onUpgrading == nil {
+		return nil, 0, nil
+	}
+	allow, status, headers := s.onUpgrading(r)
+	if allow {
+		return headers, 0, nil
+	}
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+	return headers, status, fmt.Errorf("%w with status %d", ErrUpgradeRejected, status)
+}
+
+// Checks that the server takes new connections at all, rejections are answered with a 503.
+func (s *Server) checkAccepting() error {
+	if s.shuttingDown.Load() {
+		return ErrServerClosed
+	}
+	if s.ShedLevel() >= ShedUpgrades {
+		s.metrics.shedUpgrades.Add(1)
+		return ErrLoadShed
+	}
+	return nil
+}
+
+// Runs the origin and CSRF checks on an upgrade request, rejections are answered with a 403.
+func (s *Server) checkRequestOrigin(r *http.Request) error {
+	if err := s.checkOrigin(r); err != nil {
+		return err
+	}
+	if s.checkOrigins {
+		return s.checkCSRF(r)
+	}
+	return nil
+}
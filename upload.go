@@ -0,0 +1,236 @@
+package simplewebsockets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+// Returned by Upload.Wait when the message grew past UploadOptions.MaxSize.
+var ErrUploadTooLarge = errors.New("upload exceeds maximum size")
+
+// Options for Connection.ReceiveUpload.
+type UploadOptions struct {
+	MaxSize  int64                // largest accepted message in bytes, 0 for no limit
+	Progress func(received int64) // called after every frame written
+}
+
+// A message being streamed into an io.Writer.
+type Upload struct {
+	w    io.Writer
+	opts UploadOptions
+
+	received int64
+	done     chan struct{}
+	once     sync.Once
+	err      error
+
+	// set for the messages behind NextReader
+	msg      *streamMessage
+	mt       MessageType
+	utf8Tail []byte      // incomplete rune at the end of the text received so far
+	sum      hash.Hash32 // nil unless the checksum envelope applies
+	sumTail  []byte      // last 4 bytes received, held back as they may be the checksum
+}
+
+// Streams the next binary message received on the connection into w, frame by frame, instead of
+// buffering it and passing it to OnMessage. Only one frame is held in memory at a time, so uploads
+// can be much larger than maxMessageSize. Call it before the peer starts sending, e.g. from the
+// OnMessage handler of the message announcing the upload. The checksum envelope is not applied to uploads.
+// Uploads count against the message rate limits, but skip the validator, replay protection, trace IDs and
+// MessageEvent, which need the message in one piece.
+func (c *Connection) ReceiveUpload(w io.Writer, opts UploadOptions) (*Upload, error) {
+	if c.Mode() == ModeWriteOnly {
+		return nil, fmt.Errorf("can't receive uploads on a write-only connection")
+	}
+
+	u := &Upload{w: w, opts: opts, done: make(chan struct{})}
+	if err := c.setPendingUpload(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (c *Connection) setPendingUpload(u *Upload) error {
+	c.uploadMx.Lock()
+	defer c.uploadMx.Unlock()
+
+	if c.pendingUpload != nil {
+		return fmt.Errorf("an upload is already pending on this connection")
+	}
+	c.pendingUpload = u
+	return nil
+}
+
+// Closed when the upload completed or failed.
+func (u *Upload) Done() <-chan struct{} {
+	return u.done
+}
+
+// Blocks until the upload finished and returns the number of bytes written.
+func (u *Upload) Wait() (int64, error) {
+	<-u.done
+	return u.received, u.err
+}
+
+func (u *Upload) finish(err error) {
+	u.once.Do(func() {
+		u.err = err
+		if u.msg != nil {
+			u.msg.finish(err) // nil gives the reader io.EOF
+		}
+		close(u.done)
+	})
+}
+
+// takes the upload for a new message, called from the read loop when its first frame arrives. A pending upload
+// (ReceiveUpload) only takes binary messages. Once NextReader was called, other messages are streamed to its
+// inbox, unless the server inspects whole messages: those are reassembled and go through the validator,
+// replay protection, tracing and MessageEvent like any other message before NextReader gets them.
+func (c *Connection) startUpload(mt MessageType) bool {
+	c.uploadMx.Lock()
+	u := c.pendingUpload
+	if u != nil && mt == BinaryMessage {
+		c.pendingUpload = nil
+	} else {
+		u = nil
+	}
+	c.uploadMx.Unlock()
+
+	if u == nil {
+		if c.server.inspectsMessages() {
+			return false
+		}
+		m := c.streams.begin(mt)
+		if m == nil {
+			return false
+		}
+		u = &Upload{w: m, opts: UploadOptions{MaxSize: c.maxSize.Load()}, done: make(chan struct{}), msg: m}
+		if mt == BinaryMessage {
+			u.sum = c.checksum.newHash()
+		}
+	}
+	c.activeUpload = u
+	u.mt = mt
+	return true
+}
+
+// reports whether anything needs a message in one piece before the application gets it
+func (s *Server) inspectsMessages() bool {
+	return s.validator != nil || s.replayVerify != nil || s.tracing || hasSubscribers[MessageEvent](s.events)
+}
+
+// writes a frame of the active upload, closing the connection if it can't be stored
+func (c *Connection) writeUploadFrame(fr *Frame) error {
+	u := c.activeUpload
+
+	if u.opts.MaxSize > 0 && u.received+int64(len(fr.Payload)) > u.opts.MaxSize {
+		c.activeUpload = nil
+		u.finish(ErrUploadTooLarge)
+		return c.closeWithError(ErrUploadTooLarge, 1009, "Upload too large")
+	}
+
+	if u.mt == TextMessage && c.server.validateUTF8 && !u.validUTF8(fr.Payload, fr.FIN) {
+		c.activeUpload = nil
+		u.finish(ErrInvalidUTF8)
+		return c.closeWithError(ErrInvalidUTF8, 1007, "Invalid UTF-8")
+	}
+
+	payload := fr.Payload
+	if u.sum != nil {
+		payload = u.holdChecksum(payload)
+	}
+	n, err := u.w.Write(payload)
+	u.received += int64(n)
+	if err != nil {
+		c.activeUpload = nil
+		u.finish(err)
+		return c.closeWithError(err, 1011, "Upload failed")
+	}
+
+	if u.opts.Progress != nil {
+		u.opts.Progress(u.received)
+	}
+
+	if fr.FIN {
+		c.activeUpload = nil
+		c.countMessageIn(u.received)
+		if err := u.checkSum(); err != nil {
+			u.finish(err)
+			c.server.emitError(c, err)
+			return nil
+		}
+		u.finish(nil)
+	}
+	return nil
+}
+
+// Holds back the last 4 bytes received so far, the checksum if the message ends there, and returns the
+// payload before them.
+func (u *Upload) holdChecksum(p []byte) []byte {
+	data := append(u.sumTail, p...)
+	cut := max(len(data)-4, 0)
+	u.sumTail = bytes.Clone(data[cut:])
+	u.sum.Write(data[:cut])
+	return data[:cut]
+}
+
+// verifies the checksum held back at the end of the message, like ChecksumAlgorithm.open does for buffered ones
+func (u *Upload) checkSum() error {
+	if u.sum == nil {
+		return nil
+	}
+	if len(u.sumTail) < 4 {
+		return &ChecksumError{Short: true}
+	}
+	expected, actual := binary.BigEndian.Uint32(u.sumTail), u.sum.Sum32()
+	if expected != actual {
+		return &ChecksumError{Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// Validates the next part of a streamed text message. An incomplete rune at the end of p is carried over
+// to the next part, unless this is the last one.
+func (u *Upload) validUTF8(p []byte, fin bool) bool {
+	data := append(u.utf8Tail, p...)
+	cut := len(data)
+	if !fin {
+		for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax+1; i-- {
+			if utf8.RuneStart(data[i]) {
+				if !utf8.FullRune(data[i:]) {
+					cut = i
+				}
+				break
+			}
+		}
+	}
+	if !utf8.Valid(data[:cut]) {
+		return false
+	}
+	u.utf8Tail = append(u.utf8Tail[:0], data[cut:]...)
+	return true
+}
+
+// fails uploads that can no longer complete because the connection went away
+func (c *Connection) abortUploads() {
+	err := fmt.Errorf("connection closed during upload")
+
+	c.uploadMx.Lock()
+	pending := c.pendingUpload
+	c.pendingUpload = nil
+	c.uploadMx.Unlock()
+
+	if pending != nil {
+		pending.finish(err)
+	}
+	if u := c.activeUpload; u != nil {
+		u.finish(err)
+	}
+	c.streams.end()
+}
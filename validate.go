@@ -0,0 +1,35 @@
+package simplewebsockets
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Wraps the error returned by a message validator.
+var ErrInvalidMessage = errors.New("invalid message")
+
+// Setter to be passed into the creation of a server. fn checks every inbound data message before it is
+// published or passed to OnMessage, e.g. against a JSON schema. Rejected messages are dropped and counted
+// in Metrics().Messages.Invalid. A nonzero closeCode also closes the connection with it, usually 1007 or
+// 1008, 0 keeps it open. Codes that may not be sent (see IsValidCloseCode) close with 1008.
+func WithMessageValidator(fn func(mt MessageType, data []byte) error, closeCode uint16) ServerOption {
+	return func(s *Server) {
+		if closeCode != 0 && !IsValidCloseCode(closeCode) {
+			closeCode = 1008
+		}
+		s.validator = fn
+		s.invalidCloseCode = closeCode
+	}
+}
+
+// Drops a message that failed validation and closes the connection if configured to.
+func (s *Server) rejectMessage(c *Connection, err error) error {
+	s.metrics.invalidMessages.Add(1)
+	err = fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+
+	if s.invalidCloseCode == 0 {
+		s.emitError(c, err)
+		return nil
+	}
+	return c.closeWithError(err, s.invalidCloseCode, "Invalid message")
+}
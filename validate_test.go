@@ -0,0 +1,43 @@
+package simplewebsockets_test
+
+import (
+	"errors"
+	"testing"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+func TestMessageValidatorCloseCode(t *testing.T) {
+	rejectBad := func(mt simplewebsockets.MessageType, data []byte) error {
+		if string(data) == "bad" {
+			return errors.New("rejected")
+		}
+		return nil
+	}
+
+	tests := []struct {
+		closeCode uint16
+		want      uint16 // 0 if the connection stays open
+	}{
+		{0, 0},
+		{1007, 1007},
+		{1008, 1008},
+		{4001, 4001},
+		{1005, 1008}, // reserved, never sent
+		{1006, 1008},
+		{1015, 1008},
+		{999, 1008},
+	}
+	for _, tt := range tests {
+		_, c := echoClient(t, simplewebsockets.WithMessageValidator(rejectBad, tt.closeCode))
+		c.SendText("bad")
+		c.SendText("good")
+		r := nextReply(t, c)
+		switch {
+		case tt.want == 0 && r.echo != "good":
+			t.Errorf("close code %d: server replied %+v, want the next message echoed", tt.closeCode, r)
+		case tt.want != 0 && r.closeCode != tt.want:
+			t.Errorf("close code %d: server replied %+v, want a close with %d", tt.closeCode, r, tt.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package simplewebsockets
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// A bidirectional WebTransport stream as provided by HTTP/3 libraries (e.g. webtransport-go's Stream).
+//
+// EXPERIMENTAL: the WebTransport adapter may change without notice.
+type WebTransportStream interface {
+	io.Reader
+	io.Writer
+	io.Closer
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// Serves a websocket style Connection over a bidirectional WebTransport stream, so existing handlers keep
+// working when moving from WebSocket to WebTransport. The HTTP/3 session is established by the caller's
+// library, so there is no upgrade handshake: r is the CONNECT request of the session and is used for tenant
+// attribution and the remote address. Frames on the stream use the regular websocket framing.
+// Blocks until the connection ends. Sessions are admitted like upgrades, by the shutdown and load shedding
+// state, the origin checks, OnUpgrading and the connection limits; a rejected session has its stream closed
+// and the error returned, so the caller can end the session with a fitting status.
+//
+// EXPERIMENTAL: the WebTransport adapter may change without notice.
+func (s *Server) ServeWebTransport(stream WebTransportStream, r *http.Request) error {
+	if err := s.checkAccepting(); err != nil {
+		stream.Close()
+		return err
+	}
+	if err := s.checkRequestOrigin(r); err != nil {
+		stream.Close()
+		return err
+	}
+	if _, _, err := s.checkUpgrading(r); err != nil {
+		stream.Close()
+		return err
+	}
+
+	tenantID := ""
+	if s.tenantResolver != nil {
+		tenantID = s.tenantResolver(r)
+	}
+
+	conn := &streamConn{
+		WebTransportStream: stream,
+		local:              stringAddr(r.Host),
+		remote:             stringAddr(r.RemoteAddr),
+	}
+	c := s.newConnection(conn)
+	c.request = r
+	c.inheritContext(r.Context())
+
+	if err := s.admit(c); err != nil {
+		stream.Close()
+		return err
+	}
+	if !s.attachTenant(c, tenantID) {
+		s.release(c)
+		stream.Close()
+		return fmt.Errorf("tenant %q is at its connection limit", tenantID)
+	}
+
+	s.serve(c)
+	return nil
+}
+
+// adapts a WebTransport stream to net.Conn
+type streamConn struct {
+	WebTransportStream
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// net.Addr for transports that only report addresses as strings
+type stringAddr string
+
+func (a stringAddr) Network() string {
+	return "webtransport"
+}
+
+func (a stringAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,154 @@
+package wscompat_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CarsonKiibi/simplewebsockets/wscompat"
+)
+
+// Serves u on a test server, passing every upgraded connection to handle, and returns its ws:// URL.
+func compatServer(t *testing.T, u *wscompat.Upgrader, handle func(*wscompat.Conn)) string {
+	t.Helper()
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		handle(c)
+	}))
+	t.Cleanup(hs.Close)
+	return "ws" + strings.TrimPrefix(hs.URL, "http")
+}
+
+// The read loop of a typical gorilla handler, echoing every message.
+func echo(c *wscompat.Conn) {
+	defer c.Close()
+	for {
+		mt, msg, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := c.WriteMessage(mt, msg); err != nil {
+			return
+		}
+	}
+}
+
+func dial(t *testing.T, d *wscompat.Dialer, url string, header http.Header) *wscompat.Conn {
+	t.Helper()
+	c, _, err := d.Dial(url, header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return c
+}
+
+func TestEcho(t *testing.T) {
+	url := compatServer(t, &wscompat.Upgrader{}, echo)
+	c := dial(t, wscompat.DefaultDialer, url, nil)
+
+	for _, tt := range []struct {
+		mt  int
+		msg string
+	}{{wscompat.TextMessage, "hello"}, {wscompat.BinaryMessage, "\x00\x01"}} {
+		if err := c.WriteMessage(tt.mt, []byte(tt.msg)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		mt, msg, err := c.ReadMessage()
+		if err != nil || mt != tt.mt || string(msg) != tt.msg {
+			t.Fatalf("read %d %q (%v), want %d %q", mt, msg, err, tt.mt, tt.msg)
+		}
+	}
+
+	type move struct{ X, Y int }
+	if err := c.WriteJSON(move{1, 2}); err != nil {
+		t.Fatalf("write JSON: %v", err)
+	}
+	var got move
+	if err := c.ReadJSON(&got); err != nil || got != (move{1, 2}) {
+		t.Fatalf("read JSON %+v (%v), want {X:1 Y:2}", got, err)
+	}
+}
+
+func TestSubprotocols(t *testing.T) {
+	negotiated := make(chan string, 1)
+	url := compatServer(t, &wscompat.Upgrader{Subprotocols: []string{"v2", "v1"}}, func(c *wscompat.Conn) {
+		negotiated <- c.Subprotocol()
+		echo(c)
+	})
+	c := dial(t, &wscompat.Dialer{Subprotocols: []string{"v1"}}, url, nil)
+	if c.Subprotocol() != "v1" || <-negotiated != "v1" {
+		t.Errorf("negotiated %q, want v1 on both ends", c.Subprotocol())
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	url := compatServer(t, &wscompat.Upgrader{}, echo)
+	if _, _, err := wscompat.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example"}}); err == nil {
+		t.Error("default origin check let a foreign origin connect")
+	}
+	host := strings.TrimPrefix(url, "ws://")
+	dial(t, wscompat.DefaultDialer, url, http.Header{"Origin": {"http://" + host}})
+
+	trusting := compatServer(t, &wscompat.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}, echo)
+	dial(t, wscompat.DefaultDialer, trusting, http.Header{"Origin": {"https://evil.example"}})
+}
+
+func TestCloseError(t *testing.T) {
+	errs := make(chan error, 1)
+	url := compatServer(t, &wscompat.Upgrader{}, func(c *wscompat.Conn) {
+		_, _, err := c.ReadMessage()
+		errs <- err
+	})
+	c := dial(t, wscompat.DefaultDialer, url, nil)
+	if err := c.WriteControl(wscompat.CloseMessage, wscompat.FormatCloseMessage(wscompat.CloseGoingAway, "bye"), time.Time{}); err != nil {
+		t.Fatalf("write close: %v", err)
+	}
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server read didn't end")
+	}
+	var ce *wscompat.CloseError
+	if !errors.As(err, &ce) || ce.Code != wscompat.CloseGoingAway || ce.Text != "bye" {
+		t.Fatalf("server read failed with %v, want a *CloseError with 1001 and the reason", err)
+	}
+	if !wscompat.IsCloseError(err, wscompat.CloseNormalClosure, wscompat.CloseGoingAway) {
+		t.Error("IsCloseError didn't match 1001")
+	}
+	if wscompat.IsUnexpectedCloseError(err, wscompat.CloseGoingAway) || !wscompat.IsUnexpectedCloseError(err, wscompat.CloseNormalClosure) {
+		t.Error("IsUnexpectedCloseError doesn't tell expected from unexpected codes")
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	errs := make(chan error, 1)
+	url := compatServer(t, &wscompat.Upgrader{}, func(c *wscompat.Conn) {
+		c.SetReadLimit(8)
+		_, _, err := c.ReadMessage()
+		errs <- err
+	})
+	c := dial(t, wscompat.DefaultDialer, url, nil)
+	c.WriteMessage(wscompat.TextMessage, []byte("sixteen bytes!!!"))
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, wscompat.ErrReadLimit) {
+			t.Errorf("read over the limit failed with %v, want ErrReadLimit", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read over the limit didn't fail")
+	}
+	if _, _, err := c.ReadMessage(); !wscompat.IsCloseError(err, wscompat.CloseMessageTooBig) {
+		t.Errorf("client read %v, want a close with 1009", err)
+	}
+}
@@ -0,0 +1,261 @@
+// Package wscompat mirrors the API of github.com/gorilla/websocket on top of simplewebsockets, so handlers
+// written against gorilla can be moved over by changing an import and migrated to the native API one at a
+// time, or run side by side with gorilla to compare them. Conn.Connection hands out the native connection.
+package wscompat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+// Message types, with gorilla's values.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// Close codes, see RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+	CloseServiceRestart          = 1012
+	CloseTryAgainLater           = 1013
+	CloseTLSHandshake            = 1015
+)
+
+// Returned by the read of a message over the limit set with SetReadLimit.
+var ErrReadLimit = errors.New("websocket: read limit exceeded")
+
+// Returned by reads once the connection ended, with the close code and reason the peer sent.
+// Connections lost without a close frame report CloseAbnormalClosure.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// Reports whether err is a *CloseError with one of codes.
+func IsCloseError(err error, codes ...int) bool {
+	var ce *CloseError
+	if !errors.As(err, &ce) {
+		return false
+	}
+	for _, code := range codes {
+		if ce.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether err is a *CloseError with none of expectedCodes.
+func IsUnexpectedCloseError(err error, expectedCodes ...int) bool {
+	var ce *CloseError
+	return errors.As(err, &ce) && !IsCloseError(err, expectedCodes...)
+}
+
+// Formats the payload of a close message for WriteMessage or WriteControl.
+func FormatCloseMessage(closeCode int, text string) []byte {
+	if closeCode == CloseNoStatusReceived {
+		return []byte{}
+	}
+	return append([]byte{byte(closeCode >> 8), byte(closeCode)}, text...)
+}
+
+// A websocket connection with gorilla's methods. Reads must come from one goroutine at a time, writes are
+// safe from several, unlike gorilla's.
+type Conn struct {
+	c *simplewebsockets.Connection
+}
+
+// Wraps a native connection, e.g. one accepted by a simplewebsockets.Server, so code that still expects
+// a gorilla connection can use it.
+func NewConn(c *simplewebsockets.Connection) *Conn {
+	return &Conn{c: c}
+}
+
+// Returns the native connection, for code already migrated to simplewebsockets.
+func (c *Conn) Connection() *simplewebsockets.Connection {
+	return c.c
+}
+
+// Waits for the next data message and returns a reader for its payload, see Connection.NextReader.
+// Read it to io.EOF before calling again.
+func (c *Conn) NextReader() (messageType int, r io.Reader, err error) {
+	mt, r, err := c.c.NextReader()
+	if err != nil {
+		return 0, nil, c.readError(err)
+	}
+	return int(mt), r, nil
+}
+
+// Waits for the next data message and returns its payload.
+func (c *Conn) ReadMessage() (messageType int, p []byte, err error) {
+	mt, r, err := c.NextReader()
+	if err != nil {
+		return 0, nil, err
+	}
+	p, err = io.ReadAll(r)
+	if errors.Is(err, simplewebsockets.ErrUploadTooLarge) {
+		err = ErrReadLimit
+	}
+	return mt, p, err
+}
+
+// Reads the next message and decodes it as JSON into v.
+func (c *Conn) ReadJSON(v any) error {
+	_, r, err := c.NextReader()
+	if err != nil {
+		return err
+	}
+	err = json.NewDecoder(r).Decode(v)
+	if errors.Is(err, simplewebsockets.ErrUploadTooLarge) {
+		err = ErrReadLimit
+	}
+	return err
+}
+
+// Starts a text or binary message whose payload is written in pieces, see Connection.NextWriter.
+func (c *Conn) NextWriter(messageType int) (io.WriteCloser, error) {
+	return c.c.NextWriter(simplewebsockets.MessageType(messageType))
+}
+
+// Sends a message of any type. Close, ping and pong messages go through WriteControl.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case TextMessage, BinaryMessage:
+		return c.c.SendMessage(simplewebsockets.MessageType(messageType), data)
+	case CloseMessage, PingMessage, PongMessage:
+		return c.WriteControl(messageType, data, time.Time{})
+	}
+	return fmt.Errorf("websocket: unknown message type %d", messageType)
+}
+
+// Encodes v as JSON and sends it as a text message.
+func (c *Conn) WriteJSON(v any) error {
+	return c.c.SendJSON(v)
+}
+
+// Sends a close, ping or pong message. A close message starts the closing handshake, the connection is
+// released once the peer answers or the close times out. The deadline is ignored, control frames are never
+// queued behind data.
+func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	switch messageType {
+	case PingMessage:
+		return c.c.SendPing(data)
+	case PongMessage:
+		return c.c.SendPong(data)
+	case CloseMessage:
+		if len(data) < 2 {
+			return c.c.CloseWithoutStatus()
+		}
+		return c.c.Close(uint16(data[0])<<8|uint16(data[1]), string(data[2:]))
+	}
+	return fmt.Errorf("websocket: %d is not a control message type", messageType)
+}
+
+// Drops the network connection without a closing handshake, like gorilla's Close. Send a close message
+// with WriteControl first for a clean close.
+func (c *Conn) Close() error {
+	return c.c.NetConn().Close()
+}
+
+// Sets the size limit of received messages, see Connection.SetReadLimit. Messages over it close the
+// connection with 1009.
+func (c *Conn) SetReadLimit(limit int64) {
+	c.c.SetReadLimit(limit)
+}
+
+// Sets the deadline of the connection's reads. Once it passes the connection is lost and reads return the
+// timeout error. A zero t clears it.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.c.NetConn().SetReadDeadline(t)
+}
+
+// Sets the deadline of the connection's writes. A write that times out loses the connection. A zero t
+// clears it.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.c.NetConn().SetWriteDeadline(t)
+}
+
+// Sets a function called with the payload of every ping the peer sends. Pings are answered by the
+// library either way, so h doesn't have to send a pong, and its error is ignored. h runs on the
+// connection's read loop rather than inside a read. nil removes it.
+func (c *Conn) SetPingHandler(h func(appData string) error) {
+	c.c.OnPing(controlHandler(h))
+}
+
+// Sets a function called with the payload of every pong the peer sends, e.g. to extend a read deadline.
+// Like SetPingHandler it runs on the read loop and its error is ignored. nil removes it.
+func (c *Conn) SetPongHandler(h func(appData string) error) {
+	c.c.OnPong(controlHandler(h))
+}
+
+func controlHandler(h func(string) error) func([]byte) {
+	if h == nil {
+		return nil
+	}
+	return func(payload []byte) { h(string(payload)) }
+}
+
+// Returns the subprotocol negotiated during the upgrade.
+func (c *Conn) Subprotocol() string {
+	return c.c.Subprotocol()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.c.NetConn().LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.c.NetConn().RemoteAddr()
+}
+
+// Returns the network connection, see Connection.NetConn.
+func (c *Conn) NetConn() net.Conn {
+	return c.c.NetConn()
+}
+
+// Deprecated: use NetConn.
+func (c *Conn) UnderlyingConn() net.Conn {
+	return c.c.NetConn()
+}
+
+// Translates the error a read got from the native connection into gorilla's: a *CloseError once the
+// connection ended, or the network error that lost it.
+func (c *Conn) readError(err error) error {
+	if !errors.Is(err, simplewebsockets.ErrConnectionClosed) {
+		return err
+	}
+	d, ok := c.c.Disposition()
+	if !ok {
+		return err
+	}
+	// gorilla hands out timeouts as they are, e.g. for SetReadDeadline
+	var ne net.Error
+	if errors.As(d.Err, &ne) && ne.Timeout() {
+		return d.Err
+	}
+	return &CloseError{Code: int(d.Code), Text: d.Reason}
+}
@@ -0,0 +1,171 @@
+package wscompat
+
+import (
+	"compress/flate"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+// Upgrades HTTP requests to websocket connections like gorilla's Upgrader, served by a simplewebsockets.Server
+// built from its fields on the first Upgrade. Change the fields before that. HandshakeTimeout, ReadBufferSize,
+// WriteBufferSize and Error are accepted so existing literals compile, but the server's own handshake timeout,
+// buffering and error responses apply.
+type Upgrader struct {
+	HandshakeTimeout time.Duration
+	ReadBufferSize   int
+	WriteBufferSize  int
+	Error            func(w http.ResponseWriter, r *http.Request, status int, reason error)
+
+	// Returns false for requests from origins that may not connect. nil accepts requests without an Origin
+	// header and those whose Origin host matches the request's Host, like gorilla.
+	CheckOrigin func(r *http.Request) bool
+
+	// Subprotocols the server supports, in order of preference.
+	Subprotocols []string
+
+	// Negotiates permessage-deflate with clients that offer it.
+	EnableCompression bool
+
+	// Applied to the server after the fields above, e.g. WithMaxMessageSize or WithKeepalive.
+	Options []simplewebsockets.ServerOption
+
+	once   sync.Once
+	server *simplewebsockets.Server
+}
+
+// response headers of an upgrade, passed to the OnUpgrading hook in the request context
+type responseHeaderKey struct{}
+
+// Upgrades the request, adding responseHeader (e.g. Set-Cookie) to the 101 response. On failure an error
+// response has been sent. The connection is read with ReadMessage or NextReader.
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	u.once.Do(u.newServer)
+	if responseHeader != nil {
+		r = r.WithContext(context.WithValue(r.Context(), responseHeaderKey{}, responseHeader))
+	}
+	// reading with NextReader from the start keeps messages that arrive before the first ReadMessage
+	c, err := u.server.Upgrade(w, r, simplewebsockets.WithStreamReads())
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(c), nil
+}
+
+// Returns the server the Upgrader's connections are served by, e.g. for its metrics or rooms.
+func (u *Upgrader) Server() *simplewebsockets.Server {
+	u.once.Do(u.newServer)
+	return u.server
+}
+
+func (u *Upgrader) newServer() {
+	var opts []simplewebsockets.ServerOption
+	if len(u.Subprotocols) > 0 {
+		opts = append(opts, simplewebsockets.WithSubprotocols(u.Subprotocols...))
+	}
+	if u.EnableCompression {
+		opts = append(opts, simplewebsockets.WithCompression(flate.BestSpeed))
+	}
+	u.server = simplewebsockets.NewServer(append(opts, u.Options...)...)
+
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = checkSameOrigin
+	}
+	u.server.OnUpgrading(func(r *http.Request) (bool, int, http.Header) {
+		if !checkOrigin(r) {
+			return false, http.StatusForbidden, nil
+		}
+		header, _ := r.Context().Value(responseHeaderKey{}).(http.Header)
+		return true, 0, header
+	})
+}
+
+// gorilla's default origin check
+func checkSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// Reports whether the request asks for a websocket upgrade.
+func IsWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, v := range header.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Opens client connections like gorilla's Dialer, with simplewebsockets.Dial.
+type Dialer struct {
+	NetDialer        *net.Dialer
+	TLSClientConfig  *tls.Config
+	HandshakeTimeout time.Duration
+
+	// Offered in Sec-WebSocket-Protocol, the server picks one.
+	Subprotocols []string
+}
+
+// Dialer with default settings.
+var DefaultDialer = &Dialer{HandshakeTimeout: 45 * time.Second}
+
+// Connects to urlStr, sending requestHeader along with the upgrade request.
+func (d *Dialer) Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	return d.DialContext(context.Background(), urlStr, requestHeader)
+}
+
+// Connects to urlStr like Dial, giving up when ctx ends. The returned *http.Response is always nil, the
+// server's answer isn't kept.
+func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	if d == nil {
+		d = DefaultDialer
+	}
+	if d.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.HandshakeTimeout)
+		defer cancel()
+	}
+
+	opts := []simplewebsockets.DialOption{simplewebsockets.WithConnOptions(simplewebsockets.WithStreamReads())}
+	for key, values := range requestHeader {
+		for _, v := range values {
+			opts = append(opts, simplewebsockets.WithDialHeader(key, v))
+		}
+	}
+	if len(d.Subprotocols) > 0 {
+		opts = append(opts, simplewebsockets.WithDialSubprotocols(d.Subprotocols...))
+	}
+	if d.TLSClientConfig != nil {
+		opts = append(opts, simplewebsockets.WithDialTLSConfig(d.TLSClientConfig))
+	}
+	if d.NetDialer != nil {
+		opts = append(opts, simplewebsockets.WithDialer(d.NetDialer))
+	}
+
+	c, err := simplewebsockets.Dial(ctx, urlStr, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewConn(c), nil, nil
+}
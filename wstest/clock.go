@@ -0,0 +1,128 @@
+// Package wstest contains helpers for testing applications built on simplewebsockets.
+package wstest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+// Clock that only moves when Advance is called, for deterministic tests of timeouts and intervals.
+// Pass it to the server WithClock.
+type FakeClock struct {
+	mx     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// Creates a fake clock starting at start.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	when   time.Time
+	period time.Duration // non zero for tickers
+	fn     func()
+	ch     chan time.Time
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) simplewebsockets.Timer {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	t := &fakeTimer{clock: c, when: c.now.Add(d), fn: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) simplewebsockets.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	t := &fakeTimer{clock: c, when: c.now.Add(d), period: d, ch: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	return fakeTicker{t}
+}
+
+// Moves the clock forward by d, firing every timer and ticker that becomes due, in time order.
+// AfterFunc callbacks run on the calling goroutine.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mx.Lock()
+	target := c.now.Add(d)
+
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool {
+			return c.timers[i].when.Before(c.timers[j].when)
+		})
+		if len(c.timers) == 0 || c.timers[0].when.After(target) {
+			break
+		}
+
+		t := c.timers[0]
+		c.now = t.when
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+		} else {
+			c.timers = c.timers[1:]
+		}
+		now := c.now
+
+		// run outside the lock so callbacks can use the clock
+		c.mx.Unlock()
+		if t.ch != nil {
+			select {
+			case t.ch <- now:
+			default: // like time.Ticker, drop ticks for slow receivers
+			}
+		} else {
+			t.fn()
+		}
+		c.mx.Lock()
+	}
+
+	c.now = target
+	c.mx.Unlock()
+}
+
+func (t *fakeTimer) remove() bool {
+	c := t.clock
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Stop implements simplewebsockets.Timer.
+func (t *fakeTimer) Stop() bool {
+	return t.remove()
+}
+
+type fakeTicker struct {
+	t *fakeTimer
+}
+
+// C implements simplewebsockets.Ticker.
+func (t fakeTicker) C() <-chan time.Time {
+	return t.t.ch
+}
+
+// Stop implements simplewebsockets.Ticker.
+func (t fakeTicker) Stop() {
+	t.t.remove()
+}
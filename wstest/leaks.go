@@ -0,0 +1,49 @@
+package wstest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+// How long VerifyNoLeaks waits for goroutines that are already stopping.
+const leakGracePeriod = time.Second
+
+// Fails t if goroutines the library started during the test are still running when it ends, e.g. because a
+// server wasn't shut down or a client wasn't closed. Call it first thing in the test: goroutines running at
+// that point, such as those of servers shared between tests, aren't held against it. Cleanups registered
+// after it (and deferred calls of the test) run before the check.
+func VerifyNoLeaks(t testing.TB) {
+	t.Helper()
+	before := simplewebsockets.Goroutines()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(leakGracePeriod)
+		for {
+			leaked := leakedGoroutines(before, simplewebsockets.Goroutines())
+			if len(leaked) == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("simplewebsockets goroutines still running: %s", strings.Join(leaked, ", "))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+// describes the goroutines running now that weren't before, e.g. "keepalive x2"
+func leakedGoroutines(before, now map[string]int) []string {
+	var leaked []string
+	for name, n := range now {
+		if extra := n - before[name]; extra > 0 {
+			leaked = append(leaked, fmt.Sprintf("%s x%d", name, extra))
+		}
+	}
+	sort.Strings(leaked)
+	return leaked
+}
@@ -0,0 +1,276 @@
+package wstest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+// Runs many simulated clients against a server over in-memory connections, with a fake clock and
+// seeded randomness so failing runs can be replayed with the same seed.
+type Simulation struct {
+	Server *simplewebsockets.Server
+	Clock  *FakeClock
+	Seed   int64
+}
+
+// Creates a simulation with a fresh server using a FakeClock. opts are applied after the clock so they can override it.
+func NewSimulation(seed int64, opts ...simplewebsockets.ServerOption) *Simulation {
+	clock := NewFakeClock(time.Unix(0, 0))
+	opts = append([]simplewebsockets.ServerOption{simplewebsockets.WithClock(clock)}, opts...)
+	return &Simulation{
+		Server: simplewebsockets.NewServer(opts...),
+		Clock:  clock,
+		Seed:   seed,
+	}
+}
+
+// Opens an in-memory connection to the server and completes the handshake.
+func (sim *Simulation) Connect(r *rand.Rand) (*SimClient, error) {
+	clientConn, serverConn := net.Pipe()
+	go sim.Server.ServeConn(serverConn)
+
+	c := &SimClient{conn: clientConn, rand: r}
+	c.cond = sync.NewCond(&c.mx)
+	if err := c.handshake(); err != nil {
+		clientConn.Close()
+		return nil, err
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Runs n clients concurrently, each executing script with its own rand derived from the seed, and returns
+// the errors of the scripts that failed. Clients are closed abruptly when their script ends.
+func (sim *Simulation) Run(n int, script func(r *rand.Rand, c *SimClient) error) []error {
+	var (
+		wg   sync.WaitGroup
+		mx   sync.Mutex
+		errs []error
+	)
+
+	for i := 0; i < n; i++ {
+		r := rand.New(rand.NewSource(sim.Seed + int64(i)))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, err := sim.Connect(r)
+			if err == nil {
+				err = script(r, c)
+				c.Abort()
+			}
+			if err != nil {
+				mx.Lock()
+				errs = append(errs, fmt.Errorf("client %d: %w", i, err))
+				mx.Unlock()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	return errs
+}
+
+// Client side of a simulated connection. Frames sent by the server are collected in the background so
+// server writes never block on the synchronous in-memory pipe.
+type SimClient struct {
+	conn net.Conn
+	rand *rand.Rand
+
+	writeMx sync.Mutex
+
+	mx      sync.Mutex
+	cond    *sync.Cond
+	frames  []simplewebsockets.Frame
+	readErr error
+}
+
+func (c *SimClient) handshake() error {
+	req := "GET /sim HTTP/1.1\r\nHost: sim\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := c.conn.Write([]byte(req)); err != nil {
+		return err
+	}
+
+	// read the response headers one byte at a time so no frame bytes are consumed
+	var resp strings.Builder
+	b := make([]byte, 1)
+	for !strings.HasSuffix(resp.String(), "\r\n\r\n") {
+		if _, err := c.conn.Read(b); err != nil {
+			return err
+		}
+		resp.WriteByte(b[0])
+	}
+	if !strings.HasPrefix(resp.String(), "HTTP/1.1 101") {
+		return fmt.Errorf("handshake rejected: %q", strings.SplitN(resp.String(), "\r\n", 2)[0])
+	}
+	return nil
+}
+
+func (c *SimClient) readLoop() {
+	br := bufio.NewReader(c.conn)
+	for {
+		f, err := readFrame(br)
+		c.mx.Lock()
+		if err != nil {
+			c.readErr = err
+			c.cond.Broadcast()
+			c.mx.Unlock()
+			return
+		}
+		c.frames = append(c.frames, f)
+		c.cond.Broadcast()
+		c.mx.Unlock()
+	}
+}
+
+// reads an unmasked server frame
+func readFrame(r io.Reader) (simplewebsockets.Frame, error) {
+	h, err := simplewebsockets.ParseHeader(r)
+	if err != nil {
+		return simplewebsockets.Frame{}, err
+	}
+	f := simplewebsockets.Frame{
+		FIN:           h.FIN,
+		Opcode:        h.Opcode,
+		PayloadLength: h.PayloadLength,
+		Payload:       make([]byte, h.PayloadLength),
+	}
+	_, err = io.ReadFull(h.PayloadReader(r), f.Payload)
+	return f, err
+}
+
+// Writes a single masked frame.
+func (c *SimClient) SendFrame(opcode byte, payload []byte, fin bool) error {
+	var key [4]byte
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+	c.rand.Read(key[:])
+
+	f := simplewebsockets.NewFrame(opcode, payload, fin, true, key)
+	_, err := c.conn.Write(f.FrameToBytes())
+	return err
+}
+
+// Sends a text message in a single frame.
+func (c *SimClient) SendText(msg string) error {
+	return c.SendFrame(0x1, []byte(msg), true)
+}
+
+// Sends a binary message in a single frame.
+func (c *SimClient) SendBinary(msg []byte) error {
+	return c.SendFrame(0x2, msg, true)
+}
+
+// Sends a message split into the given fragments.
+func (c *SimClient) SendFragments(opcode byte, parts ...[]byte) error {
+	if len(parts) == 0 {
+		return c.SendFrame(opcode, nil, true)
+	}
+	for i, part := range parts {
+		op := opcode
+		if i > 0 {
+			op = 0x0
+		}
+		if err := c.SendFrame(op, part, i == len(parts)-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sends a ping.
+func (c *SimClient) Ping(payload []byte) error {
+	return c.SendFrame(0x9, payload, true)
+}
+
+// Starts the closing handshake.
+func (c *SimClient) Close(code uint16, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	return c.SendFrame(0x8, append(payload, reason...), true)
+}
+
+// Drops the connection without a closing handshake.
+func (c *SimClient) Abort() {
+	c.conn.Close()
+}
+
+// Returns the next frame from the server, waiting until one arrives or the connection fails.
+func (c *SimClient) NextFrame() (simplewebsockets.Frame, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	for len(c.frames) == 0 && c.readErr == nil {
+		c.cond.Wait()
+	}
+	if len(c.frames) == 0 {
+		return simplewebsockets.Frame{}, c.readErr
+	}
+	f := c.frames[0]
+	c.frames = c.frames[1:]
+	return f, nil
+}
+
+// Returns the next complete data message, skipping control frames.
+func (c *SimClient) NextMessage() (byte, []byte, error) {
+	var (
+		opcode byte
+		data   []byte
+	)
+	for {
+		f, err := c.NextFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		if f.Opcode >= 0x8 {
+			if f.Opcode == 0x8 {
+				return 0, nil, errors.New("connection closed by server")
+			}
+			continue
+		}
+		if f.Opcode != 0x0 {
+			opcode = f.Opcode
+		}
+		data = append(data, f.Payload...)
+		if f.FIN {
+			return opcode, data, nil
+		}
+	}
+}
+
+// Script that performs a random mix of messages, fragmented messages and pings, then either closes
+// cleanly or drops the connection. Useful to shake out races in close handling and shutdown.
+func Chaos(r *rand.Rand, c *SimClient) error {
+	steps := 1 + r.Intn(20)
+	for i := 0; i < steps; i++ {
+		var err error
+		switch r.Intn(4) {
+		case 0:
+			err = c.SendText(fmt.Sprintf("message %d", i))
+		case 1:
+			buf := make([]byte, r.Intn(512))
+			r.Read(buf)
+			err = c.SendBinary(buf)
+		case 2:
+			err = c.SendFragments(0x1, []byte("frag"), []byte("men"), []byte("ted"))
+		case 3:
+			err = c.Ping([]byte("sim"))
+		}
+		if err != nil {
+			return nil // the server may legitimately close us mid script
+		}
+	}
+
+	if r.Intn(2) == 0 {
+		c.Close(1000, "done")
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package wstest
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	simplewebsockets "github.com/CarsonKiibi/simplewebsockets"
+)
+
+// Creates a simulation whose server echoes every message back as text and reports ended connections.
+func echoSimulation(seed int64) (*Simulation, chan *simplewebsockets.Connection) {
+	sim := NewSimulation(seed)
+	sim.Server.OnMessageCtx(func(ctx context.Context, c *simplewebsockets.Connection, msg []byte) {
+		c.SendTextMessage(string(msg))
+	})
+	ended := make(chan *simplewebsockets.Connection, 128)
+	sim.Server.OnDisconnect(func(c *simplewebsockets.Connection) { ended <- c })
+	return sim, ended
+}
+
+func TestSimulationScriptedClient(t *testing.T) {
+	VerifyNoLeaks(t)
+	sim, ended := echoSimulation(1)
+	c, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Abort()
+
+	c.SendText("hello")
+	if op, msg, err := c.NextMessage(); err != nil || op != 0x1 || string(msg) != "hello" {
+		t.Fatalf("echo = %x %q %v, want text %q", op, msg, err, "hello")
+	}
+
+	c.SendFragments(0x2, []byte("frag"), []byte("men"), []byte("ted"))
+	if _, msg, err := c.NextMessage(); err != nil || string(msg) != "fragmented" {
+		t.Fatalf("echo = %q %v, want %q", msg, err, "fragmented")
+	}
+
+	c.Ping([]byte("sim"))
+	if f, err := c.NextFrame(); err != nil || f.Opcode != 0xA || string(f.Payload) != "sim" {
+		t.Fatalf("answer to ping = %x %q %v, want pong %q", f.Opcode, f.Payload, err, "sim")
+	}
+
+	c.Close(1000, "done")
+	f, err := c.NextFrame()
+	if err != nil || f.Opcode != 0x8 || binary.BigEndian.Uint16(f.Payload) != 1000 {
+		t.Fatalf("answer to close = %x % x %v, want close 1000", f.Opcode, f.Payload, err)
+	}
+	select {
+	case sc := <-ended:
+		if d, _ := sc.Disposition(); !d.Clean || d.Code != 1000 || d.Reason != "done" {
+			t.Errorf("disposition %+v, want a clean close with 1000 %q", d, "done")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection didn't end after the closing handshake")
+	}
+}
+
+// The server's timers run on the simulation's clock, so a close the client never answers only times out
+// once the clock is advanced.
+func TestSimulationFakeClock(t *testing.T) {
+	VerifyNoLeaks(t)
+	sim, ended := echoSimulation(2)
+	connected := make(chan *simplewebsockets.Connection, 1)
+	sim.Server.OnConnect(func(c *simplewebsockets.Connection) { connected <- c })
+
+	c, err := sim.Connect(rand.New(rand.NewSource(sim.Seed)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Abort()
+	sc := <-connected
+
+	sc.Close(1001, "going away")
+	if f, err := c.NextFrame(); err != nil || f.Opcode != 0x8 {
+		t.Fatalf("got %x %v, want the server's close frame", f.Opcode, err)
+	}
+
+	select {
+	case <-ended:
+		t.Fatal("connection ended before the close timed out")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	sim.Clock.Advance(5 * time.Second)
+	select {
+	case <-ended:
+		if d, _ := sc.Disposition(); d.Clean || d.Code != 1006 {
+			t.Errorf("disposition %+v, want an unclean close with 1006", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("close didn't time out after advancing the clock")
+	}
+}
+
+func TestSimulationChaos(t *testing.T) {
+	VerifyNoLeaks(t)
+	const clients = 100
+	sim, ended := echoSimulation(1966)
+
+	var scripts atomic.Int32
+	errs := sim.Run(clients, func(r *rand.Rand, c *SimClient) error {
+		scripts.Add(1)
+		return Chaos(r, c)
+	})
+	for _, err := range errs {
+		t.Error(err)
+	}
+	if n := scripts.Load(); n != clients {
+		t.Fatalf("%d scripts ran, want %d", n, clients)
+	}
+
+	// clients are aborted when their script ends, so every connection ends without the clock moving
+	for i := 0; i < clients; i++ {
+		select {
+		case <-ended:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d connections ended", i, clients)
+		}
+	}
+}